@@ -6,15 +6,17 @@ package gostlink
 
 import (
 	"errors"
+	"fmt"
 
 	"github.com/boljen/go-bitmap"
 )
 
-var (
-	openedAp = bitmap.New(debugAccessPortSelectionMaximum + 1)
-)
-
 func (h *StLink) usbOpenAccessPort(apsel uint16) error {
+	return h.openAccessPort(apsel, h.usbInitAccessPort)
+}
+
+// open apsel with init unless it was opened on this handle already
+func (h *StLink) openAccessPort(apsel uint16, init func(byte) error) error {
 
 	/* nothing to do on old versions */
 	if !h.version.flags.Get(flagHasApInit) {
@@ -25,27 +27,63 @@ func (h *StLink) usbOpenAccessPort(apsel uint16) error {
 		return errors.New("apsel > DP_APSEL_MAX")
 	}
 
-	if openedAp.Get(int(apsel)) {
+	if h.openedAp.Get(int(apsel)) {
 		return nil
 	}
 
-	err := h.usbInitAccessPort(byte(apsel))
+	err := init(byte(apsel))
 
 	if err != nil {
 		return err
 	}
 
-	logger.Debugf("Access port %d enabled", apsel)
-	openedAp.Set(int(apsel), true)
+	h.log().Debugf("Access port %d enabled", apsel)
+	h.openedAp.Set(int(apsel), true)
+	return nil
+}
+
+// Drop the initialized access ports, e.g. when the target was reset or replaced.
+// The next usbOpenAccessPort initializes them again.
+func (h *StLink) forgetAccessPorts() {
+	h.openedAp = bitmap.New(debugAccessPortSelectionMaximum + 1)
+}
+
+// initialize access port 0 and the active access port again
+func (h *StLink) reopenAccessPorts() error {
+	h.forgetAccessPorts()
+
+	if err := h.usbOpenAccessPort(0); err != nil {
+		return err
+	}
+
+	if h.activeAp != 0 {
+		return h.usbOpenAccessPort(uint16(h.activeAp))
+	}
+
 	return nil
 }
 
+// Initialize the access ports in use again. Call this after replacing the target
+// connected to the st-link, e.g. when flashing boards in sequence with the same
+// handle, as the access ports of the new target have not been initialized yet.
+func (h *StLink) ResetAccessPorts() error {
+	h, unlock := h.lock()
+	defer unlock()
+
+	if err := h.UsbModeEnter(h.stMode); err != nil {
+		return err
+	}
+	defer h.UsbLeaveMode(h.stMode)
+
+	return h.reopenAccessPorts()
+}
+
 func (h *StLink) usbInitAccessPort(apNum byte) error {
 	if !h.version.flags.Get(flagHasApInit) {
-		return errors.New("could not find access port command")
+		return fmt.Errorf("could not find access port command: %w", ErrNotSupported)
 	}
 
-	logger.Debugf("initialized access port # %d", apNum)
+	h.log().Debugf("initialized access port # %d", apNum)
 
 	ctx := h.initTransfer(transferIncoming)
 
@@ -56,9 +94,158 @@ func (h *StLink) usbInitAccessPort(apNum byte) error {
 	retVal := h.usbTransferErrCheck(ctx, 2)
 
 	if retVal != nil {
-		logger.Error("could not init access port over usb")
+		h.log().Error("could not init access port over usb")
 		return retVal
 	} else {
 		return nil
 	}
 }
+
+// Access port identification register and the CSW defaults used for memory access
+const (
+	apIdr = 0xfc
+
+	apIdrClassMemAp = 0x8
+
+	cswDbgSwEnable    = 1 << 31
+	cswAxiProtPriv    = 1 << 28
+	cswAxiCacheNormal = 0x3 << 24 // modifiable and bufferable, normal non-cacheable memory
+)
+
+// Bus type of a memory access port
+type AccessPortType int
+
+const (
+	AccessPortUnknown AccessPortType = iota // not a memory access port or not identified
+	AccessPortAhb
+	AccessPortApb
+	AccessPortAxi
+)
+
+func (t AccessPortType) String() string {
+	switch t {
+	case AccessPortAhb:
+		return "AHB-AP"
+	case AccessPortApb:
+		return "APB-AP"
+	case AccessPortAxi:
+		return "AXI-AP"
+	default:
+		return "unknown"
+	}
+}
+
+// access port type from the CLASS and TYPE fields of the IDR
+func decodeApIdr(idr uint32) AccessPortType {
+	if (idr>>13)&0xf != apIdrClassMemAp {
+		return AccessPortUnknown
+	}
+
+	switch idr & 0xf {
+	case 0x1, 0x5, 0x8:
+		return AccessPortAhb
+	case 0x2, 0x6:
+		return AccessPortApb
+	case 0x4, 0x7:
+		return AccessPortAxi
+	default:
+		return AccessPortUnknown
+	}
+}
+
+// CSW used for memory accesses through an access port of type t. AXI-APs (e.g.
+// on the Cortex-M7) need the transaction attributes set, otherwise the st-link
+// default may do accesses the memory system does not keep coherent.
+func apDefaultCsw(t AccessPortType) uint32 {
+	if t == AccessPortAxi {
+		return cswDbgSwEnable | cswAxiProtPriv | cswAxiCacheNormal
+	}
+
+	return 0
+}
+
+// Read the type of access port ap from its identification register
+func (h *StLink) ReadAccessPortType(ap uint8) (AccessPortType, error) {
+	h, unlock := h.lock()
+	defer unlock()
+
+	idr, err := h.usbReadDapRegister(uint16(ap), apIdr)
+
+	if err != nil {
+		return AccessPortUnknown, err
+	}
+
+	return decodeApIdr(idr), nil
+}
+
+// Override the CSW bits 31:8 (e.g. AXI cache and protection attributes) sent with
+// memory accesses through the active access port, 0 selects the st-link default.
+// The value is reset by SetActiveAP.
+func (h *StLink) SetAccessPortCsw(csw uint32) error {
+	h, unlock := h.lock()
+	defer unlock()
+
+	if !h.version.flags.Get(flagHasCsw) {
+		return fmt.Errorf("st-link firmware does not support setting CSW: %w", ErrNotSupported)
+	}
+
+	h.activeApCsw = csw &^ 0xff
+	return nil
+}
+
+// default CSW for the type of access port ap, 0 if it cannot be identified
+func (h *StLink) detectApCsw(ap uint8) uint32 {
+	if !h.version.flags.Get(flagHasCsw) || !h.version.flags.Get(flagHasDapReg) {
+		return 0
+	}
+
+	apType, err := h.ReadAccessPortType(ap)
+
+	if err != nil {
+		h.log().Warn("could not identify access port type: ", err)
+		return 0
+	}
+
+	h.log().Debugf("access port %d is %s", ap, apType)
+	return apDefaultCsw(apType)
+}
+
+// append access port selection and CSW of a memory access command
+func (h *StLink) writeApSelection(ctx *transferCtx) {
+	ctx.cmdBuf.WriteByte(h.activeAp)
+
+	if h.version.flags.Get(flagHasCsw) {
+		ctx.cmdBuf.WriteByte(byte(h.activeApCsw >> 8))
+		ctx.cmdBuf.WriteByte(byte(h.activeApCsw >> 16))
+		ctx.cmdBuf.WriteByte(byte(h.activeApCsw >> 24))
+	}
+}
+
+// Access port currently used for memory access
+func (h *StLink) ActiveAP() uint8 {
+	h, unlock := h.lock()
+	defer unlock()
+
+	return h.activeAp
+}
+
+// Select the access port used for all following memory accesses.
+// Access ports other than 0 require st-link firmware V2J32 / V3J2 or later.
+func (h *StLink) SetActiveAP(ap uint8) error {
+	h, unlock := h.lock()
+	defer unlock()
+
+	if ap != 0 && !h.version.flags.Get(flagHasCsw) {
+		return fmt.Errorf("st-link firmware does not support memory access on access ports other than 0: %w", ErrNotSupported)
+	}
+
+	if err := h.usbOpenAccessPort(uint16(ap)); err != nil {
+		return err
+	}
+
+	h.log().Debugf("using access port %d for memory access", ap)
+	h.activeAp = ap
+	h.activeApCsw = h.detectApCsw(ap)
+
+	return nil
+}
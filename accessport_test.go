@@ -0,0 +1,71 @@
+// Copyright 2021 juju2013@github. All rights reserved.
+// Use of this source code is governed by a GNU-style
+// license that can be found in the LICENSE file.
+
+package gostlink
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/boljen/go-bitmap"
+)
+
+// handle of a probe supporting access port initialization, without usb device
+func newAccessPortTestHandle() *StLink {
+	h := &StLink{stLinkState: &stLinkState{}}
+
+	h.openedAp = bitmap.New(debugAccessPortSelectionMaximum + 1)
+	h.version.flags = bitmap.New(32)
+	h.version.flags.Set(flagHasApInit, true)
+
+	return h
+}
+
+func TestAccessPortsPerHandle(t *testing.T) {
+	first := newAccessPortTestHandle()
+	second := newAccessPortTestHandle()
+
+	var initsFirst, initsSecond []byte
+	var wg sync.WaitGroup
+
+	wg.Add(2)
+
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 100; i++ {
+			first.openAccessPort(1, func(ap byte) error { initsFirst = append(initsFirst, ap); return nil })
+		}
+	}()
+
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 100; i++ {
+			second.openAccessPort(2, func(ap byte) error { initsSecond = append(initsSecond, ap); return nil })
+		}
+	}()
+
+	wg.Wait()
+
+	if len(initsFirst) != 1 || initsFirst[0] != 1 {
+		t.Errorf("first handle initialized access ports %v, expected [1]", initsFirst)
+	}
+
+	if len(initsSecond) != 1 || initsSecond[0] != 2 {
+		t.Errorf("second handle initialized access ports %v, expected [2]", initsSecond)
+	}
+
+	if !first.openedAp.Get(1) || first.openedAp.Get(2) {
+		t.Error("access ports of the second handle show up on the first one")
+	}
+
+	if !second.openedAp.Get(2) || second.openedAp.Get(1) {
+		t.Error("access ports of the first handle show up on the second one")
+	}
+
+	first.forgetAccessPorts()
+
+	if !second.openedAp.Get(2) {
+		t.Error("forgetting the access ports of one handle dropped those of the other")
+	}
+}
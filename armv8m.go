@@ -0,0 +1,124 @@
+// Copyright 2021 juju2013@github. All rights reserved.
+// Use of this source code is governed by a GNU-style
+// license that can be found in the LICENSE file.
+
+package gostlink
+
+import (
+	"fmt"
+)
+
+// CPUID part numbers of ARMv8-M cores
+const (
+	cpuIdPartNoShift = 4
+	cpuIdPartNoMask  = 0xfff
+
+	cpuIdPartCortexM23  = 0xD20
+	cpuIdPartCortexM33  = 0xD21
+	cpuIdPartCortexM55  = 0xD22
+	cpuIdPartCortexM85  = 0xD23
+	cpuIdPartCortexM35P = 0xD31
+)
+
+// register indexes of the ARMv8-M stack limit registers. Without security
+// extension the limits are at the non-secure indexes.
+const (
+	registerMainSPLimitSecure       = 0x1C
+	registerProcessSPLimitSecure    = 0x1D
+	registerMainSPLimitNonSecure    = 0x1E
+	registerProcessSPLimitNonSecure = 0x1F
+)
+
+// ARMv8-M stack pointer limit registers
+type StackLimits struct {
+	MainSPLimit    uint32 // MSPLIM
+	ProcessSPLimit uint32 // PSPLIM
+}
+
+func cpuIdIsArmV8M(cpuid uint32) bool {
+	switch (cpuid >> cpuIdPartNoShift) & cpuIdPartNoMask {
+	case cpuIdPartCortexM23, cpuIdPartCortexM33, cpuIdPartCortexM55, cpuIdPartCortexM85, cpuIdPartCortexM35P:
+		return true
+	default:
+		return false
+	}
+}
+
+// check the core for ARMv8-M by its CPUID part number
+func (h *StLink) isArmV8M() (bool, error) {
+	cpuid, err := h.readDebugReg(cpuIdBaseRegister)
+
+	if err != nil {
+		return false, err
+	}
+
+	return cpuIdIsArmV8M(cpuid), nil
+}
+
+// Read the stack pointer limits MSPLIM and PSPLIM of an ARMv8-M core, fails
+// with ErrNotSupported on older cores. On cores with security extension the
+// limits of the security state the core is in are returned. Cortex-M23 without
+// security extension does not implement the limit registers and reads them as zero.
+func (h *StLink) ReadStackLimits() (*StackLimits, error) {
+	h, unlock := h.lock()
+	defer unlock()
+
+	if err := h.UsbModeEnter(h.stMode); err != nil {
+		return nil, err
+	}
+	defer h.UsbLeaveMode(h.stMode)
+
+	v8m, err := h.isArmV8M()
+
+	if err != nil {
+		return nil, err
+	}
+
+	if !v8m {
+		return nil, fmt.Errorf("stack limit registers require an ARMv8-M core: %w", ErrNotSupported)
+	}
+
+	if err = h.requireHalted(); err != nil {
+		return nil, err
+	}
+
+	mainLimit, processLimit, err := h.stackLimitRegisters()
+
+	if err != nil {
+		return nil, err
+	}
+
+	limits := &StackLimits{}
+
+	if limits.MainSPLimit, err = h.readRegister(mainLimit); err != nil {
+		return nil, err
+	}
+
+	if limits.ProcessSPLimit, err = h.readRegister(processLimit); err != nil {
+		return nil, err
+	}
+
+	return limits, nil
+}
+
+// register indexes of MSPLIM and PSPLIM of the state the core is in, the
+// secure ones only on a core with security extension running in secure state
+func (h *StLink) stackLimitRegisters() (uint8, uint8, error) {
+	security, err := h.hasSecurityExtension()
+
+	if err != nil || !security {
+		return registerMainSPLimitNonSecure, registerProcessSPLimitNonSecure, err
+	}
+
+	dscsr, err := h.readDebugReg(dcbDscsr)
+
+	if err != nil {
+		return 0, 0, err
+	}
+
+	if (dscsr & dscsrCds) > 0 {
+		return registerMainSPLimitSecure, registerProcessSPLimitSecure, nil
+	}
+
+	return registerMainSPLimitNonSecure, registerProcessSPLimitNonSecure, nil
+}
@@ -0,0 +1,240 @@
+// Copyright 2021 juju2013@github. All rights reserved.
+// Use of this source code is governed by a GNU-style
+// license that can be found in the LICENSE file.
+
+package gostlink
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Cortex-M flash patch and breakpoint unit
+const (
+	fpbCtrl  = 0xE0002000
+	fpbComp0 = 0xE0002008
+
+	fpbCtrlEnable = 1 << 0
+	fpbCtrlKey    = 1 << 1
+
+	fpbCompEnable       = 1 << 0
+	fpbCompReplaceLower = 1 << 30 // v1: break on the lower halfword of the word
+	fpbCompReplaceUpper = 2 << 30 // v1: break on the upper halfword of the word
+	fpbCompV1AddrMask   = 0x1ffffffc
+	fpbV1MaxAddr        = 0x20000000 // v1 comparators only cover the code region
+)
+
+type fpbInfo struct {
+	revision    uint32
+	comparators int
+}
+
+func (h *StLink) readFpbInfo() (fpbInfo, error) {
+	ctrl, err := h.readDebugReg(fpbCtrl)
+
+	if err != nil {
+		return fpbInfo{}, err
+	}
+
+	return fpbInfo{
+		revision:    (ctrl >> 28) & 0xf,
+		comparators: int(((ctrl >> 8) & 0x70) | ((ctrl >> 4) & 0xf)),
+	}, nil
+}
+
+// comparator value matching a breakpoint on addr
+func (fpb fpbInfo) compValue(addr uint32) (uint32, error) {
+	if fpb.revision > 0 {
+		return (addr &^ 1) | fpbCompEnable, nil
+	}
+
+	if addr >= fpbV1MaxAddr {
+		return 0, fmt.Errorf("breakpoint address 0x%08x is outside the code region", addr)
+	}
+
+	replace := uint32(fpbCompReplaceLower)
+
+	if (addr & 2) > 0 {
+		replace = fpbCompReplaceUpper
+	}
+
+	return replace | (addr & fpbCompV1AddrMask) | fpbCompEnable, nil
+}
+
+// set a comparator breaking at addr, reports whether it was allocated by this
+// call or was set already
+func (h *StLink) setBreakpoint(addr uint32) (bool, error) {
+	fpb, err := h.readFpbInfo()
+
+	if err != nil {
+		return false, err
+	}
+
+	value, err := fpb.compValue(addr)
+
+	if err != nil {
+		return false, err
+	}
+
+	free := -1
+
+	for i := 0; i < fpb.comparators; i++ {
+		comp, err := h.readDebugReg(fpbComp0 + uint32(i)*4)
+
+		if err != nil {
+			return false, err
+		}
+
+		if comp == value {
+			// already set
+			return false, nil
+		}
+
+		if (comp&fpbCompEnable) == 0 && free < 0 {
+			free = i
+		}
+	}
+
+	if free < 0 {
+		return false, errors.New("no free hardware breakpoint available")
+	}
+
+	if err = h.writeDebugReg(fpbComp0+uint32(free)*4, value); err != nil {
+		return false, err
+	}
+
+	return true, h.writeDebugReg(fpbCtrl, fpbCtrlKey|fpbCtrlEnable)
+}
+
+func (h *StLink) clearBreakpoint(addr uint32) error {
+	fpb, err := h.readFpbInfo()
+
+	if err != nil {
+		return err
+	}
+
+	value, err := fpb.compValue(addr)
+
+	if err != nil {
+		return err
+	}
+
+	for i := 0; i < fpb.comparators; i++ {
+		comp, err := h.readDebugReg(fpbComp0 + uint32(i)*4)
+
+		if err != nil {
+			return err
+		}
+
+		if comp == value {
+			return h.writeDebugReg(fpbComp0+uint32(i)*4, 0)
+		}
+	}
+
+	return fmt.Errorf("no hardware breakpoint set at 0x%08x", addr)
+}
+
+// Set a hardware breakpoint at addr using a free FPB comparator
+func (h *StLink) SetHardwareBreakpoint(addr uint32) error {
+	h, unlock := h.lock()
+	defer unlock()
+
+	if err := h.UsbModeEnter(h.stMode); err != nil {
+		return err
+	}
+	defer h.UsbLeaveMode(h.stMode)
+
+	_, err := h.setBreakpoint(addr)
+	return err
+}
+
+// Remove the hardware breakpoint at addr
+func (h *StLink) ClearHardwareBreakpoint(addr uint32) error {
+	h, unlock := h.lock()
+	defer unlock()
+
+	if err := h.UsbModeEnter(h.stMode); err != nil {
+		return err
+	}
+	defer h.UsbLeaveMode(h.stMode)
+
+	return h.clearBreakpoint(addr)
+}
+
+// Resume the core and run until it reaches addr, using a temporary
+// hardware breakpoint. The core is halted again if addr is not reached within timeout.
+func (h *StLink) RunToAddress(addr uint32, timeout time.Duration) error {
+	h, unlock := h.lock()
+	defer unlock()
+
+	if err := h.UsbModeEnter(h.stMode); err != nil {
+		return err
+	}
+	defer h.UsbLeaveMode(h.stMode)
+
+	if halted, err := h.usbCoreHalted(); err != nil {
+		return err
+	} else if halted {
+		pc, err := h.readRegister(registerPC)
+
+		if err != nil {
+			return err
+		}
+
+		if pc == addr&^1 {
+			return nil
+		}
+	}
+
+	created, err := h.setBreakpoint(addr)
+
+	if err != nil {
+		return err
+	}
+
+	runErr := h.runUntilHalted(timeout)
+
+	// a breakpoint the user set at addr before is kept
+	if created {
+		if err := h.clearBreakpoint(addr); err != nil {
+			return err
+		}
+	}
+
+	if runErr != nil {
+		return runErr
+	}
+
+	pc, err := h.readRegister(registerPC)
+
+	if err != nil {
+		return err
+	}
+
+	if pc != addr&^1 {
+		return fmt.Errorf("target halted at 0x%08x before reaching 0x%08x", pc, addr)
+	}
+
+	return nil
+}
+
+// resume the core and wait for the next halt, halting it on timeout
+func (h *StLink) runUntilHalted(timeout time.Duration) error {
+	if err := h.writeDebugReg(scbDfsr, dfsrAll); err != nil {
+		return err
+	}
+
+	if err := h.usbRun(); err != nil {
+		return err
+	}
+
+	if err := h.waitHalted(timeout); err != nil {
+		h.usbHalt()
+		h.waitHalted(time.Second)
+
+		return err
+	}
+
+	return nil
+}
@@ -21,6 +21,16 @@ const (
 	bigEndian           = 1
 )
 
+// exported byte orders for interpreting target data
+const (
+	LittleEndian Endian = littleEndian
+	BigEndian    Endian = bigEndian
+)
+
+func (e Endian) String() string {
+	return e.toString()
+}
+
 func (e Endian) toString() string {
 	if e == littleEndian {
 		return "little endian"
@@ -49,6 +59,18 @@ func (buf *Buffer) WriteUint16LE(value uint16) {
 	buf.WriteByte(byte(value >> 8))
 }
 
+func (buf *Buffer) WriteUint32BE(value uint32) {
+	buf.WriteByte(byte(value >> 24))
+	buf.WriteByte(byte(value >> 16))
+	buf.WriteByte(byte(value >> 8))
+	buf.WriteByte(byte(value))
+}
+
+func (buf *Buffer) WriteUint16BE(value uint16) {
+	buf.WriteByte(byte(value >> 8))
+	buf.WriteByte(byte(value))
+}
+
 // Read next 2 bytes as unsigned integer Big Endian
 // !!! This differe from original source which buffer pointer is not advenced (ie. doest not Read from Buffer)
 func (buf *Buffer) ReadUint16BE() uint16 {
@@ -82,8 +104,7 @@ func convertToUint16(buf []byte, e Endian) uint16 {
 			return uint16(buf[1]) | (uint16(buf[0]) << 8)
 		}
 	} else {
-		logger.Errorf("could not read uint16 %s from given buffer", e.toString())
-//debug.PrintStack()
+		// short buffer, all ones marks the value as invalid
 		return math.MaxUint16
 	}
 }
@@ -97,7 +118,7 @@ func convertToUint32(buf []byte, e Endian) uint32 {
 			return uint32(buf[3]) | (uint32(buf[2]) << 8) | (uint32(buf[1]) << 16) | (uint32(buf[0]) << 24)
 		}
 	} else {
-		logger.Errorf("could not read uint32 %s from given buffer", e.toString())
+		// short buffer, all ones marks the value as invalid
 		return math.MaxUint32
 	}
 }
@@ -0,0 +1,114 @@
+// Copyright 2021 juju2013@github. All rights reserved.
+// Use of this source code is governed by a GNU-style
+// license that can be found in the LICENSE file.
+
+package gostlink
+
+import (
+	"errors"
+	"time"
+)
+
+const (
+	callFunctionTimeout = 5 * time.Second
+	callMaxArguments    = 4 // arguments passed in R0-R3 by the AAPCS
+
+	thumbBreakpoints = 0xBE00BE00 // two "bkpt #0" instructions
+	xpsrThumb        = 1 << 24
+)
+
+// Save all core registers, see RestoreContext
+func (h *StLink) SaveContext() (*TargetRegisters, error) {
+	h, unlock := h.lock()
+	defer unlock()
+
+	return h.GetRegisters()
+}
+
+// Restore core registers previously saved by SaveContext
+func (h *StLink) RestoreContext(regs *TargetRegisters) error {
+	h, unlock := h.lock()
+	defer unlock()
+
+	return h.WriteRegisters(regs)
+}
+
+// Call the target function at entry with up to four arguments and return
+// its result (R0). The core is halted, the function runs until it returns
+// onto a breakpoint placed on the target's stack, then all registers are restored.
+func (h *StLink) CallFunction(entry uint32, args []uint32) (uint32, error) {
+	h, unlock := h.lock()
+	defer unlock()
+
+	if len(args) > callMaxArguments {
+		return 0, errors.New("only up to 4 function arguments are supported")
+	}
+
+	if err := h.UsbModeEnter(h.stMode); err != nil {
+		return 0, err
+	}
+	defer h.UsbLeaveMode(h.stMode)
+
+	if err := h.ensureHalted(); err != nil {
+		return 0, err
+	}
+
+	saved, err := h.readRegisters()
+
+	if err != nil {
+		return 0, err
+	}
+
+	result, callErr := h.callFunction(saved, entry, args)
+
+	if err := h.writeRegisters(saved); err != nil {
+		return 0, err
+	}
+
+	return result, callErr
+}
+
+func (h *StLink) callFunction(saved *TargetRegisters, entry uint32, args []uint32) (uint32, error) {
+	// reserve room for the return trampoline below the current stack
+	// pointer, keeping the stack 8 byte aligned as required by the AAPCS
+	sp := (saved.R[registerSP] - 8) &^ 7
+
+	if err := h.writeDebugReg(sp, thumbBreakpoints); err != nil {
+		return 0, err
+	}
+
+	for i, arg := range args {
+		if err := h.writeRegister(uint8(i), arg); err != nil {
+			return 0, err
+		}
+	}
+
+	if err := h.writeRegister(registerSP, sp); err != nil {
+		return 0, err
+	}
+	if err := h.writeRegister(registerLR, sp|1); err != nil {
+		return 0, err
+	}
+	if err := h.writeRegister(registerPC, entry&^1); err != nil {
+		return 0, err
+	}
+	if err := h.writeRegister(registerXPSR, xpsrThumb); err != nil {
+		return 0, err
+	}
+
+	if err := h.runUntilHalted(callFunctionTimeout); err != nil {
+		return 0, err
+	}
+
+	pc, err := h.readRegister(registerPC)
+
+	if err != nil {
+		return 0, err
+	}
+
+	if pc != sp {
+		return 0, errors.New("target halted before function returned")
+	}
+
+	return h.readRegister(0)
+}
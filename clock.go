@@ -0,0 +1,133 @@
+// Copyright 2021 juju2013@github. All rights reserved.
+// Use of this source code is governed by a GNU-style
+// license that can be found in the LICENSE file.
+
+package gostlink
+
+import (
+	"errors"
+	"fmt"
+)
+
+// RCC of STM32F2/F4/F7 parts
+const (
+	rccF4Pllcfgr = 0x40023804
+	rccF4Cfgr    = 0x40023808
+
+	rccF4HsiHz = 16000000
+
+	rccCfgrSwsShift  = 2
+	rccCfgrSwsMask   = 0x3
+	rccCfgrHpreShift = 4
+	rccCfgrHpreMask  = 0xf
+
+	rccSwsHsi = 0
+	rccSwsHse = 1
+	rccSwsPll = 2
+
+	rccPllcfgrPllmMask  = 0x3f
+	rccPllcfgrPllnShift = 6
+	rccPllcfgrPllnMask  = 0x1ff
+	rccPllcfgrPllpShift = 16
+	rccPllcfgrPllpMask  = 0x3
+	rccPllcfgrPllSrcHse = 1 << 22
+)
+
+// Frequency of the external oscillator in Hz, required by DetectCoreClock
+// when the target clock is derived from HSE.
+func (config *StLinkInterfaceConfig) SetHseFrequency(hz uint32) {
+	config.hseHz = hz
+}
+
+// Compute the core clock (HCLK) from the RCC configuration, e.g. as trace clock
+// input for ConfigTrace. Supported for STM32F2, F4 and F7 devices.
+func (h *StLink) DetectCoreClock() (uint32, error) {
+	h, unlock := h.lock()
+	defer unlock()
+
+	device, err := h.IdentifyDevice()
+
+	if err != nil {
+		return 0, err
+	}
+
+	switch device.family {
+	case stm32FamilyF2, stm32FamilyF4, stm32FamilyF7:
+		return h.detectCoreClockF4()
+	default:
+		return 0, fmt.Errorf("core clock detection not supported for %s: %w", device.Family, ErrNotSupported)
+	}
+}
+
+func (h *StLink) detectCoreClockF4() (uint32, error) {
+	cfgr, err := h.readDebugReg(rccF4Cfgr)
+
+	if err != nil {
+		return 0, err
+	}
+
+	var sysclk uint64
+
+	switch (cfgr >> rccCfgrSwsShift) & rccCfgrSwsMask {
+	case rccSwsHsi:
+		sysclk = rccF4HsiHz
+
+	case rccSwsHse:
+		if sysclk, err = h.hseFrequency(); err != nil {
+			return 0, err
+		}
+
+	case rccSwsPll:
+		pllcfgr, err := h.readDebugReg(rccF4Pllcfgr)
+
+		if err != nil {
+			return 0, err
+		}
+
+		var input uint64 = rccF4HsiHz
+
+		if (pllcfgr & rccPllcfgrPllSrcHse) > 0 {
+			if input, err = h.hseFrequency(); err != nil {
+				return 0, err
+			}
+		}
+
+		pllm := uint64(pllcfgr & rccPllcfgrPllmMask)
+		plln := uint64((pllcfgr >> rccPllcfgrPllnShift) & rccPllcfgrPllnMask)
+		pllp := uint64(((pllcfgr>>rccPllcfgrPllpShift)&rccPllcfgrPllpMask)+1) * 2
+
+		if pllm == 0 {
+			return 0, fmt.Errorf("invalid pll configuration 0x%08x", pllcfgr)
+		}
+
+		sysclk = input * plln / pllm / pllp
+
+	default:
+		return 0, fmt.Errorf("invalid system clock source in RCC_CFGR 0x%08x", cfgr)
+	}
+
+	// AHB prescaler, 0xxx: not divided, 1000-1011: 2-16, 1100-1111: 64-512
+	hpre := (cfgr >> rccCfgrHpreShift) & rccCfgrHpreMask
+
+	if hpre >= 8 {
+		shift := hpre - 7
+
+		if hpre >= 12 {
+			shift++
+		}
+
+		sysclk >>= shift
+	}
+
+	h.log().Debugf("detected core clock %d Hz", sysclk)
+
+	return uint32(sysclk), nil
+}
+
+func (h *StLink) hseFrequency() (uint64, error) {
+	if h.config.hseHz == 0 {
+		return 0, errors.New("core clock is derived from HSE, set its frequency with SetHseFrequency")
+	}
+
+	return uint64(h.config.hseHz), nil
+}
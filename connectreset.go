@@ -0,0 +1,75 @@
+// Copyright 2021 juju2013@github. All rights reserved.
+// Use of this source code is governed by a GNU-style
+// license that can be found in the LICENSE file.
+
+package gostlink
+
+import (
+	"fmt"
+	"time"
+)
+
+const demcrVcCoreReset = 1 << 0 // halt on the reset vector
+
+// Reset applied when connecting under reset
+type ConnectReset int
+
+const (
+	// Hold the NRST line while entering debug mode (default). All st-link
+	// generations, including the V3, drive NRST with the same DRIVE_NRST command.
+	ConnectResetHardware ConnectReset = iota
+	// Reset through AIRCR.SYSRESETREQ once connected and halt on the reset
+	// vector, for boards without NRST wired to the st-link.
+	ConnectResetSoftware
+)
+
+func (r ConnectReset) String() string {
+	switch r {
+	case ConnectResetHardware:
+		return "hardware"
+	case ConnectResetSoftware:
+		return "software"
+	default:
+		return "unknown"
+	}
+}
+
+// Select how the target is reset when connecting under reset
+func (config *StLinkInterfaceConfig) SetConnectReset(reset ConnectReset) {
+	config.connectReset = reset
+}
+
+// Reset the target with the debug connection established and catch the core on
+// the reset vector, so no code runs before the debugger takes over.
+func (h *StLink) connectSoftwareReset() error {
+	if err := h.ensureHalted(); err != nil {
+		return err
+	}
+
+	demcr, err := h.readDebugReg(dcbDemcr)
+
+	if err != nil {
+		return err
+	}
+
+	if err = h.writeDebugReg(dcbDemcr, demcr|demcrVcCoreReset); err != nil {
+		return err
+	}
+
+	resetErr := h.systemReset()
+
+	if resetErr == nil {
+		resetErr = h.waitHalted(time.Second)
+	}
+
+	if err = h.writeDebugReg(dcbDemcr, demcr); err != nil {
+		return err
+	}
+
+	if resetErr != nil {
+		return fmt.Errorf("software reset on connect failed: %w", resetErr)
+	}
+
+	h.log().Debug("target reset on connect, halted on reset vector")
+	return nil
+}
@@ -18,6 +18,7 @@ const (
 	StLinkModeDebugJtag            = 3
 	StLinkModeDebugSwd             = 4
 	StLinkModeDebugSwim            = 5
+	StLinkModeAuto                 = 6 // select the transport by st-link capabilities when opening
 )
 
 type MemoryBlockSize int // block size for read and write operations
@@ -42,6 +43,8 @@ const (
 	flagHasDpBankSel        = 0x09
 	flagHasRw8Bytes512      = 0x0a
 	flagFixCloseAp          = 0x0b
+	flagHasCsw              = 0x0c
+	flagHasMemRdNoInc       = 0x0d
 )
 
 type stLinkApiVersion uint8 // api versions of stlinks
@@ -141,6 +144,10 @@ const (
 	stLinkV3EPid         = 0x374E
 	stLinkV3SPid         = 0x374F
 	stLinkV32VcpPid      = 0x3753
+	stLinkV3PwrPid       = 0x3757
+
+	usbClassCdcComm = 0x02
+	usbClassCdcData = 0x0a
 )
 
 const (
@@ -198,6 +205,7 @@ const (
 	debugApiV2WriteMem16Bit                = 0x48
 	debugApiV2InitAccessPort               = 0x4B
 	debugApiV2CloseAccessPortDbg           = 0x4C
+	debugApiV2ReadMem32BitNoAddrInc        = 0x54
 	//STLINK_DEBUG_APIV2_DRIVE_NRST_LOW    = 0x00
 	//STLINK_DEBUG_APIV2_DRIVE_NRST_HIGH   = 0x01
 	//STLINK_DEBUG_APIV2_DRIVE_NRST_PULSE  = 0x02
@@ -215,19 +223,19 @@ const (
 )
 
 const (
-	swimEnter = 0x00
-	swimExit  = 0x01
-	//STLINK_SWIM_READ_CAP       = 0x02
-	//STLINK_SWIM_SPEED          = 0x03
-	//STLINK_SWIM_ENTER_SEQ      = 0x04
-	//STLINK_SWIM_GEN_RST        = 0x05
-	//STLINK_SWIM_RESET          = 0x06
-	//STLINK_SWIM_ASSERT_RESET   = 0x07
-	//STLINK_SWIM_DEASSERT_RESET = 0x08
-	//STLINK_SWIM_READSTATUS     = 0x09
-	//STLINK_SWIM_WRITEMEM       = 0x0a
-	//STLINK_SWIM_READMEM        = 0x0b
-	//STLINK_SWIM_READBUF        = 0x0c
+	swimEnter         = 0x00
+	swimExit          = 0x01
+	swimReadCap       = 0x02
+	swimSpeed         = 0x03
+	swimEnterSeq      = 0x04
+	swimGenRst        = 0x05
+	swimReset         = 0x06
+	swimAssertReset   = 0x07
+	swimDeassertReset = 0x08
+	swimReadStatus    = 0x09
+	swimWriteMem      = 0x0a
+	swimReadMem       = 0x0b
+	swimReadBuf       = 0x0c
 )
 
 const (
@@ -244,14 +252,18 @@ const (
 	v3MaxReadWrite8 = 512
 	v3MaxFreqNb     = 10
 
-	cmdBufferSize  = 31
-	dataBufferSize = 4096
+	cmdBufferSize    = 31
+	dataBufferSize   = 4096
+	v3DataBufferSize = 6144 // largest data stage of a STLINK-V3
+	minDataBuffer    = 64
 	//cmdSizeV1        = 10
 	cmdSizeV2 = 16
 
 	traceSize  = 4096
 	traceMaxHz = 2000000
 
+	minTargetVoltage = 1.5 // below this voltage debugging is not reliable
+
 	//STLINK_DEBUG_PORT_ACCESS = 0xffff
 	//STLINK_SERIAL_LEN  = 24
 )
@@ -0,0 +1,102 @@
+// Copyright 2021 juju2013@github. All rights reserved.
+// Use of this source code is governed by a GNU-style
+// license that can be found in the LICENSE file.
+
+package gostlink
+
+import (
+	"bytes"
+	"fmt"
+)
+
+// CoreSight identification registers, relative to the 4kB component base
+const (
+	csPidr4           = 0xFD0 // PIDR4-PIDR7, followed by PIDR0-PIDR3 and CIDR0-CIDR3
+	csIdRegisterCount = 12
+
+	csCidPreamble  = 0xB105000D // CIDR0-CIDR3 without the component class
+	csCidClassMask = 0x0000F000
+
+	jep106Arm = 0x43B // continuation code 4, identity 0x3B
+)
+
+// Component classes as found in CIDR1
+const (
+	ComponentClassVerification = 0x0
+	ComponentClassRomTable     = 0x1
+	ComponentClassCoreSight    = 0x9
+	ComponentClassPeripheral   = 0xB
+	ComponentClassGenericIP    = 0xE
+	ComponentClassPrimeCell    = 0xF
+)
+
+// Decoded CoreSight component and peripheral id of a component
+type ComponentID struct {
+	Base     uint32 // component base address
+	CID      uint32 // CIDR0-CIDR3
+	PID      uint64 // PIDR0-PIDR7
+	Class    uint8  // component class, see ComponentClass constants
+	Designer uint16 // JEP106 continuation code (bits 11:8) and identity code (bits 6:0)
+	Part     uint16 // part number defined by the designer
+	Revision uint8
+	Size     uint32 // size of the component in bytes
+}
+
+// The component was designed by ARM
+func (id ComponentID) IsArm() bool {
+	return id.Designer == jep106Arm
+}
+
+// Read and decode the component and peripheral id registers of the CoreSight
+// component at base, which has to be 4kB aligned.
+func (h *StLink) ReadComponentID(base uint32) (ComponentID, error) {
+	h, unlock := h.lock()
+	defer unlock()
+
+	id := ComponentID{Base: base}
+
+	if base&0xFFF != 0 {
+		return id, fmt.Errorf("component base 0x%08x is not 4kB aligned: %w", base, ErrUnalignedAccess)
+	}
+
+	buffer := bytes.NewBuffer([]byte{})
+
+	if err := h.ReadMem(base+csPidr4, Memory32BitBlock, csIdRegisterCount, buffer); err != nil {
+		return id, err
+	}
+
+	data := buffer.Bytes()
+
+	if len(data) < csIdRegisterCount*4 {
+		return id, fmt.Errorf("short read of %d bytes", len(data))
+	}
+
+	// only the lowest byte of each id register is used, registers are always little endian
+	var idBytes [csIdRegisterCount]byte
+
+	for i := range idBytes {
+		idBytes[i] = data[i*4]
+	}
+
+	pid := idBytes[4:8] // PIDR0-PIDR3
+	pid4 := idBytes[0]
+
+	for i, b := range idBytes[8:12] {
+		id.CID |= uint32(b) << (8 * uint(i))
+	}
+	for i := 0; i < 4; i++ {
+		id.PID |= uint64(pid[i])<<(8*uint(i)) | uint64(idBytes[i])<<(32+8*uint(i))
+	}
+
+	if id.CID&^csCidClassMask != csCidPreamble {
+		return id, fmt.Errorf("no CoreSight component at 0x%08x (cid 0x%08x)", base, id.CID)
+	}
+
+	id.Class = uint8((id.CID & csCidClassMask) >> 12)
+	id.Part = uint16(pid[0]) | uint16(pid[1]&0x0f)<<8
+	id.Designer = uint16(pid[1]>>4) | uint16(pid[2]&0x07)<<4 | uint16(pid4&0x0f)<<8
+	id.Revision = pid[2] >> 4
+	id.Size = 4096 << (pid4 >> 4)
+
+	return id, nil
+}
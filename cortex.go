@@ -0,0 +1,202 @@
+// Copyright 2021 juju2013@github. All rights reserved.
+// Use of this source code is governed by a GNU-style
+// license that can be found in the LICENSE file.
+
+package gostlink
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+)
+
+// Cortex-M system control block registers
+const (
+	scbVtor  = 0xE000ED08
+	scbAircr = 0xE000ED0C
+
+	aircrEndianness = 1 << 15
+
+	vtorAlignMask = 0x7f // the table must be aligned to at least 128 bytes
+)
+
+// Read a single 32bit word of the target's memory space in one usb transaction
+func (h *StLink) readDebugReg(addr uint32) (uint32, error) {
+	if h.version.jtagApi == jTagApiV1 {
+		return 0, fmt.Errorf("read debug register not supported by jtag api v1: %w", ErrNotSupported)
+	}
+
+	ctx := h.initTransfer(transferIncoming)
+
+	ctx.cmdBuf.WriteByte(cmdDebug)
+	ctx.cmdBuf.WriteByte(debugApiV2ReadDebugReg)
+	ctx.cmdBuf.WriteUint32LE(addr)
+
+	err := h.usbTransferErrCheck(ctx, 8)
+
+	// no data was received if the usb transfer itself failed
+	if err != nil && len(ctx.DataBytes()) < 8 {
+		return 0, err
+	}
+
+	if err = h.checkStuckRead(ctx.DataBytes()[4:8], err); err != nil {
+		return 0, err
+	}
+
+	return convertToUint32(ctx.DataBytes()[4:], littleEndian), nil
+}
+
+// Write a single 32bit word into the target's memory space in one usb transaction
+func (h *StLink) writeDebugReg(addr uint32, value uint32) error {
+	if h.version.jtagApi == jTagApiV1 {
+		return fmt.Errorf("write debug register not supported by jtag api v1: %w", ErrNotSupported)
+	}
+
+	h.invalidateReadCache()
+
+	ctx := h.initTransfer(transferIncoming)
+
+	ctx.cmdBuf.WriteByte(cmdDebug)
+	ctx.cmdBuf.WriteByte(debugApiV2WriteDebugReg)
+	ctx.cmdBuf.WriteUint32LE(addr)
+	ctx.cmdBuf.WriteUint32LE(value)
+
+	return h.usbCmdAllowRetry(ctx, 2)
+}
+
+// Determine the data endianness of the target core from AIRCR.ENDIANNESS.
+// The result is kept and returned by Endianness afterwards.
+func (h *StLink) DetectEndianness() (Endian, error) {
+	h, unlock := h.lock()
+	defer unlock()
+
+	aircr, err := h.readDebugReg(scbAircr)
+
+	if err != nil {
+		return h.targetEndian, err
+	}
+
+	if (aircr & aircrEndianness) > 0 {
+		h.targetEndian = BigEndian
+	} else {
+		h.targetEndian = LittleEndian
+	}
+
+	return h.targetEndian, nil
+}
+
+// Endianness of the target core as detected at connect, little endian by default
+func (h *StLink) Endianness() Endian {
+	h, unlock := h.lock()
+	defer unlock()
+
+	return h.targetEndian
+}
+
+// Read the vector table offset register, the address of the active vector table
+func (h *StLink) ReadVTOR() (uint32, error) {
+	h, unlock := h.lock()
+	defer unlock()
+
+	return h.readDebugReg(scbVtor)
+}
+
+// Relocate the vector table to addr, which must be aligned to at least 128 bytes
+func (h *StLink) WriteVTOR(addr uint32) error {
+	h, unlock := h.lock()
+	defer unlock()
+
+	if (addr & vtorAlignMask) != 0 {
+		return fmt.Errorf("vector table address 0x%08x is not aligned to 128 bytes: %w", addr, ErrUnalignedAccess)
+	}
+
+	return h.writeDebugReg(scbVtor, addr)
+}
+
+// Read a 16bit value from target memory and interpret it in the given byte order
+func (h *StLink) ReadUint16(addr uint32, e Endian) (uint16, error) {
+	h, unlock := h.lock()
+	defer unlock()
+
+	buffer := bytes.NewBuffer([]byte{})
+
+	if err := h.ReadMem(addr, Memory8BitBlock, 2, buffer); err != nil {
+		return 0, err
+	}
+
+	return convertToUint16(buffer.Bytes(), e), nil
+}
+
+// Read a 32bit value from target memory and interpret it in the given byte order
+func (h *StLink) ReadUint32(addr uint32, e Endian) (uint32, error) {
+	h, unlock := h.lock()
+	defer unlock()
+
+	buffer := bytes.NewBuffer([]byte{})
+
+	if err := h.ReadMem(addr, Memory32BitBlock, 1, buffer); err != nil {
+		return 0, err
+	}
+
+	return convertToUint32(buffer.Bytes(), e), nil
+}
+
+// Read count consecutive 16bit values in target byte order with one block read
+func (h *StLink) ReadUint16Slice(addr uint32, count int) ([]uint16, error) {
+	h, unlock := h.lock()
+	defer unlock()
+
+	if count < 0 {
+		return nil, errors.New("negative count")
+	}
+
+	buffer := bytes.NewBuffer([]byte{})
+
+	if err := h.ReadMem(addr, Memory16BitBlock, uint32(count), buffer); err != nil {
+		return nil, err
+	}
+
+	data := buffer.Bytes()
+
+	if len(data) < count*2 {
+		return nil, fmt.Errorf("short read of %d bytes", len(data))
+	}
+
+	values := make([]uint16, count)
+
+	for i := range values {
+		values[i] = convertToUint16(data[i*2:], h.targetEndian)
+	}
+
+	return values, nil
+}
+
+// Read count consecutive 32bit values in target byte order with one block read
+func (h *StLink) ReadUint32Slice(addr uint32, count int) ([]uint32, error) {
+	h, unlock := h.lock()
+	defer unlock()
+
+	if count < 0 {
+		return nil, errors.New("negative count")
+	}
+
+	buffer := bytes.NewBuffer([]byte{})
+
+	if err := h.ReadMem(addr, Memory32BitBlock, uint32(count), buffer); err != nil {
+		return nil, err
+	}
+
+	data := buffer.Bytes()
+
+	if len(data) < count*4 {
+		return nil, fmt.Errorf("short read of %d bytes", len(data))
+	}
+
+	values := make([]uint32, count)
+
+	for i := range values {
+		values[i] = convertToUint32(data[i*4:], h.targetEndian)
+	}
+
+	return values, nil
+}
@@ -0,0 +1,91 @@
+// Copyright 2021 juju2013@github. All rights reserved.
+// Use of this source code is governed by a GNU-style
+// license that can be found in the LICENSE file.
+
+package gostlink
+
+import (
+	"bytes"
+	"time"
+)
+
+// Memory region of the target
+type MemoryRange struct {
+	Address uint32
+	Size    uint32
+}
+
+// Content of a memory region read for a crash dump
+type MemoryDump struct {
+	MemoryRange
+	Data []byte
+	Err  error // set if the region could not be read
+}
+
+// Snapshot of the target state for post mortem analysis
+type CrashDump struct {
+	Time      time.Time
+	CpuId     uint32
+	Device    *StmDeviceInfo // nil if the device could not be identified
+	Registers *TargetRegisters
+	Faults    FaultRegisters
+	Memory    []MemoryDump
+}
+
+// Halt the target and capture CPUID, core registers, fault registers and
+// the given memory ranges in one snapshot. The watchdogs are frozen first so
+// the halted target is not reset while the dump is taken. Targets which
+// cannot be connected otherwise should be opened with connect under reset.
+// Regions which cannot be read are reported in their MemoryDump.Err.
+func (h *StLink) CaptureCrashDump(ranges []MemoryRange) (*CrashDump, error) {
+	h, unlock := h.lock()
+	defer unlock()
+
+	var err error
+
+	if err = h.UsbModeEnter(h.stMode); err != nil {
+		return nil, err
+	}
+	defer h.UsbLeaveMode(h.stMode)
+
+	if err = h.ensureHalted(); err != nil {
+		return nil, err
+	}
+
+	dump := &CrashDump{Time: time.Now()}
+
+	if dump.Device, err = h.IdentifyDevice(); err == nil {
+		if err = h.FreezeWatchdogs(); err != nil {
+			h.log().Warn("could not freeze watchdogs: ", err)
+		}
+	} else {
+		h.log().Warn("could not identify device: ", err)
+	}
+
+	if dump.CpuId, err = h.readDebugReg(cpuIdBaseRegister); err != nil {
+		return nil, err
+	}
+
+	if dump.Registers, err = h.readRegisters(); err != nil {
+		return nil, err
+	}
+
+	if dump.Faults, err = h.ReadFaultRegisters(); err != nil {
+		return nil, err
+	}
+
+	for _, r := range ranges {
+		buffer := bytes.NewBuffer([]byte{})
+		region := MemoryDump{MemoryRange: r}
+
+		if region.Err = h.ReadMem(r.Address, Memory8BitBlock, r.Size, buffer); region.Err == nil {
+			region.Data = buffer.Bytes()[:r.Size]
+		} else {
+			h.log().Warnf("could not read memory range [%08x, %08x]: %s", r.Address, r.Address+r.Size, region.Err)
+		}
+
+		dump.Memory = append(dump.Memory, region)
+	}
+
+	return dump, nil
+}
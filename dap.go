@@ -0,0 +1,149 @@
+// Copyright 2021 juju2013@github. All rights reserved.
+// Use of this source code is governed by a GNU-style
+// license that can be found in the LICENSE file.
+
+package gostlink
+
+import (
+	"fmt"
+	"time"
+)
+
+// debug port registers as addressed by the st-link dap register commands
+const (
+	dapDebugPortAccess = 0xffff // selects the debug port instead of an access port
+
+	dpIdr      = 0x00
+	dpCtrlStat = 0x04
+
+	dpCtrlCDbgRstReq = 1 << 26
+	dpCtrlCDbgRstAck = 1 << 27
+
+	debugResetTimeout = 100 * time.Millisecond
+)
+
+func (h *StLink) usbReadDapRegister(port uint16, addr uint16) (uint32, error) {
+	if !h.version.flags.Get(flagHasDapReg) {
+		return 0, fmt.Errorf("dap register access not supported by st-link: %w", ErrNotSupported)
+	}
+
+	ctx := h.initTransfer(transferIncoming)
+
+	ctx.cmdBuf.WriteByte(cmdDebug)
+	ctx.cmdBuf.WriteByte(debugApiV2ReadDebugAccessPortRegister)
+	ctx.cmdBuf.WriteUint16LE(port)
+	ctx.cmdBuf.WriteUint16LE(addr)
+
+	err := h.usbTransferErrCheck(ctx, 8)
+
+	if err != nil {
+		return 0, err
+	}
+
+	return convertToUint32(ctx.DataBytes()[4:], littleEndian), nil
+}
+
+func (h *StLink) usbWriteDapRegister(port uint16, addr uint16, value uint32) error {
+	if !h.version.flags.Get(flagHasDapReg) {
+		return fmt.Errorf("dap register access not supported by st-link: %w", ErrNotSupported)
+	}
+
+	ctx := h.initTransfer(transferIncoming)
+
+	ctx.cmdBuf.WriteByte(cmdDebug)
+	ctx.cmdBuf.WriteByte(debugApiV2WriteDebugAccessPortRegister)
+	ctx.cmdBuf.WriteUint16LE(port)
+	ctx.cmdBuf.WriteUint16LE(addr)
+	ctx.cmdBuf.WriteUint32LE(value)
+
+	return h.usbCmdAllowRetry(ctx, 2)
+}
+
+// Check whether a powered, debuggable target is connected without touching
+// its memory. The target voltage is checked first (if supported by the st-link),
+// then the debug port is asked for its identification register.
+func (h *StLink) TargetPresent() (bool, error) {
+	h, unlock := h.lock()
+	defer unlock()
+
+	if h.version.flags.Get(flagHasTargetVolt) {
+		voltage, err := h.GetTargetVoltage()
+
+		if err != nil {
+			return false, err
+		}
+
+		if voltage < minTargetVoltage {
+			h.log().Debugf("target voltage %.2fV too low, assuming no target", voltage)
+			return false, nil
+		}
+	}
+
+	var idr uint32
+	var err error
+
+	if h.version.flags.Get(flagHasDapReg) {
+		idr, err = h.usbReadDapRegister(dapDebugPortAccess, dpIdr)
+	} else {
+		idr, err = h.GetIdCode()
+	}
+
+	if err != nil {
+		h.log().Debug("no answer from debug port: ", err)
+		return false, nil
+	}
+
+	return idr != 0 && idr != 0xffffffff, nil
+}
+
+// Reset the debug logic of the target (DWT, FPB, ITM...) through DP CTRL/STAT.CDBGRSTREQ
+// without resetting the core. The reset request is optional in the debug
+// architecture, an error is returned when the target does not acknowledge it.
+func (h *StLink) ResetDebugLogic() error {
+	h, unlock := h.lock()
+	defer unlock()
+
+	ctrl, err := h.usbReadDapRegister(dapDebugPortAccess, dpCtrlStat)
+
+	if err != nil {
+		return err
+	}
+
+	if err = h.usbWriteDapRegister(dapDebugPortAccess, dpCtrlStat, ctrl|dpCtrlCDbgRstReq); err != nil {
+		return err
+	}
+
+	ackErr := h.waitDebugResetAck(true)
+
+	if err = h.usbWriteDapRegister(dapDebugPortAccess, dpCtrlStat, ctrl&^dpCtrlCDbgRstReq); err != nil {
+		return err
+	}
+
+	if ackErr != nil {
+		return ackErr
+	}
+
+	return h.waitDebugResetAck(false)
+}
+
+func (h *StLink) waitDebugResetAck(set bool) error {
+	deadline := time.Now().Add(debugResetTimeout)
+
+	for {
+		ctrl, err := h.usbReadDapRegister(dapDebugPortAccess, dpCtrlStat)
+
+		if err != nil {
+			return err
+		}
+
+		if ((ctrl & dpCtrlCDbgRstAck) > 0) == set {
+			return nil
+		}
+
+		if time.Now().After(deadline) {
+			return fmt.Errorf("debug logic reset not acknowledged by target: %w", ErrTimeout)
+		}
+
+		time.Sleep(haltPollInterval)
+	}
+}
@@ -0,0 +1,78 @@
+// Copyright 2021 juju2013@github. All rights reserved.
+// Use of this source code is governed by a GNU-style
+// license that can be found in the LICENSE file.
+
+package gostlink
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+const (
+	debugAuthTimeout      = time.Second
+	debugAuthPollInterval = 10 * time.Millisecond
+)
+
+// Access port register of a secured part receiving the debug key, e.g. a
+// vendor debug mailbox. The layout is device specific and taken from the
+// reference manual of the part.
+type DebugAuthMailbox struct {
+	AccessPort uint16 // access port of the mailbox
+	Register   uint16 // access port register the key words are written to
+
+	// Register polled after the key was written until (value & StatusMask) == StatusOk,
+	// polling is skipped if StatusMask is 0
+	Status     uint16
+	StatusMask uint32
+	StatusOk   uint32
+}
+
+// Present key to the debug mailbox of a secured part, for the parts unlocking
+// debug access by a written password or key. The key is written as little
+// endian words to the mailbox register one after another, its length has to
+// be a multiple of 4. Certificate based handshakes (ADAC, debug certificates)
+// exchanging several messages with the part are not implemented. The access
+// ports are initialized again once the key was accepted. Needs st-link
+// firmware with dap register access, fails with ErrNotSupported otherwise.
+func (h *StLink) DebugAuthenticate(mailbox DebugAuthMailbox, key []byte) error {
+	h, unlock := h.lock()
+	defer unlock()
+
+	if len(key) == 0 || len(key)%4 != 0 {
+		return errors.New("debug key length has to be a non-zero multiple of 4")
+	}
+
+	if err := h.UsbModeEnter(h.stMode); err != nil {
+		return err
+	}
+	defer h.UsbLeaveMode(h.stMode)
+
+	if err := h.usbOpenAccessPort(mailbox.AccessPort); err != nil {
+		return err
+	}
+
+	for i := 0; i < len(key); i += 4 {
+		word := convertToUint32(key[i:i+4], littleEndian)
+
+		if err := h.usbWriteDapRegister(mailbox.AccessPort, mailbox.Register, word); err != nil {
+			return fmt.Errorf("write of debug key word %d failed: %w", i/4, err)
+		}
+	}
+
+	if mailbox.StatusMask != 0 {
+		err := waitForValue(func() (uint32, error) {
+			return h.usbReadDapRegister(mailbox.AccessPort, mailbox.Status)
+		}, mailbox.StatusMask, mailbox.StatusOk, debugAuthTimeout, debugAuthPollInterval, func(status uint32) string {
+			return fmt.Sprintf("debug key not accepted, mailbox status 0x%08x", status)
+		})
+
+		if err != nil {
+			return err
+		}
+	}
+
+	h.log().Info("debug key written to access port ", mailbox.AccessPort)
+	return h.reopenAccessPorts()
+}
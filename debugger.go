@@ -6,6 +6,7 @@ package gostlink
 
 import (
 	"errors"
+	"fmt"
 	"time"
 )
 
@@ -20,33 +21,33 @@ func (h *StLink) usbCmdAllowRetry(ctx *transferCtx, size uint32) error {
 	var retries int = 0
 
 	for true {
-		if (h.stMode != StLinkModeDebugSwim) || retries > 0 {
+		// a busy swim command is not sent again, only its status is polled
+		if (h.stMode != StLinkModeDebugSwim) || retries == 0 {
 			err := h.usbTransferNoErrCheck(ctx, size)
 			if err != nil {
 				return err
 			}
 		}
 
-		/*
-			    TODO: Implement DEBUG swim!
-			if (h.st_mode == STLINK_MODE_DEBUG_SWIM) {
-				err = h.stlink_swim_status(handle);
-				if err != nil {
-					return err
-				}
-			}*/
+		statusCtx := ctx
 
-		err := h.usbErrorCheck(ctx)
+		if h.stMode == StLinkModeDebugSwim {
+			var err error
 
-		if err != nil {
-			usbError := err.(*usbError)
+			if statusCtx, err = h.usbSwimStatus(); err != nil {
+				return err
+			}
+		}
 
-			if usbError.UsbErrorCode == usbErrorWait && retries < maximumWaitRetries {
-				var delayUs time.Duration = (1 << retries) * 1000
+		err := h.usbErrorCheck(statusCtx)
+
+		if err != nil {
+			if errors.Is(err, ErrProbeBusy) && retries < h.config.retryPolicy.CommandRetries {
+				delay := h.config.retryPolicy.delay(retries)
 
 				retries++
-				logger.Debugf("cmdAllowRetry ERROR_WAIT, retry %d, delaying %d microseconds", retries, delayUs)
-				time.Sleep(delayUs * 1000)
+				h.log().Debugf("cmdAllowRetry ERROR_WAIT, retry %d, delaying %v", retries, delay)
+				time.Sleep(delay)
 
 				continue
 			}
@@ -59,17 +60,12 @@ func (h *StLink) usbCmdAllowRetry(ctx *transferCtx, size uint32) error {
 }
 
 func (h *StLink) usbAssertSrst(srst byte) error {
-
-	/* TODO:
-		* Implement SWIM debugger
-	     *
-		if h.st_mode == STLINK_MODE_DEBUG_SWIM {
-			return stlink_swim_assert_reset(handle, srst);
-		}
-	*/
+	if h.stMode == StLinkModeDebugSwim {
+		return h.usbSwimAssertReset(srst == 0)
+	}
 
 	if h.version.stlink == 1 {
-		return errors.New("rsrt command not supported by st-link V1")
+		return fmt.Errorf("rsrt command not supported by st-link V1: %w", ErrNotSupported)
 	}
 
 	ctx := h.initTransfer(transferIncoming)
@@ -78,5 +74,14 @@ func (h *StLink) usbAssertSrst(srst byte) error {
 	ctx.cmdBuf.WriteByte(debugApiV2DriveNrst)
 	ctx.cmdBuf.WriteByte(srst)
 
-	return h.usbCmdAllowRetry(ctx, 2)
+	if err := h.usbCmdAllowRetry(ctx, 2); err != nil {
+		return err
+	}
+
+	if srst == 0 {
+		// the access ports have to be initialized again once the reset is released
+		h.forgetAccessPorts()
+	}
+
+	return nil
 }
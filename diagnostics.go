@@ -0,0 +1,103 @@
+// Copyright 2021 juju2013@github. All rights reserved.
+// Use of this source code is governed by a GNU-style
+// license that can be found in the LICENSE file.
+
+package gostlink
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Health information reported by a STLINK-V3. The firmware has no documented
+// diagnostic command, so the raw responses of the status queries are exposed
+// for monitoring, e.g. to detect changes over a long running session.
+type ProbeDiagnostics struct {
+	VersionEx     []byte  // raw GET_VERSION_EX response
+	LastRwStatus  []byte  // raw response of the last read/write status query
+	CurrentMode   byte    // usb mode the probe reports
+	TargetVoltage float32 // measured target voltage
+}
+
+// Query the diagnostic information of a STLINK-V3, not supported on older probes
+func (h *StLink) ProbeDiagnostics() (*ProbeDiagnostics, error) {
+	h, unlock := h.lock()
+	defer unlock()
+
+	if h.version.stlink != 3 {
+		return nil, fmt.Errorf("probe diagnostics are only supported on STLINK-V3: %w", ErrNotSupported)
+	}
+
+	diag := &ProbeDiagnostics{}
+
+	ctx := h.initTransfer(transferIncoming)
+	ctx.cmdBuf.WriteByte(debugApiV3GetVersionEx)
+
+	if err := h.usbTransferNoErrCheck(ctx, 12); err != nil {
+		return nil, err
+	}
+
+	diag.VersionEx = append([]byte(nil), ctx.DataBytes()[:ctx.rxSize]...)
+
+	ctx = h.initTransfer(transferIncoming)
+	ctx.cmdBuf.WriteByte(cmdDebug)
+	ctx.cmdBuf.WriteByte(debugApiV2GetLastRWStatus2)
+
+	if err := h.usbTransferNoErrCheck(ctx, 12); err != nil {
+		return nil, err
+	}
+
+	diag.LastRwStatus = append([]byte(nil), ctx.DataBytes()[:ctx.rxSize]...)
+
+	mode, err := h.UsbCurrentMode()
+
+	if err != nil {
+		return nil, err
+	}
+
+	diag.CurrentMode = mode
+
+	if diag.TargetVoltage, err = h.GetTargetVoltage(); err != nil {
+		return nil, err
+	}
+
+	return diag, nil
+}
+
+// Measure the usb round trip time by timing samples current mode queries, a
+// command answered by the probe itself without any target access.
+func (h *StLink) MeasureLatency(samples int) (min, avg, max time.Duration, err error) {
+	h, unlock := h.lock()
+	defer unlock()
+
+	if samples <= 0 {
+		return 0, 0, 0, errors.New("invalid sample count")
+	}
+
+	var total time.Duration
+
+	for i := 0; i < samples; i++ {
+		start := time.Now()
+
+		if _, err = h.usbCurrentMode(); err != nil {
+			return 0, 0, 0, err
+		}
+
+		elapsed := time.Since(start)
+		total += elapsed
+
+		if i == 0 || elapsed < min {
+			min = elapsed
+		}
+		if elapsed > max {
+			max = elapsed
+		}
+	}
+
+	avg = total / time.Duration(samples)
+
+	h.log().Debugf("usb latency over %d samples: min %v, avg %v, max %v", samples, min, avg, max)
+
+	return min, avg, max, nil
+}
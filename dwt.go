@@ -0,0 +1,157 @@
+// Copyright 2021 juju2013@github. All rights reserved.
+// Use of this source code is governed by a GNU-style
+// license that can be found in the LICENSE file.
+
+package gostlink
+
+// ARMv6-M/ARMv7-M data watchpoint and trace unit registers
+const (
+	dwtCtrl  = 0xE0001000
+	dwtComp0 = 0xE0001020 // COMP, MASK and FUNCTION of each comparator, 16 bytes apart
+
+	dwtCtrlNumCompShift = 28
+	dwtComparatorStride = 16
+
+	dwtFunctionMask       = 0xf
+	dwtFunctionCycMatch   = 1 << 7
+	dwtFunctionDataVMatch = 1 << 8
+	dwtFunctionMatched    = 1 << 24
+)
+
+// Decoded function of a DWT comparator
+type DwtFunction int
+
+const (
+	DwtDisabled       DwtFunction = iota
+	DwtSamplePc                   // trace the PC on match
+	DwtSampleData                 // trace the data value on match
+	DwtSamplePcData               // trace PC and data value on match
+	DwtWatchPc                    // halt or debug monitor on instruction address
+	DwtWatchRead                  // halt or debug monitor on data read
+	DwtWatchWrite                 // halt or debug monitor on data write
+	DwtWatchReadWrite             // halt or debug monitor on data read or write
+	DwtEtmTrigger                 // CMPMATCH event for the ETM
+	DwtUnknown                    // reserved value or not decoded for the core
+)
+
+func (f DwtFunction) String() string {
+	switch f {
+	case DwtDisabled:
+		return "disabled"
+	case DwtSamplePc:
+		return "sample pc"
+	case DwtSampleData:
+		return "sample data"
+	case DwtSamplePcData:
+		return "sample pc and data"
+	case DwtWatchPc:
+		return "watch pc"
+	case DwtWatchRead:
+		return "watch read"
+	case DwtWatchWrite:
+		return "watch write"
+	case DwtWatchReadWrite:
+		return "watch read/write"
+	case DwtEtmTrigger:
+		return "etm trigger"
+	default:
+		return "unknown"
+	}
+}
+
+// One DWT comparator as configured in COMP, MASK and FUNCTION
+type DwtComparator struct {
+	Number   int
+	COMP     uint32
+	MASK     uint32
+	FUNCTION uint32
+
+	Function   DwtFunction
+	Address    uint32 // compared address, or data value if DataMatch is set
+	IgnoreBits uint8  // low address bits ignored by the comparison
+	DataMatch  bool   // compares the data value instead of the address
+	CycleMatch bool   // compares the cycle counter (comparator 0 only)
+	Matched    bool   // matched since FUNCTION was read last
+}
+
+func decodeDwtFunction(function uint32) DwtFunction {
+	switch function & dwtFunctionMask {
+	case 0x0:
+		return DwtDisabled
+	case 0x1:
+		return DwtSamplePc
+	case 0x2:
+		return DwtSampleData
+	case 0x3:
+		return DwtSamplePcData
+	case 0x4:
+		return DwtWatchPc
+	case 0x5:
+		return DwtWatchRead
+	case 0x6:
+		return DwtWatchWrite
+	case 0x7:
+		return DwtWatchReadWrite
+	case 0x8, 0x9, 0xa, 0xb:
+		return DwtEtmTrigger
+	default:
+		return DwtUnknown
+	}
+}
+
+func decodeDwtComparator(number int, comp uint32, mask uint32, function uint32) DwtComparator {
+	return DwtComparator{
+		Number:     number,
+		COMP:       comp,
+		MASK:       mask,
+		FUNCTION:   function,
+		Function:   decodeDwtFunction(function),
+		Address:    comp,
+		IgnoreBits: uint8(mask & 0x1f),
+		DataMatch:  (function & dwtFunctionDataVMatch) > 0,
+		CycleMatch: (function & dwtFunctionCycMatch) > 0,
+		Matched:    (function & dwtFunctionMatched) > 0,
+	}
+}
+
+// Read the configuration of all DWT comparators, e.g. to verify watchpoints
+// or find comparators left configured by another debugger. Reading FUNCTION
+// clears its MATCHED bit. ARMv8-M cores use a different FUNCTION layout, their
+// comparators are returned with only the raw register values and DwtUnknown.
+func (h *StLink) ReadDWTComparators() ([]DwtComparator, error) {
+	h, unlock := h.lock()
+	defer unlock()
+
+	ctrl, err := h.readDebugReg(dwtCtrl)
+
+	if err != nil {
+		return nil, err
+	}
+
+	v8m, err := h.isArmV8M()
+
+	if err != nil {
+		return nil, err
+	}
+
+	count := int(ctrl >> dwtCtrlNumCompShift)
+	comparators := make([]DwtComparator, 0, count)
+
+	for i := 0; i < count; i++ {
+		words, err := h.readNvicWords(dwtComp0+uint32(i*dwtComparatorStride), 3)
+
+		if err != nil {
+			return nil, err
+		}
+
+		if v8m {
+			comparators = append(comparators, DwtComparator{Number: i, COMP: words[0], MASK: words[1],
+				FUNCTION: words[2], Function: DwtUnknown, Address: words[0]})
+			continue
+		}
+
+		comparators = append(comparators, decodeDwtComparator(i, words[0], words[1], words[2]))
+	}
+
+	return comparators, nil
+}
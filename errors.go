@@ -5,7 +5,32 @@
 package gostlink
 
 import (
+	"errors"
 	"fmt"
+
+	"github.com/google/gousb"
+)
+
+// Errors returned by the package wrap one of these, so they can be tested with errors.Is
+var (
+	ErrNotSupported    = errors.New("not supported")
+	ErrTargetNotHalted = errors.New("target not halted")
+	ErrProbeBusy       = errors.New("st-link busy")
+	ErrDeviceNotFound  = errors.New("st-link not found")
+	ErrUnalignedAccess = errors.New("unaligned access")
+	ErrTimeout         = errors.New("timeout")
+
+	// The core reset repeatedly while it was observed, e.g. by a watchdog or brown-out
+	ErrTargetResetLooping = errors.New("target is resetting repeatedly")
+
+	// Failed memory or register accesses reported by the st-link status
+	ErrTargetFault     = errors.New("target fault response")
+	ErrParity          = errors.New("swd parity error")
+	ErrAccessPortFault = errors.New("access port fault")
+
+	// Returned when the st-link lost its usb configuration, which typically
+	// happens when the host was suspended. The handle has to be reconnected.
+	ErrDeviceSuspended = errors.New("st-link lost its usb configuration (host suspended?)")
 )
 
 type usbErrorCode int
@@ -21,14 +46,63 @@ const (
 type usbError struct {
 	errorString  string
 	UsbErrorCode usbErrorCode
+	status       byte // st-link status code of a debug command, 0 if not from a status response
 }
 
 func (e *usbError) Error() string {
 	return e.errorString
 }
 
+// match the sentinel errors corresponding to the st-link status
+func (e *usbError) Is(target error) bool {
+	switch target {
+	case ErrProbeBusy:
+		return e.UsbErrorCode == usbErrorWait
+	case ErrUnalignedAccess:
+		return e.UsbErrorCode == usbErrorTargetUnalignedAccess
+	case ErrNotSupported:
+		return e.UsbErrorCode == usbErrorCommandNotFound
+	case ErrTargetFault:
+		return e.status == debugErrorFault || e.status == swdDebugPortFault
+	case ErrParity:
+		return e.status == swdAccessPortParityError || e.status == swdDebugPortParityError
+	case ErrAccessPortFault:
+		switch e.status {
+		case swdAccessPortFault, swdAccessPortError, swdAccessPortWDataError,
+			swdAccessPortStickyError, swdAccessPortStickOrRunError, badAccessPortError:
+			return true
+		}
+		return false
+	default:
+		return false
+	}
+}
+
+// Raw st-link status code of a failed debug command, e.g. STLINK_SWD_AP_FAULT (0x11).
+// Use errors.Is with ErrProbeBusy, ErrTargetFault, ErrParity or ErrAccessPortFault to
+// react on the kind of failure.
+func StatusCode(err error) (byte, bool) {
+	var e *usbError
+
+	if errors.As(err, &e) && e.status != 0 {
+		return e.status, true
+	}
+
+	return 0, false
+}
+
 func newUsbError(msg string, code usbErrorCode) error {
-	return &usbError{msg, code}
+	return &usbError{errorString: msg, UsbErrorCode: code}
+}
+
+// map libusb errors which indicate a lost device configuration to ErrDeviceSuspended
+func usbMapError(err error) error {
+	switch err {
+	case gousb.ErrorNoDevice, gousb.TransferNoDevice:
+		return fmt.Errorf("%w: %v", ErrDeviceSuspended, err)
+	default:
+		return err
+	}
 }
 
 /**
@@ -57,27 +131,32 @@ func (h *StLink) usbErrorCheck(ctx *transferCtx) error {
 		errorStatus = debugErrorOk
 	}
 
+	// keep the status so callers can tell the failure apart, see StatusCode
+	statusError := func(msg string, code usbErrorCode) error {
+		return &usbError{errorString: msg, UsbErrorCode: code, status: errorStatus}
+	}
+
 	switch errorStatus {
 	case debugErrorOk:
 		return nil
 
 	case debugErrorFault:
-		return newUsbError(fmt.Sprintf("SWD fault response (0x%x)", debugErrorFault), usbErrorFail)
+		return statusError(fmt.Sprintf("SWD fault response (0x%x)", debugErrorFault), usbErrorFail)
 
 	case swdAccessPortWait:
-		return newUsbError(fmt.Sprintf("wait status SWD_AP_WAIT (0x%x)", swdAccessPortWait), usbErrorWait)
+		return statusError(fmt.Sprintf("wait status SWD_AP_WAIT (0x%x)", swdAccessPortWait), usbErrorWait)
 
 	case swdDebugPortWait:
-		return newUsbError(fmt.Sprintf("wait status SWD_DP_WAIT (0x%x)", swdDebugPortWait), usbErrorWait)
+		return statusError(fmt.Sprintf("wait status SWD_DP_WAIT (0x%x)", swdDebugPortWait), usbErrorWait)
 
 	case jTagGetIdCodeError:
-		return newUsbError("STLINK_JTAG_GET_IDCODE_ERROR", usbErrorFail)
+		return statusError("STLINK_JTAG_GET_IDCODE_ERROR", usbErrorFail)
 
 	case jTagWriteError:
-		return newUsbError("Write error", usbErrorFail)
+		return statusError("Write error", usbErrorFail)
 
 	case jTagWriteVerifyError:
-		return newUsbError("Write verify error, ignoring", usbErrorOK)
+		return statusError("Write verify error, ignoring", usbErrorOK)
 
 	case swdAccessPortFault:
 		/* git://git.ac6.fr/openocd commit 657e3e885b9ee10
@@ -85,36 +164,36 @@ func (h *StLink) usbErrorCheck(ctx *transferCtx) error {
 		 * Change in error status when reading outside RAM.
 		 * This fix allows CDT plugin to visualize memory.
 		 */
-		return newUsbError("STLINK_SWD_AP_FAULT", usbErrorFail)
+		return statusError("STLINK_SWD_AP_FAULT", usbErrorFail)
 
 	case swdAccessPortError:
-		return newUsbError("STLINK_SWD_AP_ERROR", usbErrorFail)
+		return statusError("STLINK_SWD_AP_ERROR", usbErrorFail)
 
 	case swdAccessPortParityError:
-		return newUsbError("STLINK_SWD_AP_PARITY_ERROR", usbErrorFail)
+		return statusError("STLINK_SWD_AP_PARITY_ERROR", usbErrorFail)
 
 	case swdDebugPortFault:
-		return newUsbError("STLINK_SWD_DP_FAULT", usbErrorFail)
+		return statusError("STLINK_SWD_DP_FAULT", usbErrorFail)
 
 	case swdDebugPortError:
-		return newUsbError("STLINK_SWD_DP_ERROR", usbErrorFail)
+		return statusError("STLINK_SWD_DP_ERROR", usbErrorFail)
 
 	case swdDebugPortParityError:
-		return newUsbError("STLINK_SWD_DP_PARITY_ERROR", usbErrorFail)
+		return statusError("STLINK_SWD_DP_PARITY_ERROR", usbErrorFail)
 
 	case swdAccessPortWDataError:
-		return newUsbError("STLINK_SWD_AP_WDATA_ERROR", usbErrorFail)
+		return statusError("STLINK_SWD_AP_WDATA_ERROR", usbErrorFail)
 
 	case swdAccessPortStickyError:
-		return newUsbError("STLINK_SWD_AP_STICKY_ERROR", usbErrorFail)
+		return statusError("STLINK_SWD_AP_STICKY_ERROR", usbErrorFail)
 
 	case swdAccessPortStickOrRunError:
-		return newUsbError("STLINK_SWD_AP_STICKYORUN_ERROR", usbErrorFail)
+		return statusError("STLINK_SWD_AP_STICKYORUN_ERROR", usbErrorFail)
 
 	case badAccessPortError:
-		return newUsbError("STLINK_BAD_AP_ERROR", usbErrorFail)
+		return statusError("STLINK_BAD_AP_ERROR", usbErrorFail)
 
 	default:
-		return newUsbError(fmt.Sprintf("unknown/unexpected STLINK status code 0x%x", errorStatus), usbErrorFail)
+		return statusError(fmt.Sprintf("unknown/unexpected STLINK status code 0x%x", errorStatus), usbErrorFail)
 	}
 }
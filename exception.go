@@ -0,0 +1,246 @@
+// Copyright 2021 juju2013@github. All rights reserved.
+// Use of this source code is governed by a GNU-style
+// license that can be found in the LICENSE file.
+
+package gostlink
+
+import (
+	"bytes"
+	"fmt"
+)
+
+const (
+	excReturnMask      = 0xF0000000 // EXC_RETURN values have all upper bits set
+	excReturnStdFrame  = 1 << 4     // cleared when the frame contains FPU state
+	xpsrStackAlignment = 1 << 9     // set when the core padded the frame to 8 bytes
+
+	scbCfsr  = 0xE000ED28
+	scbHfsr  = 0xE000ED2C
+	scbMmfar = 0xE000ED34
+	scbBfar  = 0xE000ED38
+	scbAfsr  = 0xE000ED3C
+
+	fpuFpccr    = 0xE000EF34
+	fpuFpcar    = 0xE000EF38 // address of the FPU space of the frame pending lazy stacking
+	fpccrLspAct = 1 << 0     // lazy state preservation pending, FPU context not yet stacked
+
+	basicFrameSize    = 8 * 4  // R0-R3, R12, LR, PC, xPSR
+	extendedFrameSize = 26 * 4 // basic frame + S0-S15, FPSCR and a reserved word
+)
+
+// Context saved by the core on the stack on exception entry
+type ExceptionFrame struct {
+	R0   uint32
+	R1   uint32
+	R2   uint32
+	R3   uint32
+	R12  uint32
+	LR   uint32
+	PC   uint32
+	XPSR uint32
+
+	S     [16]uint32 // S0-S15 of an extended frame
+	FPSCR uint32     // FPSCR of an extended frame
+	// FPU context of an extended frame was not stacked yet due to lazy stacking,
+	// S and FPSCR are not valid and the values are still held in the FPU
+	FpuLazy bool
+
+	ExcReturn uint32 // EXC_RETURN value used to determine the frame layout
+	Extended  bool   // frame also holds the FPU context
+	Size      uint32 // size of the frame on stack including alignment padding
+
+	StackPointer uint32 // address the frame was read from
+	OriginalSP   uint32 // stack pointer value before the exception was taken
+}
+
+// Read the exception frame stacked at sp. The frame layout is derived
+// from the EXC_RETURN value currently held in LR, so the core should be
+// halted inside the exception handler before any call overwrote LR.
+func (h *StLink) ReadExceptionFrame(sp uint32) (ExceptionFrame, error) {
+	h, unlock := h.lock()
+	defer unlock()
+
+	lr, err := h.GetRegister(registerLR)
+
+	if err != nil {
+		return ExceptionFrame{}, err
+	}
+
+	if (lr & excReturnMask) != excReturnMask {
+		h.log().Debugf("LR 0x%08x is not an EXC_RETURN value, assuming standard frame", lr)
+		lr = excReturnMask | excReturnStdFrame
+	}
+
+	return h.readExceptionFrame(sp, lr)
+}
+
+func (h *StLink) readExceptionFrame(sp uint32, excReturn uint32) (ExceptionFrame, error) {
+	frame := ExceptionFrame{ExcReturn: excReturn, StackPointer: sp}
+	buffer := bytes.NewBuffer([]byte{})
+
+	frame.Extended = (excReturn & excReturnStdFrame) == 0
+
+	frameSize := uint32(basicFrameSize)
+
+	if frame.Extended {
+		frameSize = extendedFrameSize
+	}
+
+	if err := h.ReadMem(sp, Memory32BitBlock, frameSize/4, buffer); err != nil {
+		return frame, err
+	}
+
+	data := buffer.Bytes()
+
+	if uint32(len(data)) < frameSize {
+		return frame, fmt.Errorf("short read of %d bytes", len(data))
+	}
+
+	frame.R0 = convertToUint32(data[0:], littleEndian)
+	frame.R1 = convertToUint32(data[4:], littleEndian)
+	frame.R2 = convertToUint32(data[8:], littleEndian)
+	frame.R3 = convertToUint32(data[12:], littleEndian)
+	frame.R12 = convertToUint32(data[16:], littleEndian)
+	frame.LR = convertToUint32(data[20:], littleEndian)
+	frame.PC = convertToUint32(data[24:], littleEndian)
+	frame.XPSR = convertToUint32(data[28:], littleEndian)
+
+	if frame.Extended {
+		for i := range frame.S {
+			frame.S[i] = convertToUint32(data[basicFrameSize+i*4:], littleEndian)
+		}
+		frame.FPSCR = convertToUint32(data[basicFrameSize+len(frame.S)*4:], littleEndian)
+
+		fpccr, err := h.readDebugReg(fpuFpccr)
+
+		if err != nil {
+			return frame, err
+		}
+
+		if (fpccr & fpccrLspAct) > 0 {
+			fpcar, err := h.readDebugReg(fpuFpcar)
+
+			if err != nil {
+				return frame, err
+			}
+
+			frame.FpuLazy = (fpcar &^ 7) == sp+basicFrameSize
+		}
+	}
+
+	frame.Size = frameSize
+
+	if (frame.XPSR & xpsrStackAlignment) > 0 {
+		frame.Size += 4
+	}
+
+	frame.OriginalSP = sp + frame.Size
+
+	return frame, nil
+}
+
+// Fault status and address registers of the system control block
+type FaultRegisters struct {
+	CFSR  uint32 // configurable fault status (MMFSR, BFSR, UFSR)
+	HFSR  uint32 // hard fault status
+	DFSR  uint32 // debug fault status
+	MMFAR uint32 // memory management fault address
+	BFAR  uint32 // bus fault address
+	AFSR  uint32 // auxiliary fault status
+}
+
+// Read all fault status and fault address registers
+func (h *StLink) ReadFaultRegisters() (FaultRegisters, error) {
+	h, unlock := h.lock()
+	defer unlock()
+
+	faults := FaultRegisters{}
+
+	registers := []struct {
+		addr  uint32
+		value *uint32
+	}{
+		{scbCfsr, &faults.CFSR},
+		{scbHfsr, &faults.HFSR},
+		{scbDfsr, &faults.DFSR},
+		{scbMmfar, &faults.MMFAR},
+		{scbBfar, &faults.BFAR},
+		{scbAfsr, &faults.AFSR},
+	}
+
+	for _, reg := range registers {
+		value, err := h.readDebugReg(reg.addr)
+
+		if err != nil {
+			return faults, err
+		}
+
+		*reg.value = value
+	}
+
+	return faults, nil
+}
+
+const xpsrIpsrMask = 0x1ff // exception number, 0 in thread mode
+
+var exceptionNames = [...]string{
+	0:  "Thread",
+	1:  "Reset",
+	2:  "NMI",
+	3:  "HardFault",
+	4:  "MemManage",
+	5:  "BusFault",
+	6:  "UsageFault",
+	7:  "SecureFault",
+	11: "SVCall",
+	12: "DebugMonitor",
+	14: "PendSV",
+	15: "SysTick",
+}
+
+// Exception the core is executing, decoded from the IPSR part of xPSR
+type ActiveException struct {
+	Number uint32 // exception number, 0 in thread mode, 16 and above for external interrupts
+	Name   string // e.g. "HardFault" or "IRQ5"
+}
+
+// Core executes an exception handler
+func (e ActiveException) HandlerMode() bool {
+	return e.Number != 0
+}
+
+// Name of an exception number, external interrupts are named IRQn
+func ExceptionName(number uint32) string {
+	if number >= 16 {
+		return fmt.Sprintf("IRQ%d", number-16)
+	}
+
+	if name := exceptionNames[number]; name != "" {
+		return name
+	}
+
+	return fmt.Sprintf("Reserved%d", number)
+}
+
+// Decode the active exception from the registers
+func (regs *TargetRegisters) ActiveException() ActiveException {
+	number := regs.XPSR & xpsrIpsrMask
+
+	return ActiveException{Number: number, Name: ExceptionName(number)}
+}
+
+// Read xPSR and decode the exception the halted core is executing
+func (h *StLink) ReadActiveException() (ActiveException, error) {
+	h, unlock := h.lock()
+	defer unlock()
+
+	xpsr, err := h.GetRegister(registerXPSR)
+
+	if err != nil {
+		return ActiveException{}, err
+	}
+
+	regs := TargetRegisters{XPSR: xpsr}
+
+	return regs.ActiveException(), nil
+}
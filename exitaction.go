@@ -0,0 +1,100 @@
+// Copyright 2021 juju2013@github. All rights reserved.
+// Use of this source code is governed by a GNU-style
+// license that can be found in the LICENSE file.
+
+package gostlink
+
+import (
+	"time"
+)
+
+// State the target is left in by Close
+type ExitAction int
+
+const (
+	ExitActionNone ExitAction = iota // leave the target as it is (default)
+	ExitActionRun                    // release reset, disable debug and let the core run
+	ExitActionHalt                   // halt the core
+)
+
+func (a ExitAction) String() string {
+	switch a {
+	case ExitActionNone:
+		return "none"
+	case ExitActionRun:
+		return "run"
+	case ExitActionHalt:
+		return "halt"
+	default:
+		return "unknown"
+	}
+}
+
+// Select the state Close leaves the target in. To get it applied when the
+// program is interrupted, call Close from a signal handler (see os/signal).
+func (config *StLinkInterfaceConfig) SetExitAction(action ExitAction) {
+	config.exitAction = action
+}
+
+// Choose explicitly between leaving the core halted by Close, e.g. for a post-mortem
+// inspection by another tool, and resuming it. Shorthand for SetExitAction with
+// ExitActionHalt or ExitActionRun.
+func (config *StLinkInterfaceConfig) SetLeaveHalted(leaveHalted bool) {
+	if leaveHalted {
+		config.exitAction = ExitActionHalt
+	} else {
+		config.exitAction = ExitActionRun
+	}
+}
+
+// Exit action applied by Close
+func (h *StLink) ExitAction() ExitAction {
+	h, unlock := h.lock()
+	defer unlock()
+
+	return h.config.exitAction
+}
+
+func (h *StLink) runExitAction() error {
+	action := h.config.exitAction
+
+	if action == ExitActionNone || h.state == StateDisconnected {
+		return nil
+	}
+
+	if h.stMode != StLinkModeDebugSwd && h.stMode != StLinkModeDebugJtag {
+		return nil
+	}
+
+	h.log().Debugf("running exit action %s", action)
+
+	if err := h.UsbModeEnter(h.stMode); err != nil {
+		return err
+	}
+	defer h.UsbLeaveMode(h.stMode)
+
+	switch action {
+	case ExitActionRun:
+		if h.state == StateReset {
+			if err := h.usbAssertSrst(1); err != nil {
+				return err
+			}
+		}
+
+		// clearing C_DEBUGEN resumes the core and disables halting debug
+		if err := h.writeDebugReg(dcbDhcsr, dhcsrDbgKey); err != nil {
+			return err
+		}
+
+		h.setState(StateRunning)
+
+	case ExitActionHalt:
+		if err := h.usbHalt(); err != nil {
+			return err
+		}
+
+		return h.waitHalted(time.Second)
+	}
+
+	return nil
+}
@@ -0,0 +1,153 @@
+// Copyright 2021 juju2013@github. All rights reserved.
+// Use of this source code is governed by a GNU-style
+// license that can be found in the LICENSE file.
+
+package gostlink
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+const (
+	fillRoutineThreshold = 4096 // shorter fills are written directly over usb
+	fillChunkSize        = 1024 // pattern buffer size for direct fills
+)
+
+// Thumb routine storing r2 to r1 words starting at r0 and halting on its final
+// breakpoint, so it needs no stack. Uses only ARMv6-M instructions.
+var fillRoutine = []byte{
+	0x00, 0x29, // loop: cmp   r1, #0
+	0x02, 0xd0, //       beq   done
+	0x04, 0xc0, //       stmia r0!, {r2}
+	0x01, 0x39, //       subs  r1, #1
+	0xfa, 0xe7, //       b     loop
+	0x00, 0xbe, // done: bkpt  #0
+}
+
+const fillRoutineDone = 10 // offset of the final breakpoint in fillRoutine
+
+// Fill length bytes at addr with the 32bit value (in target byte order), both
+// addr and length have to be word aligned. Large fills run a small routine on the
+// target, so only the parameters are sent over usb. The routine is loaded into the
+// last bytes of the region itself and these are filled over usb afterwards, so no
+// ram outside the region is used and e.g. the whole SRAM including the stack can
+// be cleared. The region has to be executable ram then. This halts the core and
+// restores its registers afterwards.
+func (h *StLink) FillMem(addr uint32, length uint32, value uint32) error {
+	h, unlock := h.lock()
+	defer unlock()
+
+	if addr%4 != 0 || length%4 != 0 {
+		return fmt.Errorf("fill of %d bytes at 0x%08x is not word aligned: %w", length, addr, ErrUnalignedAccess)
+	}
+
+	if length == 0 {
+		return nil
+	}
+
+	if err := h.checkFlashWrite(addr, length); err != nil {
+		return err
+	}
+
+	if length < fillRoutineThreshold {
+		return h.fillDirect(addr, length, value)
+	}
+
+	if err := h.UsbModeEnter(h.stMode); err != nil {
+		return err
+	}
+	defer h.UsbLeaveMode(h.stMode)
+
+	if err := h.ensureHalted(); err != nil {
+		return err
+	}
+
+	saved, err := h.readRegisters()
+
+	if err != nil {
+		return err
+	}
+
+	routineAddr := addr + length - uint32(len(fillRoutine))
+
+	if err := h.WriteMem(routineAddr, Memory8BitBlock, uint32(len(fillRoutine)), fillRoutine); err != nil {
+		return err
+	}
+
+	runErr := h.runFillRoutine(routineAddr, []uint32{addr, (routineAddr - addr) / 4, value})
+
+	if err := h.writeRegisters(saved); err != nil {
+		return err
+	}
+
+	if runErr != nil {
+		return runErr
+	}
+
+	// the routine itself is overwritten last
+	return h.fillDirect(routineAddr, uint32(len(fillRoutine)), value)
+}
+
+// run the fill routine loaded at routineAddr with args in r0-r2 until it halts
+func (h *StLink) runFillRoutine(routineAddr uint32, args []uint32) error {
+	for i, arg := range args {
+		if err := h.writeRegister(uint8(i), arg); err != nil {
+			return err
+		}
+	}
+
+	if err := h.writeRegister(registerPC, routineAddr); err != nil {
+		return err
+	}
+	if err := h.writeRegister(registerXPSR, xpsrThumb); err != nil {
+		return err
+	}
+
+	if err := h.runUntilHalted(callFunctionTimeout); err != nil {
+		return err
+	}
+
+	pc, err := h.readRegister(registerPC)
+
+	if err != nil {
+		return err
+	}
+
+	if pc != routineAddr+fillRoutineDone {
+		return fmt.Errorf("fill routine halted at 0x%08x before finishing", pc)
+	}
+
+	return nil
+}
+
+func (h *StLink) fillDirect(addr uint32, length uint32, value uint32) error {
+	var order binary.ByteOrder = binary.LittleEndian
+
+	if h.targetEndian == bigEndian {
+		order = binary.BigEndian
+	}
+
+	chunk := make([]byte, fillChunkSize)
+
+	for i := 0; i < len(chunk); i += 4 {
+		order.PutUint32(chunk[i:], value)
+	}
+
+	for length > 0 {
+		size := length
+
+		if size > fillChunkSize {
+			size = fillChunkSize
+		}
+
+		if err := h.WriteMem(addr, Memory32BitBlock, size/4, chunk[:size]); err != nil {
+			return err
+		}
+
+		addr += size
+		length -= size
+	}
+
+	return nil
+}
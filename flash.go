@@ -0,0 +1,379 @@
+// Copyright 2021 juju2013@github. All rights reserved.
+// Use of this source code is governed by a GNU-style
+// license that can be found in the LICENSE file.
+
+package gostlink
+
+import (
+	"fmt"
+	"time"
+)
+
+// STM32F2/F4/F7 flash controller
+const (
+	flashSectorKeyr = 0x40023C04
+	flashSectorSr   = 0x40023C0C
+	flashSectorCr   = 0x40023C10
+
+	flashKey1 = 0x45670123
+	flashKey2 = 0xCDEF89AB
+
+	flashSrEop    = 1 << 0
+	flashSrErrors = 0xf2 // OPERR, WRPERR, PGAERR, PGPERR, PGSERR (ERSERR on F7)
+	flashSrBsy    = 1 << 16
+
+	flashCrPg       = 1 << 0
+	flashCrSer      = 1 << 1
+	flashCrSnbShift = 3
+	flashCrPsize8   = 0 << 8
+	flashCrPsize16  = 1 << 8
+	flashCrStrt     = 1 << 16
+	flashCrLock     = 1 << 31
+
+	flashSecondBankSnb = 4 // sectors of the second bank are numbered from 16 in SNB
+
+	flashBankSize        = 1024 * kB // size of one bank of dual bank parts
+	flashEraseTimeout    = 10 * time.Second
+	flashProgramTimeout  = time.Second
+	flashProgramChunk    = 4 * kB
+	flashDualBankMinSize = 2048 * kB
+)
+
+// One erasable flash sector
+type FlashSector struct {
+	Index   int // sector number as used by the flash controller
+	Address uint32
+	Size    uint32
+}
+
+// sector sizes of a flash bank: four small ones, one medium and large ones for the rest
+type flashSectorLayout struct {
+	sizeReg  uint32 // flash size register (kB)
+	small    uint32
+	medium   uint32
+	large    uint32
+	dualBank bool // 2MB parts have a second bank with the same layout
+}
+
+// layout of the sector based flash of a device
+func flashSectorLayoutOf(device *StmDeviceInfo) (flashSectorLayout, error) {
+	switch device.family {
+	case stm32FamilyF2, stm32FamilyF4:
+		dualBank := device.DevId == 0x419 || device.DevId == 0x434
+		return flashSectorLayout{sizeReg: 0x1FFF7A22, small: 16 * kB, medium: 64 * kB, large: 128 * kB, dualBank: dualBank}, nil
+	case stm32FamilyF7:
+		if device.DevId == 0x452 {
+			return flashSectorLayout{sizeReg: 0x1FF07A22, small: 16 * kB, medium: 64 * kB, large: 128 * kB}, nil
+		}
+		return flashSectorLayout{sizeReg: 0x1FF0F442, small: 32 * kB, medium: 128 * kB, large: 256 * kB}, nil
+	default:
+		return flashSectorLayout{}, fmt.Errorf("flash programming not supported for %s: %w", device.Family, ErrNotSupported)
+	}
+}
+
+// list the sectors of size bytes of flash, F7 dual bank mode is not handled
+func (l flashSectorLayout) sectors(size uint32) []FlashSector {
+	var sectors []FlashSector
+
+	bankSize := size
+	if l.dualBank && size >= flashDualBankMinSize {
+		bankSize = flashBankSize
+	}
+
+	for bank := uint32(0); bank*bankSize < size; bank++ {
+		addr := stm32FlashStart + bank*bankSize
+		end := addr + bankSize
+
+		for i := 0; addr < end; i++ {
+			sectorSize := l.large
+
+			if i < 4 {
+				sectorSize = l.small
+			} else if i == 4 {
+				sectorSize = l.medium
+			}
+
+			sectors = append(sectors, FlashSector{Index: len(sectors), Address: addr, Size: sectorSize})
+			addr += sectorSize
+		}
+	}
+
+	return sectors
+}
+
+// sector number written to SNB of the control register, the second bank of
+// dual bank parts starts after the 12 sectors of the first one
+func (s FlashSector) snb() uint32 {
+	if s.Index >= 12 {
+		return uint32(s.Index) + flashSecondBankSnb
+	}
+
+	return uint32(s.Index)
+}
+
+// Sectors overlapping the length bytes at addr
+func sectorsInRange(sectors []FlashSector, addr uint32, length uint32) []FlashSector {
+	var touched []FlashSector
+
+	end := uint64(addr) + uint64(length)
+
+	for _, s := range sectors {
+		if uint64(s.Address) < end && uint64(s.Address)+uint64(s.Size) > uint64(addr) {
+			touched = append(touched, s)
+		}
+	}
+
+	return touched
+}
+
+// List the flash sectors of the identified device, only STM32F2/F4/F7 are supported
+func (h *StLink) FlashSectors() ([]FlashSector, error) {
+	h, unlock := h.lock()
+	defer unlock()
+
+	device, err := h.IdentifyDevice()
+
+	if err != nil {
+		return nil, err
+	}
+
+	layout, err := flashSectorLayoutOf(device)
+
+	if err != nil {
+		return nil, err
+	}
+
+	sizeBuffer, err := h.readRegion(layout.sizeReg&^3, 4)
+
+	if err != nil {
+		return nil, err
+	}
+
+	sizeKb := convertToUint16(sizeBuffer[layout.sizeReg&3:], littleEndian)
+
+	return layout.sectors(uint32(sizeKb) * kB), nil
+}
+
+// Sectors of the identified device spanned by length bytes at addr, these are
+// the sectors FlashProgram erases
+func (h *StLink) FlashSectorsInRange(addr uint32, length uint32) ([]FlashSector, error) {
+	h, unlock := h.lock()
+	defer unlock()
+
+	sectors, err := h.FlashSectors()
+
+	if err != nil {
+		return nil, err
+	}
+
+	return sectorsInRange(sectors, addr, length), nil
+}
+
+// Program data into flash at addr through the flash controller of STM32F2/F4/F7
+// parts. If eraseFirst is set, the sectors spanning the range are erased before,
+// note that this also erases the data around the range in those sectors. The
+// core is halted while programming, a locked flash controller is unlocked and
+// locked again afterwards.
+func (h *StLink) FlashProgram(addr uint32, data []byte, eraseFirst bool) error {
+	h, unlock := h.lock()
+	defer unlock()
+
+	if err := h.UsbModeEnter(h.stMode); err != nil {
+		return err
+	}
+	defer h.UsbLeaveMode(h.stMode)
+
+	if addr%2 != 0 {
+		return fmt.Errorf("flash program address 0x%08x: %w", addr, ErrUnalignedAccess)
+	}
+
+	if len(data) == 0 {
+		return nil
+	}
+
+	touched, err := h.flashSectorsSpanned(addr, uint32(len(data)))
+
+	if err != nil {
+		return err
+	}
+
+	if !eraseFirst {
+		touched = nil
+	}
+
+	return h.flashProgram(addr, data, touched)
+}
+
+// sectors spanned by length bytes at addr, failing if the range is not entirely in flash
+func (h *StLink) flashSectorsSpanned(addr uint32, length uint32) ([]FlashSector, error) {
+	sectors, err := h.FlashSectors()
+
+	if err != nil {
+		return nil, err
+	}
+
+	touched := sectorsInRange(sectors, addr, length)
+	end := uint64(addr) + uint64(length)
+
+	if len(touched) == 0 || addr < touched[0].Address ||
+		end > uint64(touched[len(touched)-1].Address)+uint64(touched[len(touched)-1].Size) {
+		return nil, fmt.Errorf("flash range 0x%08x-0x%08x is outside the flash", addr, end)
+	}
+
+	return touched, nil
+}
+
+// erase the given sectors and program data at addr, the debug mode has to be entered
+func (h *StLink) flashProgram(addr uint32, data []byte, erase []FlashSector) error {
+	if err := h.ensureHalted(); err != nil {
+		return err
+	}
+
+	cr, err := h.readDebugReg(flashSectorCr)
+
+	if err != nil {
+		return err
+	}
+
+	if (cr & flashCrLock) > 0 {
+		if err = h.flashUnlock(); err != nil {
+			return err
+		}
+		defer h.writeDebugReg(flashSectorCr, flashCrLock)
+	}
+
+	for _, s := range erase {
+		if err = h.flashEraseSector(s); err != nil {
+			return err
+		}
+	}
+
+	return h.flashProgramData(addr, data)
+}
+
+func (h *StLink) flashUnlock() error {
+	if err := h.writeDebugReg(flashSectorKeyr, flashKey1); err != nil {
+		return err
+	}
+
+	if err := h.writeDebugReg(flashSectorKeyr, flashKey2); err != nil {
+		return err
+	}
+
+	cr, err := h.readDebugReg(flashSectorCr)
+
+	if err != nil {
+		return err
+	}
+
+	if (cr & flashCrLock) > 0 {
+		return fmt.Errorf("could not unlock flash controller: %w", ErrFlashLocked)
+	}
+
+	return nil
+}
+
+func (h *StLink) flashEraseSector(s FlashSector) error {
+	h.log().Debugf("erasing flash sector %d at 0x%08x (%d bytes)", s.Index, s.Address, s.Size)
+
+	snb := s.snb()
+
+	if err := h.flashClearStatus(); err != nil {
+		return err
+	}
+
+	cr := flashCrSer | snb<<flashCrSnbShift
+
+	if err := h.writeDebugReg(flashSectorCr, cr); err != nil {
+		return err
+	}
+
+	if err := h.writeDebugReg(flashSectorCr, cr|flashCrStrt); err != nil {
+		return err
+	}
+
+	if err := h.flashWaitReady(flashEraseTimeout); err != nil {
+		return fmt.Errorf("erase of sector %d failed: %w", s.Index, err)
+	}
+
+	return h.writeDebugReg(flashSectorCr, 0)
+}
+
+func (h *StLink) flashProgramData(addr uint32, data []byte) error {
+	if len(data)%2 != 0 {
+		// pad with the erased value, leaving the byte after the range unchanged
+		data = append(append([]byte(nil), data...), 0xff)
+	}
+
+	var blockSize MemoryBlockSize = Memory16BitBlock
+	var cr uint32 = flashCrPg | flashCrPsize16
+
+	// without 16bit memory access the flash is programmed byte wise
+	if !h.version.flags.Get(flagHasMem16Bit) {
+		blockSize = Memory8BitBlock
+		cr = flashCrPg | flashCrPsize8
+	}
+
+	if err := h.flashClearStatus(); err != nil {
+		return err
+	}
+
+	if err := h.writeDebugReg(flashSectorCr, cr); err != nil {
+		return err
+	}
+
+	for offset := 0; offset < len(data); offset += flashProgramChunk {
+		chunk := data[offset:]
+
+		if len(chunk) > flashProgramChunk {
+			chunk = chunk[:flashProgramChunk]
+		}
+
+		chunkAddr := addr + uint32(offset)
+
+		if err := h.WriteMem(chunkAddr, blockSize, uint32(len(chunk))/uint32(blockSize), chunk); err != nil {
+			return err
+		}
+
+		if err := h.flashWaitReady(flashProgramTimeout); err != nil {
+			return fmt.Errorf("programming at 0x%08x failed: %w", chunkAddr, err)
+		}
+	}
+
+	return h.writeDebugReg(flashSectorCr, 0)
+}
+
+func (h *StLink) flashClearStatus() error {
+	if err := h.flashWaitReady(flashEraseTimeout); err != nil {
+		return err
+	}
+
+	return h.writeDebugReg(flashSectorSr, flashSrEop|flashSrErrors)
+}
+
+// wait for the flash controller to finish the current operation and check its errors
+func (h *StLink) flashWaitReady(timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+
+	for {
+		sr, err := h.readDebugReg(flashSectorSr)
+
+		if err != nil {
+			return err
+		}
+
+		if (sr & flashSrBsy) == 0 {
+			if (sr & flashSrErrors) > 0 {
+				return fmt.Errorf("flash controller error, SR 0x%08x", sr)
+			}
+
+			return nil
+		}
+
+		if time.Now().After(deadline) {
+			return fmt.Errorf("timeout while waiting for flash controller: %w", ErrTimeout)
+		}
+
+		time.Sleep(haltPollInterval)
+	}
+}
@@ -0,0 +1,146 @@
+// Copyright 2021 juju2013@github. All rights reserved.
+// Use of this source code is governed by a GNU-style
+// license that can be found in the LICENSE file.
+
+package gostlink
+
+import (
+	"testing"
+)
+
+var (
+	f4Layout     = flashSectorLayout{small: 16 * kB, medium: 64 * kB, large: 128 * kB}
+	f4DualLayout = flashSectorLayout{small: 16 * kB, medium: 64 * kB, large: 128 * kB, dualBank: true}
+	f7Layout     = flashSectorLayout{small: 32 * kB, medium: 128 * kB, large: 256 * kB}
+)
+
+// sizes are the expected sector sizes in kB, the sectors of both banks follow
+// each other from the flash start
+func checkSectors(t *testing.T, sectors []FlashSector, sizes []uint32) {
+	t.Helper()
+
+	if len(sectors) != len(sizes) {
+		t.Fatalf("got %d sectors, expected %d", len(sectors), len(sizes))
+	}
+
+	addr := uint32(stm32FlashStart)
+
+	for i, s := range sectors {
+		if s.Index != i || s.Address != addr || s.Size != sizes[i]*kB {
+			t.Fatalf("sector %d: index %d at 0x%08x of %d bytes, expected 0x%08x of %d bytes",
+				i, s.Index, s.Address, s.Size, addr, sizes[i]*kB)
+		}
+
+		addr += s.Size
+	}
+}
+
+func TestFlashSectorLayoutSectors(t *testing.T) {
+	bank := []uint32{16, 16, 16, 16, 64, 128, 128, 128, 128, 128, 128, 128}
+
+	tests := []struct {
+		name   string
+		layout flashSectorLayout
+		size   uint32
+		sizes  []uint32
+	}{
+		{"f4 512k", f4Layout, 512 * kB, bank[:8]},
+		{"f4 1M", f4Layout, 1024 * kB, bank},
+		{"f4 dual bank part with 1M", f4DualLayout, 1024 * kB, bank},
+		{"f4 2M dual bank", f4DualLayout, 2048 * kB, append(append([]uint32{}, bank...), bank...)},
+		{"f7 0x452 512k", flashSectorLayout{small: 16 * kB, medium: 64 * kB, large: 128 * kB}, 512 * kB, bank[:8]},
+		{"f7 1M", f7Layout, 1024 * kB, []uint32{32, 32, 32, 32, 128, 256, 256, 256}},
+		{"f7 2M", f7Layout, 2048 * kB, []uint32{32, 32, 32, 32, 128, 256, 256, 256, 256, 256, 256, 256}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			checkSectors(t, tt.layout.sectors(tt.size), tt.sizes)
+		})
+	}
+}
+
+func TestFlashSectorLayoutOf(t *testing.T) {
+	tests := []struct {
+		device *StmDeviceInfo
+		layout flashSectorLayout
+	}{
+		{&StmDeviceInfo{DevId: 0x413, family: stm32FamilyF4}, flashSectorLayout{sizeReg: 0x1FFF7A22, small: 16 * kB, medium: 64 * kB, large: 128 * kB}},
+		{&StmDeviceInfo{DevId: 0x419, family: stm32FamilyF4}, flashSectorLayout{sizeReg: 0x1FFF7A22, small: 16 * kB, medium: 64 * kB, large: 128 * kB, dualBank: true}},
+		{&StmDeviceInfo{DevId: 0x452, family: stm32FamilyF7}, flashSectorLayout{sizeReg: 0x1FF07A22, small: 16 * kB, medium: 64 * kB, large: 128 * kB}},
+		{&StmDeviceInfo{DevId: 0x451, family: stm32FamilyF7}, flashSectorLayout{sizeReg: 0x1FF0F442, small: 32 * kB, medium: 128 * kB, large: 256 * kB}},
+	}
+
+	for _, tt := range tests {
+		layout, err := flashSectorLayoutOf(tt.device)
+
+		if err != nil {
+			t.Fatalf("device 0x%03x: %v", tt.device.DevId, err)
+		}
+
+		if layout != tt.layout {
+			t.Fatalf("device 0x%03x: layout %+v, expected %+v", tt.device.DevId, layout, tt.layout)
+		}
+	}
+}
+
+func TestFlashSectorSnb(t *testing.T) {
+	sectors := f4DualLayout.sectors(2048 * kB)
+
+	for _, s := range sectors {
+		expected := uint32(s.Index)
+
+		if s.Address >= stm32FlashStart+flashBankSize {
+			expected = uint32(s.Index-12) + 16
+		}
+
+		if s.snb() != expected {
+			t.Fatalf("sector %d at 0x%08x: snb %d, expected %d", s.Index, s.Address, s.snb(), expected)
+		}
+	}
+}
+
+func TestSectorsInRange(t *testing.T) {
+	sectors := f4DualLayout.sectors(2048 * kB)
+
+	tests := []struct {
+		name    string
+		addr    uint32
+		length  uint32
+		indexes []int
+	}{
+		{"empty", 0x08000000, 0, nil},
+		{"first byte", 0x08000000, 1, []int{0}},
+		{"whole first sector", 0x08000000, 16 * kB, []int{0}},
+		{"one byte past a sector", 0x08000000, 16*kB + 1, []int{0, 1}},
+		{"last byte of a sector", 0x08003fff, 1, []int{0}},
+		{"straddling small sectors", 0x08003fff, 2, []int{0, 1}},
+		{"small into medium", 0x0800c000, 32 * kB, []int{3, 4}},
+		{"medium into large", 0x0801ffff, 2, []int{4, 5}},
+		{"across the banks", 0x080ffff0, 0x20, []int{11, 12}},
+		{"second bank", 0x08104000, 4, []int{13}},
+		{"last byte", 0x081fffff, 1, []int{23}},
+		{"past the end", 0x08200000, 16, nil},
+		{"before the flash", 0x07fffff0, 0x20, []int{0}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			touched := sectorsInRange(sectors, tt.addr, tt.length)
+
+			if len(touched) != len(tt.indexes) {
+				t.Fatalf("got %d sectors %+v, expected indexes %v", len(touched), touched, tt.indexes)
+			}
+
+			for i, s := range touched {
+				if s.Index != tt.indexes[i] {
+					t.Fatalf("sector %d has index %d, expected %d", i, s.Index, tt.indexes[i])
+				}
+			}
+		})
+	}
+
+	if touched := sectorsInRange(sectors, 0xfffffff0, 0x20); touched != nil {
+		t.Fatalf("range wrapping the address space touched %+v", touched)
+	}
+}
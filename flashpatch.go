@@ -0,0 +1,202 @@
+// Copyright 2021 juju2013@github. All rights reserved.
+// Use of this source code is governed by a GNU-style
+// license that can be found in the LICENSE file.
+
+package gostlink
+
+import (
+	"errors"
+	"fmt"
+)
+
+const (
+	fpbRemap = 0xE0002004
+
+	fpbRemapSupported = 1 << 29 // RMPSPT
+	fpbRemapAddrMask  = 0x1fffffe0
+
+	fpbRemapTableAlign = 32
+	fpbRemapRegionBase = 0x20000000 // the remap table has to be in the SRAM region
+	fpbRemapRegionEnd  = 0x40000000
+
+	thumbLdrPcLiteral = 0xF000F8DF // "ldr.w pc, [pc, #0]"
+)
+
+// Set the address of the FPB remap table used by FlashPatch. It has to be 32 byte
+// aligned in the SRAM region, hold one word per FPB comparator and must not be used
+// by the target firmware.
+func (h *StLink) SetFlashPatchTable(addr uint32) error {
+	h, unlock := h.lock()
+	defer unlock()
+
+	if addr < fpbRemapRegionBase || addr >= fpbRemapRegionEnd {
+		return fmt.Errorf("remap table address 0x%08x is outside the SRAM region", addr)
+	}
+
+	if addr%fpbRemapTableAlign != 0 {
+		return fmt.Errorf("remap table address 0x%08x: %w", addr, ErrUnalignedAccess)
+	}
+
+	h.flashPatchTable = addr
+	return nil
+}
+
+// Redirect execution of the code at the word aligned flashAddr to ramAddr, e.g. a
+// patched copy of a function in RAM, without reflashing. Two FPB comparators remap
+// the flash words at flashAddr to a "ldr pc" loading ramAddr. Needs a remap table set
+// by SetFlashPatchTable and a FPB revision 1 (Cortex-M3/M4), later revisions
+// dropped remap support.
+func (h *StLink) FlashPatch(flashAddr uint32, ramAddr uint32) error {
+	h, unlock := h.lock()
+	defer unlock()
+
+	if err := h.UsbModeEnter(h.stMode); err != nil {
+		return err
+	}
+	defer h.UsbLeaveMode(h.stMode)
+
+	if h.flashPatchTable == 0 {
+		return errors.New("no flash patch remap table set")
+	}
+
+	if flashAddr%4 != 0 {
+		return fmt.Errorf("flash patch address 0x%08x: %w", flashAddr, ErrUnalignedAccess)
+	}
+
+	if flashAddr+8 > fpbV1MaxAddr {
+		return fmt.Errorf("flash patch address 0x%08x is outside the code region", flashAddr)
+	}
+
+	fpb, err := h.readFpbInfo()
+
+	if err != nil {
+		return err
+	}
+
+	if err := h.checkFpbRemap(fpb); err != nil {
+		return err
+	}
+
+	free, err := h.freeRemapComparators(fpb, flashAddr)
+
+	if err != nil {
+		return err
+	}
+
+	patch := []uint32{thumbLdrPcLiteral, ramAddr | 1}
+
+	for i, comp := range free {
+		if err := h.writeDebugReg(h.flashPatchTable+uint32(comp)*4, patch[i]); err != nil {
+			return err
+		}
+	}
+
+	if err := h.writeDebugReg(fpbRemap, h.flashPatchTable&fpbRemapAddrMask); err != nil {
+		return err
+	}
+
+	for i, comp := range free {
+		value := ((flashAddr + uint32(i)*4) & fpbCompV1AddrMask) | fpbCompEnable
+
+		if err := h.writeDebugReg(fpbComp0+uint32(comp)*4, value); err != nil {
+			return err
+		}
+	}
+
+	return h.writeDebugReg(fpbCtrl, fpbCtrlKey|fpbCtrlEnable)
+}
+
+// Remove a patch set by FlashPatch at flashAddr
+func (h *StLink) ClearFlashPatch(flashAddr uint32) error {
+	h, unlock := h.lock()
+	defer unlock()
+
+	if err := h.UsbModeEnter(h.stMode); err != nil {
+		return err
+	}
+	defer h.UsbLeaveMode(h.stMode)
+
+	fpb, err := h.readFpbInfo()
+
+	if err != nil {
+		return err
+	}
+
+	cleared := false
+
+	for i := 0; i < fpb.comparators; i++ {
+		comp, err := h.readDebugReg(fpbComp0 + uint32(i)*4)
+
+		if err != nil {
+			return err
+		}
+
+		if !isRemapComp(comp) {
+			continue
+		}
+
+		if addr := comp & fpbCompV1AddrMask; addr == flashAddr || addr == flashAddr+4 {
+			if err := h.writeDebugReg(fpbComp0+uint32(i)*4, 0); err != nil {
+				return err
+			}
+
+			cleared = true
+		}
+	}
+
+	if !cleared {
+		return fmt.Errorf("no flash patch set at 0x%08x", flashAddr)
+	}
+
+	return nil
+}
+
+func (h *StLink) checkFpbRemap(fpb fpbInfo) error {
+	if fpb.revision > 0 {
+		return fmt.Errorf("FPB revision %d has no flash remap: %w", fpb.revision+1, ErrNotSupported)
+	}
+
+	remap, err := h.readDebugReg(fpbRemap)
+
+	if err != nil {
+		return err
+	}
+
+	if (remap & fpbRemapSupported) == 0 {
+		return fmt.Errorf("FPB does not support flash remap: %w", ErrNotSupported)
+	}
+
+	return nil
+}
+
+// two free comparators for a patch at addr, failing if addr is already patched
+func (h *StLink) freeRemapComparators(fpb fpbInfo, addr uint32) ([]int, error) {
+	var free []int
+
+	for i := 0; i < fpb.comparators; i++ {
+		comp, err := h.readDebugReg(fpbComp0 + uint32(i)*4)
+
+		if err != nil {
+			return nil, err
+		}
+
+		if isRemapComp(comp) && (comp&fpbCompV1AddrMask) == addr {
+			return nil, fmt.Errorf("flash patch already set at 0x%08x", addr)
+		}
+
+		if (comp&fpbCompEnable) == 0 && len(free) < 2 {
+			free = append(free, i)
+		}
+	}
+
+	if len(free) < 2 {
+		return nil, errors.New("not enough free FPB comparators for a flash patch")
+	}
+
+	return free, nil
+}
+
+// an enabled v1 comparator without replace bits remaps instead of breaking
+func isRemapComp(comp uint32) bool {
+	return (comp&fpbCompEnable) > 0 && (comp&(fpbCompReplaceLower|fpbCompReplaceUpper)) == 0
+}
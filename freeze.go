@@ -0,0 +1,320 @@
+// Copyright 2021 juju2013@github. All rights reserved.
+// Use of this source code is governed by a GNU-style
+// license that can be found in the LICENSE file.
+
+package gostlink
+
+import (
+	"fmt"
+	"sort"
+)
+
+// bit in a DBGMCU freeze register stopping a peripheral while the core is halted
+type stm32FreezeBit struct {
+	name string
+	reg  uint32
+	bit  uint
+}
+
+var stm32FreezeF4 = []stm32FreezeBit{
+	{"TIM2", 0xE0042008, 0},
+	{"TIM3", 0xE0042008, 1},
+	{"TIM4", 0xE0042008, 2},
+	{"TIM5", 0xE0042008, 3},
+	{"TIM6", 0xE0042008, 4},
+	{"TIM7", 0xE0042008, 5},
+	{"TIM12", 0xE0042008, 6},
+	{"TIM13", 0xE0042008, 7},
+	{"TIM14", 0xE0042008, 8},
+	{"RTC", 0xE0042008, 10},
+	{"WWDG", 0xE0042008, 11},
+	{"IWDG", 0xE0042008, 12},
+	{"I2C1", 0xE0042008, 21},
+	{"I2C2", 0xE0042008, 22},
+	{"I2C3", 0xE0042008, 23},
+	{"CAN1", 0xE0042008, 25},
+	{"CAN2", 0xE0042008, 26},
+	{"TIM1", 0xE004200C, 0},
+	{"TIM8", 0xE004200C, 1},
+	{"TIM9", 0xE004200C, 16},
+	{"TIM10", 0xE004200C, 17},
+	{"TIM11", 0xE004200C, 18},
+}
+
+var stm32FreezeL4 = []stm32FreezeBit{
+	{"TIM2", 0xE0042008, 0},
+	{"TIM3", 0xE0042008, 1},
+	{"TIM4", 0xE0042008, 2},
+	{"TIM5", 0xE0042008, 3},
+	{"TIM6", 0xE0042008, 4},
+	{"TIM7", 0xE0042008, 5},
+	{"RTC", 0xE0042008, 10},
+	{"WWDG", 0xE0042008, 11},
+	{"IWDG", 0xE0042008, 12},
+	{"I2C1", 0xE0042008, 21},
+	{"I2C2", 0xE0042008, 22},
+	{"I2C3", 0xE0042008, 23},
+	{"CAN1", 0xE0042008, 25},
+	{"LPTIM1", 0xE0042008, 31},
+	{"I2C4", 0xE004200C, 1},
+	{"LPTIM2", 0xE004200C, 5},
+	{"TIM1", 0xE0042010, 11},
+	{"TIM8", 0xE0042010, 13},
+	{"TIM15", 0xE0042010, 16},
+	{"TIM16", 0xE0042010, 17},
+	{"TIM17", 0xE0042010, 18},
+}
+
+var stm32FreezeBits = map[stm32Family][]stm32FreezeBit{
+	stm32FamilyF0: {
+		{"TIM2", 0x40015808, 0},
+		{"TIM3", 0x40015808, 1},
+		{"TIM6", 0x40015808, 4},
+		{"TIM7", 0x40015808, 5},
+		{"TIM14", 0x40015808, 8},
+		{"RTC", 0x40015808, 10},
+		{"WWDG", 0x40015808, 11},
+		{"IWDG", 0x40015808, 12},
+		{"I2C1", 0x40015808, 21},
+		{"CAN", 0x40015808, 25},
+		{"TIM1", 0x4001580C, 11},
+		{"TIM15", 0x4001580C, 16},
+		{"TIM16", 0x4001580C, 17},
+		{"TIM17", 0x4001580C, 18},
+	},
+	stm32FamilyF1: {
+		{"IWDG", 0xE0042004, 8},
+		{"WWDG", 0xE0042004, 9},
+		{"TIM1", 0xE0042004, 10},
+		{"TIM2", 0xE0042004, 11},
+		{"TIM3", 0xE0042004, 12},
+		{"TIM4", 0xE0042004, 13},
+		{"CAN1", 0xE0042004, 14},
+		{"I2C1", 0xE0042004, 15},
+		{"I2C2", 0xE0042004, 16},
+		{"TIM8", 0xE0042004, 17},
+		{"TIM5", 0xE0042004, 18},
+		{"TIM6", 0xE0042004, 19},
+		{"TIM7", 0xE0042004, 20},
+		{"CAN2", 0xE0042004, 21},
+	},
+	stm32FamilyF2: stm32FreezeF4,
+	stm32FamilyF3: {
+		{"TIM2", 0xE0042008, 0},
+		{"TIM3", 0xE0042008, 1},
+		{"TIM4", 0xE0042008, 2},
+		{"TIM6", 0xE0042008, 4},
+		{"TIM7", 0xE0042008, 5},
+		{"RTC", 0xE0042008, 10},
+		{"WWDG", 0xE0042008, 11},
+		{"IWDG", 0xE0042008, 12},
+		{"I2C1", 0xE0042008, 21},
+		{"I2C2", 0xE0042008, 22},
+		{"CAN", 0xE0042008, 25},
+		{"TIM1", 0xE004200C, 0},
+		{"TIM8", 0xE004200C, 1},
+		{"TIM15", 0xE004200C, 2},
+		{"TIM16", 0xE004200C, 3},
+		{"TIM17", 0xE004200C, 4},
+	},
+	stm32FamilyF4: stm32FreezeF4,
+	stm32FamilyF7: {
+		{"TIM2", 0xE0042008, 0},
+		{"TIM3", 0xE0042008, 1},
+		{"TIM4", 0xE0042008, 2},
+		{"TIM5", 0xE0042008, 3},
+		{"TIM6", 0xE0042008, 4},
+		{"TIM7", 0xE0042008, 5},
+		{"TIM12", 0xE0042008, 6},
+		{"TIM13", 0xE0042008, 7},
+		{"TIM14", 0xE0042008, 8},
+		{"LPTIM1", 0xE0042008, 9},
+		{"RTC", 0xE0042008, 10},
+		{"WWDG", 0xE0042008, 11},
+		{"IWDG", 0xE0042008, 12},
+		{"I2C1", 0xE0042008, 21},
+		{"I2C2", 0xE0042008, 22},
+		{"I2C3", 0xE0042008, 23},
+		{"I2C4", 0xE0042008, 24},
+		{"CAN1", 0xE0042008, 25},
+		{"CAN2", 0xE0042008, 26},
+		{"TIM1", 0xE004200C, 0},
+		{"TIM8", 0xE004200C, 1},
+		{"TIM9", 0xE004200C, 16},
+		{"TIM10", 0xE004200C, 17},
+		{"TIM11", 0xE004200C, 18},
+	},
+	stm32FamilyG0: {
+		{"TIM2", 0x40015808, 0},
+		{"TIM3", 0x40015808, 1},
+		{"TIM6", 0x40015808, 4},
+		{"TIM7", 0x40015808, 5},
+		{"RTC", 0x40015808, 10},
+		{"WWDG", 0x40015808, 11},
+		{"IWDG", 0x40015808, 12},
+		{"I2C1", 0x40015808, 21},
+		{"LPTIM2", 0x40015808, 30},
+		{"LPTIM1", 0x40015808, 31},
+		{"TIM1", 0x4001580C, 11},
+		{"TIM14", 0x4001580C, 15},
+		{"TIM15", 0x4001580C, 16},
+		{"TIM16", 0x4001580C, 17},
+		{"TIM17", 0x4001580C, 18},
+	},
+	stm32FamilyL0: {
+		{"TIM2", 0x40015808, 0},
+		{"TIM3", 0x40015808, 1},
+		{"TIM6", 0x40015808, 4},
+		{"TIM7", 0x40015808, 5},
+		{"RTC", 0x40015808, 10},
+		{"WWDG", 0x40015808, 11},
+		{"IWDG", 0x40015808, 12},
+		{"I2C1", 0x40015808, 21},
+		{"I2C2", 0x40015808, 22},
+		{"I2C3", 0x40015808, 23},
+		{"LPTIM1", 0x40015808, 31},
+		{"TIM21", 0x4001580C, 2},
+		{"TIM22", 0x4001580C, 5},
+	},
+	stm32FamilyL1: {
+		{"TIM2", 0xE0042008, 0},
+		{"TIM3", 0xE0042008, 1},
+		{"TIM4", 0xE0042008, 2},
+		{"TIM5", 0xE0042008, 3},
+		{"TIM6", 0xE0042008, 4},
+		{"TIM7", 0xE0042008, 5},
+		{"RTC", 0xE0042008, 10},
+		{"WWDG", 0xE0042008, 11},
+		{"IWDG", 0xE0042008, 12},
+		{"I2C1", 0xE0042008, 21},
+		{"I2C2", 0xE0042008, 22},
+		{"TIM9", 0xE004200C, 2},
+		{"TIM10", 0xE004200C, 3},
+		{"TIM11", 0xE004200C, 4},
+	},
+	stm32FamilyG4: stm32FreezeL4,
+	stm32FamilyL4: stm32FreezeL4,
+}
+
+// Peripherals stopped while the core is halted, keyed by peripheral name (e.g. "TIM1", "CAN1")
+type FreezeConfig map[string]bool
+
+// Sorted names of the peripherals in the configuration
+func (c FreezeConfig) Peripherals() []string {
+	names := make([]string, 0, len(c))
+
+	for name := range c {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+	return names
+}
+
+func (h *StLink) freezeBits() ([]stm32FreezeBit, error) {
+	device, err := h.IdentifyDevice()
+
+	if err != nil {
+		return nil, err
+	}
+
+	bits, ok := stm32FreezeBits[device.family]
+
+	if !ok {
+		return nil, fmt.Errorf("peripheral freeze configuration not supported for %s: %w", device.Family, ErrNotSupported)
+	}
+
+	return bits, nil
+}
+
+// Read the DBGMCU freeze registers and report the freeze state of every
+// peripheral known for the device family
+func (h *StLink) ReadFreezeConfig() (FreezeConfig, error) {
+	h, unlock := h.lock()
+	defer unlock()
+
+	bits, err := h.freezeBits()
+
+	if err != nil {
+		return nil, err
+	}
+
+	registers := make(map[uint32]uint32)
+	config := make(FreezeConfig)
+
+	for _, b := range bits {
+		value, ok := registers[b.reg]
+
+		if !ok {
+			if value, err = h.readDebugReg(b.reg); err != nil {
+				return nil, err
+			}
+
+			registers[b.reg] = value
+		}
+
+		config[b.name] = (value & (1 << b.bit)) > 0
+	}
+
+	return config, nil
+}
+
+// Change the freeze state of the peripherals listed in config, peripherals
+// not listed keep their state
+func (h *StLink) WriteFreezeConfig(config FreezeConfig) error {
+	h, unlock := h.lock()
+	defer unlock()
+
+	bits, err := h.freezeBits()
+
+	if err != nil {
+		return err
+	}
+
+	byName := make(map[string]stm32FreezeBit)
+
+	for _, b := range bits {
+		byName[b.name] = b
+	}
+
+	set := make(map[uint32]uint32)
+	clear := make(map[uint32]uint32)
+
+	for _, name := range config.Peripherals() {
+		b, ok := byName[name]
+
+		if !ok {
+			return fmt.Errorf("no freeze bit for peripheral %s", name)
+		}
+
+		if config[name] {
+			set[b.reg] |= 1 << b.bit
+		} else {
+			clear[b.reg] |= 1 << b.bit
+		}
+	}
+
+	for _, b := range bits {
+		setBits, clearBits := set[b.reg], clear[b.reg]
+
+		if setBits == 0 && clearBits == 0 {
+			continue
+		}
+
+		value, err := h.readDebugReg(b.reg)
+
+		if err != nil {
+			return err
+		}
+
+		if err = h.writeDebugReg(b.reg, (value&^clearBits)|setBits); err != nil {
+			return err
+		}
+
+		// register done, skip its remaining bits
+		delete(set, b.reg)
+		delete(clear, b.reg)
+	}
+
+	return nil
+}
@@ -0,0 +1,220 @@
+// Copyright 2021 juju2013@github. All rights reserved.
+// Use of this source code is governed by a GNU-style
+// license that can be found in the LICENSE file.
+
+package gostlink
+
+import (
+	"time"
+)
+
+// ITM/DWT trace packet headers
+const (
+	itmSyncMinZeros  = 5 // a sync packet is at least 47 zero bits followed by a one
+	itmSyncEnd       = 0x80
+	itmOverflow      = 0x70
+	itmGlobalTs1     = 0x94
+	itmGlobalTs2     = 0xb4
+	itmContinuation  = 0x80
+	itmSourceSizeMsk = 0x03
+	itmSourceHwBit   = 0x04
+	itmExtensionMsk  = 0x0b
+	itmExtension     = 0x08
+)
+
+type itmState int
+
+const (
+	itmStateHeader itmState = iota
+	itmStateSource
+	itmStateLocalTs
+	itmStateSkip // global timestamp and extension payload
+)
+
+// Stimulus (or DWT hardware source) packet decoded from the trace stream
+type ItmEvent struct {
+	Port      uint8  // stimulus port or hardware source id
+	Hardware  bool   // emitted by the DWT instead of a stimulus port
+	Data      []byte // payload of 1, 2 or 4 bytes
+	Timestamp uint64 // accumulated local timestamp in timestamp clock ticks
+	Delayed   bool   // the timestamp was delayed against its data, it is not exact
+}
+
+// Time of the event since trace start with the given timestamp clock, that
+// is the trace clock divided by the timestamp prescaler of ITM_TCR
+func (e ItmEvent) Time(timestampHz uint32) time.Duration {
+	if timestampHz == 0 {
+		return 0
+	}
+
+	return time.Duration(e.Timestamp * uint64(time.Second) / uint64(timestampHz))
+}
+
+type ItmEventCb func(ItmEvent)
+
+// Stream decoder for ITM trace data, e.g. fed from a TraceReader callback.
+// With timestamps enabled, events are held back until the local timestamp
+// packet following them arrives, and are emitted with the accumulated
+// timestamp. Otherwise events are emitted immediately with the last timestamp.
+type ItmDecoder struct {
+	callback   ItmEventCb
+	timestamps bool
+
+	state     itmState
+	zeros     int
+	header    byte
+	payload   []byte
+	remaining int
+	tsValue   uint64
+	tsShift   uint
+
+	timestamp uint64
+	pending   []ItmEvent
+	overflows int
+
+	log handleLog
+}
+
+func NewItmDecoder(callback ItmEventCb, timestamps bool) *ItmDecoder {
+	return &ItmDecoder{callback: callback, timestamps: timestamps}
+}
+
+// Create an ItmDecoder logging at the level set for this handle with SetLogLevel
+func (h *StLink) NewItmDecoder(callback ItmEventCb, timestamps bool) *ItmDecoder {
+	return &ItmDecoder{callback: callback, timestamps: timestamps, log: h.log()}
+}
+
+// Accumulated local timestamp of the last timestamp packet
+func (d *ItmDecoder) Timestamp() uint64 {
+	return d.timestamp
+}
+
+// Number of overflow packets seen, data was lost by the target then
+func (d *ItmDecoder) Overflows() int {
+	return d.overflows
+}
+
+// Decode the next chunk of trace data, packets may span several chunks
+func (d *ItmDecoder) Write(data []byte) (int, error) {
+	for _, b := range data {
+		d.decodeByte(b)
+	}
+
+	return len(data), nil
+}
+
+// Emit events still waiting for their timestamp, e.g. when the trace stopped
+func (d *ItmDecoder) Flush() {
+	d.emitPending()
+}
+
+func (d *ItmDecoder) decodeByte(b byte) {
+	switch d.state {
+	case itmStateSource:
+		d.payload = append(d.payload, b)
+		d.remaining--
+
+		if d.remaining == 0 {
+			d.sourceDone()
+		}
+
+	case itmStateLocalTs:
+		d.tsValue |= uint64(b&0x7f) << d.tsShift
+		d.tsShift += 7
+
+		if (b & itmContinuation) == 0 {
+			d.localTimestamp(d.tsValue, (d.header>>4)&0x03 != 0)
+		}
+
+	case itmStateSkip:
+		if (b & itmContinuation) == 0 {
+			d.state = itmStateHeader
+		}
+
+	default:
+		d.decodeHeader(b)
+	}
+}
+
+func (d *ItmDecoder) decodeHeader(b byte) {
+	if b == 0 {
+		d.zeros++
+		return
+	}
+
+	zeros := d.zeros
+	d.zeros = 0
+
+	switch {
+	case b == itmSyncEnd && zeros >= itmSyncMinZeros:
+		d.log.Trace("itm sync")
+
+	case b == itmOverflow:
+		d.overflows++
+		d.log.Warn("itm overflow, trace data was lost")
+
+	case (b&0x8f) == 0 && (b&0x70) != 0:
+		// local timestamp format 2, value in header
+		d.localTimestamp(uint64(b>>4), false)
+
+	case (b & 0xcf) == 0xc0:
+		// local timestamp format 1, value in continuation bytes
+		d.header = b
+		d.tsValue = 0
+		d.tsShift = 0
+		d.state = itmStateLocalTs
+
+	case b == itmGlobalTs1 || b == itmGlobalTs2 || (b&itmExtensionMsk) == itmExtension:
+		if (b & itmContinuation) > 0 {
+			d.state = itmStateSkip
+		}
+
+	case (b & itmSourceSizeMsk) != 0:
+		d.header = b
+		d.payload = make([]byte, 0, 4)
+		d.remaining = 1 << ((b & itmSourceSizeMsk) - 1)
+		d.state = itmStateSource
+
+	default:
+		d.log.Debugf("unknown itm header 0x%02x", b)
+	}
+}
+
+func (d *ItmDecoder) sourceDone() {
+	d.state = itmStateHeader
+
+	event := ItmEvent{
+		Port:      d.header >> 3,
+		Hardware:  (d.header & itmSourceHwBit) > 0,
+		Data:      d.payload,
+		Timestamp: d.timestamp,
+	}
+
+	if d.timestamps {
+		d.pending = append(d.pending, event)
+	} else {
+		d.callback(event)
+	}
+}
+
+// a local timestamp carries the time since the previous one and belongs to
+// the packets received in between
+func (d *ItmDecoder) localTimestamp(delta uint64, delayed bool) {
+	d.state = itmStateHeader
+	d.timestamp += delta
+
+	for i := range d.pending {
+		d.pending[i].Timestamp = d.timestamp
+		d.pending[i].Delayed = delayed
+	}
+
+	d.emitPending()
+}
+
+func (d *ItmDecoder) emitPending() {
+	for _, event := range d.pending {
+		d.callback(event)
+	}
+
+	d.pending = nil
+}
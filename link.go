@@ -0,0 +1,88 @@
+// Copyright 2021 juju2013@github. All rights reserved.
+// Use of this source code is governed by a GNU-style
+// license that can be found in the LICENSE file.
+
+package gostlink
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Recover a wedged debug link without closing the handle. The st-link
+// firmware issues the swj line reset and the jtag to swd switch sequence when
+// entering debug mode, so the debug mode is left and entered again and the
+// access ports in use are initialized again.
+func (h *StLink) RecoverLink() error {
+	h, unlock := h.lock()
+	defer unlock()
+
+	if h.stMode != StLinkModeDebugSwd && h.stMode != StLinkModeDebugJtag {
+		return errors.New("link recovery requires swd or jtag mode")
+	}
+
+	if err := h.usbLeaveMode(h.stMode); err != nil {
+		h.log().Warn("error while leaving debug mode: ", err)
+	}
+
+	if err := h.usbModeEnter(h.stMode); err != nil {
+		return fmt.Errorf("could not enter debug mode again: %w", err)
+	}
+
+	if err := h.reopenAccessPorts(); err != nil {
+		return err
+	}
+
+	h.stuckReads = 0
+
+	h.log().Info("debug link recovered")
+	return nil
+}
+
+// count consecutive single word reads that failed and returned only ones or
+// only zeros, and recover the link once the configured number is reached. Any
+// successful read resets the count, so polling a register that really reads as
+// zero is not taken for a stuck link. Returns readErr unless the link was recovered.
+func (h *StLink) checkStuckRead(data []byte, readErr error) error {
+	if h.config.recoverStuckReads <= 0 {
+		return readErr
+	}
+
+	if readErr == nil {
+		h.stuckReads = 0
+		return nil
+	}
+
+	// a wait response is retried and says nothing about the link
+	if errors.Is(readErr, ErrProbeBusy) {
+		return readErr
+	}
+
+	stuck := len(data) > 0
+
+	for _, b := range data {
+		if b != data[0] {
+			stuck = false
+			break
+		}
+	}
+
+	if !stuck || (data[0] != 0x00 && data[0] != 0xff) {
+		h.stuckReads = 0
+		return readErr
+	}
+
+	h.stuckReads++
+
+	if h.stuckReads < h.config.recoverStuckReads {
+		return readErr
+	}
+
+	h.log().Warnf("%d consecutive reads failed returning 0x%02x, recovering debug link", h.stuckReads, data[0])
+
+	if err := h.RecoverLink(); err != nil {
+		return err
+	}
+
+	return fmt.Errorf("debug link was stuck and has been recovered, data read is not valid: %w", readErr)
+}
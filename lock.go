@@ -0,0 +1,77 @@
+// Copyright 2021 juju2013@github. All rights reserved.
+// Use of this source code is governed by a GNU-style
+// license that can be found in the LICENSE file.
+
+package gostlink
+
+import (
+	"bytes"
+	"runtime"
+	"strconv"
+	"sync/atomic"
+)
+
+// Take the command lock of the handle. Commands are composed of several usb
+// transactions (e.g. a memory read followed by the read/write status query)
+// which all have to run under the lock, so every exported operation locks
+// once and runs the rest on the returned view of the handle. Calls on the
+// view itself do not lock again, neither do calls from the goroutine running
+// the callback of WithLock, WithDebugMode or WithSpeed. The returned function
+// releases the lock.
+func (h *StLink) lock() (*StLink, func()) {
+	view := &StLink{stLinkState: h.stLinkState, locked: true}
+
+	if h.locked {
+		return h, func() {}
+	}
+
+	if owner := atomic.LoadUint64(&h.lockOwner); owner != 0 && owner == goroutineId() {
+		return view, func() {}
+	}
+
+	h.cmdLock.Lock()
+	return view, h.cmdLock.Unlock
+}
+
+// view of the handle which takes the lock for every operation, used by
+// goroutines and objects keeping a reference to the handle
+func (h *StLink) unlocked() *StLink {
+	return &StLink{stLinkState: h.stLinkState}
+}
+
+// run fn on a locked view, operations fn calls on any view of the handle from
+// the current goroutine run under the lock already held
+func (h *StLink) runLocked(fn func() error) error {
+	if atomic.LoadUint64(&h.lockOwner) != 0 {
+		// nested callback, the lock is held for this goroutine already
+		return fn()
+	}
+
+	atomic.StoreUint64(&h.lockOwner, goroutineId())
+	defer atomic.StoreUint64(&h.lockOwner, 0)
+
+	return fn()
+}
+
+func goroutineId() uint64 {
+	var buf [64]byte
+
+	// stack trace starts with "goroutine <id> ["
+	trace := buf[:runtime.Stack(buf[:], false)]
+	trace = bytes.TrimPrefix(trace, []byte("goroutine "))
+	trace = trace[:bytes.IndexByte(trace, ' ')]
+
+	id, _ := strconv.ParseUint(string(trace), 10, 64)
+	return id
+}
+
+// Run fn while holding the command lock of the handle, so a sequence of
+// operations (e.g. read, modify, write) cannot interleave with commands
+// issued by other goroutines. Operations on the handle must be called from
+// the goroutine running fn, other goroutines block until fn returned.
+func (h *StLink) WithLock(fn func() error) error {
+	h, unlock := h.lock()
+	defer unlock()
+
+	return h.runLocked(fn)
+}
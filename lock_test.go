@@ -0,0 +1,58 @@
+// Copyright 2021 juju2013@github. All rights reserved.
+// Use of this source code is governed by a GNU-style
+// license that can be found in the LICENSE file.
+
+package gostlink
+
+import (
+	"testing"
+	"time"
+)
+
+func TestWithLockReentrant(t *testing.T) {
+	h := &StLink{stLinkState: &stLinkState{}}
+
+	entered := make(chan struct{})
+	other := make(chan struct{})
+
+	err := h.WithLock(func() error {
+		// the outer handle is used from the goroutine holding the lock
+		inner, unlock := h.lock()
+		unlock()
+
+		if !inner.locked {
+			t.Error("view returned inside WithLock is not locked")
+		}
+
+		go func() {
+			close(entered)
+			_, unlock := h.lock()
+			unlock()
+			close(other)
+		}()
+
+		<-entered
+
+		select {
+		case <-other:
+			t.Error("other goroutine took the lock held by WithLock")
+		case <-time.After(20 * time.Millisecond):
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case <-other:
+	case <-time.After(time.Second):
+		t.Fatal("lock not released after WithLock returned")
+	}
+
+	if h.lockOwner != 0 {
+		t.Errorf("lock owner %d kept after WithLock returned", h.lockOwner)
+	}
+}
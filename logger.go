@@ -20,3 +20,111 @@ func init() {
 func SetLogger(loggerInstance *logrus.Logger) {
 	logger = loggerInstance
 }
+
+// Verbosity of the logging of one handle
+type LogLevel int
+
+const (
+	LogOff LogLevel = iota
+	LogError
+	LogWarn
+	LogInfo
+	LogDebug
+	LogTrace
+)
+
+// Set the verbosity of this handle independent of the other handles, e.g. to
+// silence all but one of several probes. Messages are written through the
+// package logger, so the handle only drops messages the package logger would
+// write: to trace one probe set the package logger to trace and lower the level
+// of the other handles. See StLinkInterfaceConfig.SetLogLevel for the level
+// used while connecting.
+func (h *StLink) SetLogLevel(level LogLevel) {
+	h, unlock := h.lock()
+	defer unlock()
+
+	h.logLevel.Store(level)
+}
+
+// Log level of the handle opened with this configuration, applied before the
+// st-link is searched so also the connect messages are filtered, see
+// StLink.SetLogLevel
+func (config *StLinkInterfaceConfig) SetLogLevel(level LogLevel) {
+	config.logLevel = &level
+}
+
+// level of a handle's logger
+func (level LogLevel) logrusLevel() logrus.Level {
+	switch level {
+	case LogError:
+		return logrus.ErrorLevel
+	case LogWarn:
+		return logrus.WarnLevel
+	case LogInfo:
+		return logrus.InfoLevel
+	case LogDebug:
+		return logrus.DebugLevel
+	default:
+		return logrus.TraceLevel
+	}
+}
+
+// Logging of a handle through the package logger, filtered by the level set
+// with SetLogLevel if any. The zero value logs like the package logger.
+type handleLog struct {
+	level LogLevel
+	set   bool
+}
+
+// logger of the handle, the package logger unless a level was set
+func (h *StLink) log() handleLog {
+	level, set := h.logLevel.Load().(LogLevel)
+	return handleLog{level: level, set: set}
+}
+
+// whether a message at level passes both the handle and the package logger level
+func (l handleLog) enabled(level logrus.Level) bool {
+	if l.set && (l.level == LogOff || level > l.level.logrusLevel()) {
+		return false
+	}
+
+	return logger.IsLevelEnabled(level)
+}
+
+func (l handleLog) print(level logrus.Level, args ...interface{}) {
+	if l.enabled(level) {
+		logger.Log(level, args...)
+	}
+}
+
+func (l handleLog) printf(level logrus.Level, format string, args ...interface{}) {
+	if l.enabled(level) {
+		logger.Logf(level, format, args...)
+	}
+}
+
+func (l handleLog) Trace(args ...interface{}) { l.print(logrus.TraceLevel, args...) }
+func (l handleLog) Debug(args ...interface{}) { l.print(logrus.DebugLevel, args...) }
+func (l handleLog) Info(args ...interface{})  { l.print(logrus.InfoLevel, args...) }
+func (l handleLog) Warn(args ...interface{})  { l.print(logrus.WarnLevel, args...) }
+func (l handleLog) Error(args ...interface{}) { l.print(logrus.ErrorLevel, args...) }
+
+func (l handleLog) Tracef(format string, args ...interface{}) {
+	l.printf(logrus.TraceLevel, format, args...)
+}
+
+func (l handleLog) Debugf(format string, args ...interface{}) {
+	l.printf(logrus.DebugLevel, format, args...)
+}
+
+func (l handleLog) Infof(format string, args ...interface{}) {
+	l.printf(logrus.InfoLevel, format, args...)
+}
+
+func (l handleLog) Warnf(format string, args ...interface{}) {
+	l.printf(logrus.WarnLevel, format, args...)
+}
+
+func (l handleLog) Errorf(format string, args ...interface{}) {
+	l.printf(logrus.ErrorLevel, format, args...)
+}
@@ -6,11 +6,34 @@ package gostlink
 
 import (
 	"bytes"
+	"errors"
 	"fmt"
+	"time"
 )
 
+const (
+	cStringChunkSize        = 32
+	readVerifyMaxMismatches = 8 // disagreeing reads tolerated by ReadVerified
+	verifyChunkSize         = 4096
+	snapshotHaltTimeout     = 100 * time.Millisecond
+)
+
+// Returned by VerifyMem for the first byte differing from the expected data
+type VerifyError struct {
+	Addr     uint32
+	Expected byte
+	Actual   byte
+}
+
+func (e *VerifyError) Error() string {
+	return fmt.Sprintf("verify failed at 0x%08x: expected 0x%02x, read 0x%02x", e.Addr, e.Expected, e.Actual)
+}
+
 // Read (len * 1) bytes from Target's memory
 func (h *StLink) UsbReadMem8(addr uint32, len uint16, buffer *bytes.Buffer) error {
+	h, unlock := h.lock()
+	defer unlock()
+
 	var readLen = uint32(len)
 
 	/* max 8 bit read/write is 64 bytes or 512 bytes for v3 */
@@ -25,6 +48,7 @@ func (h *StLink) UsbReadMem8(addr uint32, len uint16, buffer *bytes.Buffer) erro
 
 	ctx.cmdBuf.WriteUint32LE(addr)
 	ctx.cmdBuf.WriteUint16LE(len)
+	h.writeApSelection(ctx)
 
 	// we need to fix read length for single bytes
 	if readLen == 1 {
@@ -40,11 +64,14 @@ func (h *StLink) UsbReadMem8(addr uint32, len uint16, buffer *bytes.Buffer) erro
 
 	buffer.Write(ctx.DataBytes())
 
-	return h.usbGetReadWriteStatus()
+	return h.usbReadStatusCheck()
 }
 
 // Read ((len/2) * 2) bytes from Target's memory, addr must be 16bit aligned
 func (h *StLink) UsbReadMem16(addr uint32, len uint16, buffer *bytes.Buffer) error {
+	h, unlock := h.lock()
+	defer unlock()
+
 	if !h.version.flags.Get(flagHasMem16Bit) {
 		return newUsbError("Read16 command not supported by device", usbErrorCommandNotFound)
 	}
@@ -54,6 +81,10 @@ func (h *StLink) UsbReadMem16(addr uint32, len uint16, buffer *bytes.Buffer) err
 		return newUsbError("ReadMem16 Invalid data alignment", usbErrorTargetUnalignedAccess)
 	}
 
+	if uint32(len) > h.maxDataStage() {
+		return newUsbError(fmt.Sprintf("max buffer (%d) length exceeded", h.maxDataStage()), usbErrorFail)
+	}
+
 	ctx := h.initTransfer(transferIncoming)
 
 	ctx.cmdBuf.WriteByte(cmdDebug)
@@ -61,6 +92,7 @@ func (h *StLink) UsbReadMem16(addr uint32, len uint16, buffer *bytes.Buffer) err
 
 	ctx.cmdBuf.WriteUint32LE(addr)
 	ctx.cmdBuf.WriteUint16LE(len)
+	h.writeApSelection(ctx)
 
 	err := h.usbTransferNoErrCheck(ctx, uint32(len))
 
@@ -70,17 +102,23 @@ func (h *StLink) UsbReadMem16(addr uint32, len uint16, buffer *bytes.Buffer) err
 
 	buffer.Write(ctx.DataBytes())
 
-	return h.usbGetReadWriteStatus()
+	return h.usbReadStatusCheck()
 }
 
 // Read ((len/4) * 4) bytes from Target's memory, addr must be 32bit aligned
 func (h *StLink) UsbReadMem32(addr uint32, len uint16, buffer *bytes.Buffer) error {
+	h, unlock := h.lock()
+	defer unlock()
 
 	/* data must be a multiple of 4 and word aligned */
 	if ((len % 4) > 0) || ((addr % 4) > 0) {
 		return newUsbError("ReadMem32 Invalid data alignment", usbErrorTargetUnalignedAccess)
 	}
 
+	if uint32(len) > h.maxDataStage() {
+		return newUsbError(fmt.Sprintf("max buffer (%d) length exceeded", h.maxDataStage()), usbErrorFail)
+	}
+
 	ctx := h.initTransfer(transferIncoming)
 
 	ctx.cmdBuf.WriteByte(cmdDebug)
@@ -88,6 +126,7 @@ func (h *StLink) UsbReadMem32(addr uint32, len uint16, buffer *bytes.Buffer) err
 
 	ctx.cmdBuf.WriteUint32LE(addr)
 	ctx.cmdBuf.WriteUint16LE(len)
+	h.writeApSelection(ctx)
 
 	err := h.usbTransferNoErrCheck(ctx, uint32(len))
 
@@ -97,46 +136,122 @@ func (h *StLink) UsbReadMem32(addr uint32, len uint16, buffer *bytes.Buffer) err
 
 	buffer.Write(ctx.DataBytes())
 
-	return h.usbGetReadWriteStatus()
+	err = h.usbReadStatusCheck()
+
+	if len == 4 {
+		return h.checkStuckRead(ctx.DataBytes()[:4], err)
+	}
+
+	return err
 }
 
-// Read len bytes from Target's memory, NO aligment needed for add and len 
+// Read len bytes from Target's memory, NO aligment needed for add and len.
+// Whole words are read with 32bit accesses, split like ReadMem.
 func (h *StLink) UsbReadMem(addr uint32, len uint16, buffer *bytes.Buffer) error {
+  h, unlock := h.lock()
+  defer unlock()
+
+  return h.usbReadMem(addr, uint32(len), buffer, h.readMemChunk)
+}
+
+func (h *StLink) usbReadMem(addr uint32, length uint32, buffer *bytes.Buffer, read func(memChunk, *bytes.Buffer) error) error {
+  chunks := splitMemAccess(addr, length, Memory32BitBlock, h.maxMemPacket, h.usbBlock())
+  return h.readMemChunks(chunks, buffer, read)
+}
+
+// Read count 32bit words from the same address without incrementing it, e.g. to
+// drain a peripheral fifo or a buffer that a target DMA channel keeps refilling.
+// Falls back to single word reads on st-links without native support.
+func (h *StLink) ReadMemNoIncrement(addr uint32, count uint32) ([]uint32, error) {
+	h, unlock := h.lock()
+	defer unlock()
+
+	if (addr % 4) > 0 {
+		return nil, newUsbError("ReadMemNoIncrement Invalid data alignment", usbErrorTargetUnalignedAccess)
+	}
+
+	values := make([]uint32, 0, count)
+
+	if !h.version.flags.Get(flagHasMemRdNoInc) {
+		for i := uint32(0); i < count; i++ {
+			value, err := h.readDebugReg(addr)
+
+			if err != nil {
+				return values, err
+			}
+
+			values = append(values, value)
+		}
+
+		return values, nil
+	}
+
+	for count > 0 {
+		words := count
+
+		if words > h.maxMemPacket/4 {
+			words = h.maxMemPacket / 4
+		}
+
+		buffer, err := h.usbReadMem32NoAddrInc(addr, uint16(words*4))
+
+		if err != nil {
+			return values, err
+		}
+
+		for i := uint32(0); i < words; i++ {
+			values = append(values, convertToUint32(buffer[i*4:], littleEndian))
+		}
+
+		count -= words
+	}
 
-  // Read 8 bits until we get a 32bit aligned addr
-  prelen := uint16(addr % 4)
-  if (prelen > 0) {
-    prelen = 4 - prelen
-    if err := h.UsbReadMem8(addr, prelen, buffer); err != nil {
-      return err
-    }
-  }
-  
-  // Read as many 32bit as needed
-  w32len := uint16((len - prelen) / 4)*4
-  if (w32len > 0) {
-    if err := h.UsbReadMem32((addr+uint32(prelen)), w32len, buffer); err !=nil {
-        return err
-    } 
-  }
-  
-  // Read remaining bytes by 8bit's Read
-  postlen := len - w32len - prelen
-  if (postlen > 0) {
-    if err := h.UsbReadMem8(addr+uint32(prelen+w32len), postlen, buffer); err != nil {
-      return err
-    }
-  }
-  return nil
+	return values, nil
+}
+
+func (h *StLink) usbReadMem32NoAddrInc(addr uint32, len uint16) ([]byte, error) {
+	ctx := h.initTransfer(transferIncoming)
+
+	ctx.cmdBuf.WriteByte(cmdDebug)
+	ctx.cmdBuf.WriteByte(debugApiV2ReadMem32BitNoAddrInc)
+
+	ctx.cmdBuf.WriteUint32LE(addr)
+	ctx.cmdBuf.WriteUint16LE(len)
+	h.writeApSelection(ctx)
+
+	err := h.usbTransferNoErrCheck(ctx, uint32(len))
+
+	if err != nil {
+		return nil, newUsbError("ReadMem32NoAddrInc transfer error occurred", usbErrorFail)
+	}
+
+	return ctx.DataBytes(), h.usbGetReadWriteStatus()
+}
+
+// Write a single 32bit word to Target's memory, e.g. to program a peripheral register
+func (h *StLink) WriteUint32(addr uint32, value uint32) error {
+	h, unlock := h.lock()
+	defer unlock()
+
+	if (addr % 4) > 0 {
+		return newUsbError("WriteUint32 Invalid data alignment", usbErrorTargetUnalignedAccess)
+	}
+
+	return h.writeDebugReg(addr, value)
 }
 
 func (h *StLink) UsbWriteMem8(addr uint32, len uint16, buffer []byte) error {
+	h, unlock := h.lock()
+	defer unlock()
+
 	writeLen := uint32(len)
 
 	if writeLen > h.usbBlock() {
 		return newUsbError(fmt.Sprintf("max buffer (%d) length exceeded", h.usbBlock()), usbErrorFail)
 	}
 
+	h.invalidateReadCache()
+
 	ctx := h.initTransfer(transferOutgoing)
 
 	ctx.cmdBuf.WriteByte(cmdDebug)
@@ -144,6 +259,7 @@ func (h *StLink) UsbWriteMem8(addr uint32, len uint16, buffer []byte) error {
 
 	ctx.cmdBuf.WriteUint32LE(addr)
 	ctx.cmdBuf.WriteUint16LE(len)
+	h.writeApSelection(ctx)
 
 	ctx.dataBuf.Write(buffer[:len])
 
@@ -153,10 +269,13 @@ func (h *StLink) UsbWriteMem8(addr uint32, len uint16, buffer []byte) error {
 		return err
 	}
 
-	return h.usbGetReadWriteStatus()
+	return h.usbWriteStatusCheck()
 }
 
 func (h *StLink) UsbWriteMem16(addr uint32, len uint16, buffer []byte) error {
+	h, unlock := h.lock()
+	defer unlock()
+
 	writeLen := uint32(len)
 
 	if !h.version.flags.Get(flagHasMem16Bit) {
@@ -168,6 +287,12 @@ func (h *StLink) UsbWriteMem16(addr uint32, len uint16, buffer []byte) error {
 		return newUsbError("ReadMem16 Invalid data alignment", usbErrorTargetUnalignedAccess)
 	}
 
+	if writeLen > h.maxDataStage() {
+		return newUsbError(fmt.Sprintf("max buffer (%d) length exceeded", h.maxDataStage()), usbErrorFail)
+	}
+
+	h.invalidateReadCache()
+
 	ctx := h.initTransfer(transferOutgoing)
 
 	ctx.cmdBuf.WriteByte(cmdDebug)
@@ -175,6 +300,7 @@ func (h *StLink) UsbWriteMem16(addr uint32, len uint16, buffer []byte) error {
 
 	ctx.cmdBuf.WriteUint32LE(addr)
 	ctx.cmdBuf.WriteUint16LE(len)
+	h.writeApSelection(ctx)
 
 	ctx.dataBuf.Write(buffer[:len])
 
@@ -184,10 +310,13 @@ func (h *StLink) UsbWriteMem16(addr uint32, len uint16, buffer []byte) error {
 		return err
 	}
 
-	return h.usbGetReadWriteStatus()
+	return h.usbWriteStatusCheck()
 }
 
 func (h *StLink) UsbWriteMem32(addr uint32, len uint16, buffer []byte) error {
+	h, unlock := h.lock()
+	defer unlock()
+
 	writeLen := uint32(len)
 
 	/* data must be a multiple of 4 and word aligned */
@@ -195,6 +324,12 @@ func (h *StLink) UsbWriteMem32(addr uint32, len uint16, buffer []byte) error {
 		return newUsbError("ReadMem32 Invalid data alignment", usbErrorTargetUnalignedAccess)
 	}
 
+	if writeLen > h.maxDataStage() {
+		return newUsbError(fmt.Sprintf("max buffer (%d) length exceeded", h.maxDataStage()), usbErrorFail)
+	}
+
+	h.invalidateReadCache()
+
 	ctx := h.initTransfer(transferOutgoing)
 
 	ctx.cmdBuf.WriteByte(cmdDebug)
@@ -202,6 +337,7 @@ func (h *StLink) UsbWriteMem32(addr uint32, len uint16, buffer []byte) error {
 
 	ctx.cmdBuf.WriteUint32LE(addr)
 	ctx.cmdBuf.WriteUint16LE(len)
+	h.writeApSelection(ctx)
 
 	ctx.dataBuf.Write(buffer[:len])
 
@@ -211,5 +347,206 @@ func (h *StLink) UsbWriteMem32(addr uint32, len uint16, buffer []byte) error {
 		return err
 	}
 
-	return h.usbGetReadWriteStatus()
+	return h.usbWriteStatusCheck()
+}
+
+// Read a null terminated string of at most max bytes from Target's memory.
+// Memory is read in small aligned chunks so reading stops close to the terminator.
+func (h *StLink) ReadCString(addr uint32, max int) (string, error) {
+	h, unlock := h.lock()
+	defer unlock()
+
+	var str []byte
+
+	if max <= 0 {
+		return "", errors.New("invalid maximum string length")
+	}
+
+	for len(str) < max {
+		chunkLen := cStringChunkSize - (addr % cStringChunkSize)
+
+		if remaining := uint32(max - len(str)); chunkLen > remaining {
+			chunkLen = remaining
+		}
+
+		buffer := bytes.NewBuffer([]byte{})
+
+		if err := h.ReadMem(addr, Memory8BitBlock, chunkLen, buffer); err != nil {
+			return string(str), err
+		}
+
+		chunk := buffer.Bytes()[:chunkLen]
+
+		if end := bytes.IndexByte(chunk, 0); end >= 0 {
+			return string(append(str, chunk[:end]...)), nil
+		}
+
+		str = append(str, chunk...)
+		addr += chunkLen
+	}
+
+	return string(str), nil
+}
+
+// Read count 32bit words twice and compare both reads. A word whose reads
+// disagree is read again until two consecutive reads match. Fails when more
+// than readVerifyMaxMismatches disagreements occurred in total.
+func (h *StLink) ReadVerified(addr uint32, count int) ([]uint32, error) {
+	h, unlock := h.lock()
+	defer unlock()
+
+	first, err := h.ReadUint32Slice(addr, count)
+
+	if err != nil {
+		return nil, err
+	}
+
+	second, err := h.ReadUint32Slice(addr, count)
+
+	if err != nil {
+		return nil, err
+	}
+
+	mismatches := 0
+
+	for i := range first {
+		wordAddr := addr + uint32(i)*4
+
+		for first[i] != second[i] {
+			mismatches++
+
+			h.log().Debugf("read mismatch at 0x%08x: %08x != %08x", wordAddr, first[i], second[i])
+
+			if mismatches > readVerifyMaxMismatches {
+				return nil, fmt.Errorf("read verification failed at 0x%08x after %d mismatches", wordAddr, mismatches)
+			}
+
+			word, err := h.ReadUint32Slice(wordAddr, 1)
+
+			if err != nil {
+				return nil, err
+			}
+
+			first[i], second[i] = second[i], word[0]
+		}
+	}
+
+	return first, nil
+}
+
+// Compare target memory at addr with expected, e.g. to verify a flash write.
+// Memory is read and compared chunk by chunk, stopping at the first mismatch
+// which is returned as *VerifyError.
+func (h *StLink) VerifyMem(addr uint32, expected []byte) error {
+	h, unlock := h.lock()
+	defer unlock()
+
+	for len(expected) > 0 {
+		// align the chunks so all but the last one are read with 32bit accesses
+		chunkLen := verifyChunkSize - (addr % verifyChunkSize)
+
+		if remaining := uint32(len(expected)); chunkLen > remaining {
+			chunkLen = remaining
+		}
+
+		data, err := h.readRegion(addr, chunkLen)
+
+		if err != nil {
+			return err
+		}
+
+		for i, b := range data {
+			if b != expected[i] {
+				return &VerifyError{Addr: addr + uint32(i), Expected: expected[i], Actual: b}
+			}
+		}
+
+		addr += chunkLen
+		expected = expected[chunkLen:]
+	}
+
+	return nil
+}
+
+// Write data into target ram without halting the core, e.g. to tune a variable
+// at runtime. Memory is accessed through the memory access port which works
+// while the core is running. Each aligned word (or halfword) is written with a
+// single bus access, so the core never sees a torn value of a naturally aligned
+// variable, but there is no atomicity across several variables. On cores with
+// a data cache (Cortex-M7) the core may keep using a stale cached value.
+func (h *StLink) WriteLive(addr uint32, data []byte) error {
+	h, unlock := h.lock()
+	defer unlock()
+
+	if addr >= stm32FlashStart && addr < stm32FlashEnd {
+		return errors.New("live writes are only supported for ram")
+	}
+
+	size := uint32(len(data))
+
+	switch {
+	case (addr%4) == 0 && (size%4) == 0:
+		return h.WriteMem(addr, Memory32BitBlock, size/4, data)
+	case (addr%2) == 0 && (size%2) == 0:
+		return h.WriteMem(addr, Memory16BitBlock, size/2, data)
+	default:
+		return h.WriteMem(addr, Memory8BitBlock, size, data)
+	}
+}
+
+// Read length bytes at addr with the core halted, so a data structure modified
+// by the running firmware is read consistently. A running core is halted just
+// for the read and resumed afterwards, other commands on the handle are held
+// back meanwhile to keep the halt short. A halted core stays halted.
+func (h *StLink) SnapshotMem(addr uint32, length uint32) ([]byte, error) {
+	h, unlock := h.lock()
+	defer unlock()
+
+	if h.version.jtagApi == jTagApiV1 {
+		return nil, fmt.Errorf("snapshot not supported by jtag api v1: %w", ErrNotSupported)
+	}
+
+	if err := h.UsbModeEnter(h.stMode); err != nil {
+		return nil, err
+	}
+	defer h.UsbLeaveMode(h.stMode)
+
+	halted, err := h.usbCoreHalted()
+
+	if err != nil {
+		return nil, err
+	}
+
+	if halted {
+		return h.readRegion(addr, length)
+	}
+
+	if err = h.usbHalt(); err != nil {
+		return nil, err
+	}
+
+	var data []byte
+
+	if err = h.waitHalted(snapshotHaltTimeout); err == nil {
+		data, err = h.readRegion(addr, length)
+	}
+
+	// resume even if the halt timed out or the read failed. The halt was
+	// requested by the debugger, do not report it as a halt event.
+	dfsrErr := h.writeDebugReg(scbDfsr, dfsrHalted)
+	runErr := h.usbRun()
+
+	if err != nil {
+		return nil, err
+	}
+
+	if dfsrErr != nil {
+		return nil, dfsrErr
+	}
+
+	if runErr != nil {
+		return nil, runErr
+	}
+
+	return data, nil
 }
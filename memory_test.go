@@ -0,0 +1,128 @@
+// Copyright 2021 juju2013@github. All rights reserved.
+// Use of this source code is governed by a GNU-style
+// license that can be found in the LICENSE file.
+
+package gostlink
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+
+	"github.com/boljen/go-bitmap"
+)
+
+// memory access as issued to the st-link
+type recordedAccess struct {
+	addr   uint32
+	width  MemoryBlockSize
+	length uint32
+}
+
+// transport recording the accesses, target memory reads as the low byte of its address
+type recordingTransport struct {
+	accesses []recordedAccess
+	busy     int // accesses answered busy before succeeding
+}
+
+func (r *recordingTransport) read(chunk memChunk, buffer *bytes.Buffer) error {
+	r.accesses = append(r.accesses, recordedAccess{chunk.addr, chunk.width, chunk.length})
+
+	for i := uint32(0); i < chunk.length; i++ {
+		buffer.WriteByte(byte(chunk.addr + i))
+	}
+
+	if r.busy > 0 {
+		r.busy--
+		return newUsbError("wait", usbErrorWait)
+	}
+
+	return nil
+}
+
+func newMemoryTestHandle() *StLink {
+	h := &StLink{stLinkState: &stLinkState{}}
+	h.version.flags = bitmap.New(32)
+	h.maxMemPacket = 0x400
+	h.config.retryPolicy = RetryPolicy{MemoryRetries: 2, MaxDelay: 1}
+
+	return h
+}
+
+func TestUsbReadMemAccesses(t *testing.T) {
+	tests := []struct {
+		name     string
+		addr     uint32
+		length   uint32
+		expected []recordedAccess
+	}{
+		{"empty", 0x20000000, 0, nil},
+		{"aligned", 0x20000000, 8, []recordedAccess{
+			{0x20000000, Memory32BitBlock, 8},
+		}},
+		{"unaligned", 0x20000001, 10, []recordedAccess{
+			{0x20000001, Memory8BitBlock, 3},
+			{0x20000004, Memory8BitBlock, 7},
+		}},
+		{"block boundary", 0x200003fc, 12, []recordedAccess{
+			{0x200003fc, Memory32BitBlock, 4},
+			{0x20000400, Memory32BitBlock, 8},
+		}},
+		{"unaligned head", 0x20000002, 0x82, []recordedAccess{
+			{0x20000002, Memory8BitBlock, 2},
+			{0x20000004, Memory32BitBlock, 0x80},
+		}},
+		{"8bit limit", 0x20000001, 0x46, []recordedAccess{
+			{0x20000001, Memory8BitBlock, 3},
+			{0x20000004, Memory8BitBlock, 0x40},
+			{0x20000044, Memory8BitBlock, 3},
+		}},
+	}
+
+	for _, test := range tests {
+		h := newMemoryTestHandle()
+		transport := &recordingTransport{}
+		buffer := bytes.NewBuffer([]byte{})
+
+		if err := h.usbReadMem(test.addr, test.length, buffer, transport.read); err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+
+		if !reflect.DeepEqual(transport.accesses, test.expected) {
+			t.Errorf("%s: accesses %+v, expected %+v", test.name, transport.accesses, test.expected)
+		}
+
+		checkReadPattern(t, test.name, test.addr, test.length, buffer.Bytes())
+	}
+}
+
+func TestUsbReadMemBusyRetry(t *testing.T) {
+	h := newMemoryTestHandle()
+	transport := &recordingTransport{busy: 1}
+	buffer := bytes.NewBuffer([]byte{})
+
+	if err := h.usbReadMem(0x20000000, 8, buffer, transport.read); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(transport.accesses) != 2 {
+		t.Errorf("%d accesses, expected the busy one to be repeated once", len(transport.accesses))
+	}
+
+	// data of the busy access is dropped
+	checkReadPattern(t, "busy retry", 0x20000000, 8, buffer.Bytes())
+}
+
+func checkReadPattern(t *testing.T, name string, addr uint32, length uint32, data []byte) {
+	t.Helper()
+
+	if uint32(len(data)) != length {
+		t.Fatalf("%s: read %d bytes, expected %d", name, len(data), length)
+	}
+
+	for i, b := range data {
+		if b != byte(addr+uint32(i)) {
+			t.Fatalf("%s: byte %d is 0x%02x, expected 0x%02x", name, i, b, byte(addr+uint32(i)))
+		}
+	}
+}
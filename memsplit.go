@@ -0,0 +1,84 @@
+// Copyright 2021 juju2013@github. All rights reserved.
+// Use of this source code is governed by a GNU-style
+// license that can be found in the LICENSE file.
+
+package gostlink
+
+// One usb memory transfer of a ReadMem or WriteMem call
+type memChunk struct {
+	addr   uint32
+	offset uint32          // position of the chunk in the data of the whole access
+	length uint32          // bytes
+	width  MemoryBlockSize // access width of the transfer
+}
+
+// Split an access of length bytes at addr into transfers the st-link can do.
+// 16 and 32bit transfers never cross a TAR auto increment block of maxPacket
+// bytes (the stlink is a hla adapter, so this has to be handled here). Unaligned
+// head bytes and a block remainder shorter than a whole access are transferred
+// with 8bit accesses, which are limited to block8 bytes per transfer.
+// This has no side effects so the decomposition can be checked without a probe.
+func splitMemAccess(addr uint32, length uint32, width MemoryBlockSize, maxPacket uint32, block8 uint32) []memChunk {
+	var chunks []memChunk
+	var offset uint32
+
+	appendChunk := func(size uint32, width MemoryBlockSize) {
+		chunks = append(chunks, memChunk{addr: addr, offset: offset, length: size, width: width})
+		addr += size
+		offset += size
+	}
+
+	append8 := func(size uint32) {
+		for size > 0 {
+			n := size
+			if n > block8 {
+				n = block8
+			}
+
+			appendChunk(n, Memory8BitBlock)
+			size -= n
+		}
+	}
+
+	if width == Memory8BitBlock {
+		append8(length)
+		return chunks
+	}
+
+	mask := uint32(width) - 1
+
+	for length > 0 {
+		size := maxBlockSize(maxPacket, addr)
+
+		if size > length {
+			size = length
+		}
+
+		// unaligned head bytes up to the next access boundary
+		if (addr & mask) > 0 {
+			head := uint32(width) - (addr & mask)
+
+			if head > size {
+				head = size
+			}
+
+			append8(head)
+			length -= head
+			size -= head
+		}
+
+		if size == 0 {
+			continue
+		}
+
+		if (size & mask) > 0 {
+			append8(size)
+		} else {
+			appendChunk(size, width)
+		}
+
+		length -= size
+	}
+
+	return chunks
+}
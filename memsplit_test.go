@@ -0,0 +1,134 @@
+// Copyright 2021 juju2013@github. All rights reserved.
+// Use of this source code is governed by a GNU-style
+// license that can be found in the LICENSE file.
+
+package gostlink
+
+import (
+	"reflect"
+	"testing"
+)
+
+// check the invariants every decomposition of splitMemAccess has to keep
+func checkMemChunks(t *testing.T, addr uint32, length uint32, width MemoryBlockSize, maxPacket uint32, block8 uint32, chunks []memChunk) {
+	t.Helper()
+
+	next := addr
+	var offset uint32
+
+	for i, c := range chunks {
+		if c.addr != next || c.offset != offset {
+			t.Fatalf("chunk %d at 0x%08x offset %d, expected 0x%08x offset %d", i, c.addr, c.offset, next, offset)
+		}
+
+		if c.length == 0 {
+			t.Fatalf("chunk %d is empty", i)
+		}
+
+		switch c.width {
+		case Memory8BitBlock:
+			if c.length > block8 {
+				t.Fatalf("8bit chunk %d of %d bytes exceeds %d", i, c.length, block8)
+			}
+		case width:
+			if c.addr%uint32(width) != 0 || c.length%uint32(width) != 0 {
+				t.Fatalf("%dbit chunk %d at 0x%08x of %d bytes is unaligned", width*8, i, c.addr, c.length)
+			}
+
+			if c.addr/maxPacket != (c.addr+c.length-1)/maxPacket {
+				t.Fatalf("chunk %d at 0x%08x of %d bytes crosses a %d byte block", i, c.addr, c.length, maxPacket)
+			}
+		default:
+			t.Fatalf("chunk %d has width %d for a %d access", i, c.width, width)
+		}
+
+		next += c.length
+		offset += c.length
+	}
+
+	if offset != length {
+		t.Fatalf("chunks cover %d bytes, expected %d", offset, length)
+	}
+}
+
+func TestSplitMemAccess(t *testing.T) {
+	widths := []MemoryBlockSize{Memory8BitBlock, Memory16BitBlock, Memory32BitBlock}
+	starts := []uint32{0x20000000, 0x20000001, 0x20000002, 0x20000003, 0x200003fe, 0x20000ffd}
+	lengths := []uint32{0, 1, 2, 3, 4, 5, 7, 64, 65, 1023, 1024, 1025, 4096, 6000}
+	packets := []uint32{0x400, 0x1000}
+
+	for _, width := range widths {
+		for _, addr := range starts {
+			for _, length := range lengths {
+				for _, maxPacket := range packets {
+					chunks := splitMemAccess(addr, length, width, maxPacket, 64)
+					checkMemChunks(t, addr, length, width, maxPacket, 64, chunks)
+				}
+			}
+		}
+	}
+}
+
+func TestSplitMemAccessChunks(t *testing.T) {
+	tests := []struct {
+		name      string
+		addr      uint32
+		length    uint32
+		width     MemoryBlockSize
+		maxPacket uint32
+		expected  []memChunk
+	}{
+		{"aligned", 0x20000000, 8, Memory32BitBlock, 0x400, []memChunk{
+			{0x20000000, 0, 8, Memory32BitBlock},
+		}},
+		{"unaligned head and tail", 0x20000001, 8, Memory32BitBlock, 0x400, []memChunk{
+			{0x20000001, 0, 3, Memory8BitBlock},
+			{0x20000004, 3, 5, Memory8BitBlock},
+		}},
+		{"block boundary", 0x200003f8, 16, Memory32BitBlock, 0x400, []memChunk{
+			{0x200003f8, 0, 8, Memory32BitBlock},
+			{0x20000400, 8, 8, Memory32BitBlock},
+		}},
+		{"16bit odd length", 0x20000000, 5, Memory16BitBlock, 0x400, []memChunk{
+			{0x20000000, 0, 5, Memory8BitBlock},
+		}},
+		{"8bit split", 0x20000000, 130, Memory8BitBlock, 0x400, []memChunk{
+			{0x20000000, 0, 64, Memory8BitBlock},
+			{0x20000040, 64, 64, Memory8BitBlock},
+			{0x20000080, 128, 2, Memory8BitBlock},
+		}},
+	}
+
+	for _, test := range tests {
+		chunks := splitMemAccess(test.addr, test.length, test.width, test.maxPacket, 64)
+
+		if !reflect.DeepEqual(chunks, test.expected) {
+			t.Errorf("%s: got %+v, expected %+v", test.name, chunks, test.expected)
+		}
+	}
+}
+
+// accesses around 64kB have to be split into chunks whose length survives the
+// uint16 length of the usb memory commands
+func TestSplitMemAccess64k(t *testing.T) {
+	widths := []MemoryBlockSize{Memory8BitBlock, Memory16BitBlock, Memory32BitBlock}
+	starts := []uint32{0x20000000, 0x20000001, 0x20000002}
+	packets := []uint32{0x400, 0x1000}
+
+	for _, width := range widths {
+		for _, addr := range starts {
+			for _, length := range []uint32{65535, 65536, 65537} {
+				for _, maxPacket := range packets {
+					chunks := splitMemAccess(addr, length, width, maxPacket, 512)
+					checkMemChunks(t, addr, length, width, maxPacket, 512, chunks)
+
+					for i, c := range chunks {
+						if uint32(uint16(c.length)) != c.length {
+							t.Fatalf("chunk %d of %d bytes is truncated by the usb length field", i, c.length)
+						}
+					}
+				}
+			}
+		}
+	}
+}
@@ -6,10 +6,24 @@ package gostlink
 
 import (
 	"errors"
+	"fmt"
 )
 
-/** */
+const currentModeRetries = 2
+
+// Enter the given usb mode, nothing is done for debug modes inside WithDebugMode
 func (h *StLink) UsbModeEnter(stMode StLinkMode) error {
+	h, unlock := h.lock()
+	defer unlock()
+
+	if h.modeHeld > 0 && isDebugMode(stMode) {
+		return nil
+	}
+
+	return h.usbModeEnter(stMode)
+}
+
+func (h *StLink) usbModeEnter(stMode StLinkMode) error {
 	var rxSize uint32 = 0
 	/* on api V2 we are able the read the latest command
 	 * status
@@ -59,7 +73,33 @@ func (h *StLink) UsbModeEnter(stMode StLinkMode) error {
 	return h.usbCmdAllowRetry(ctx, rxSize)
 }
 
+// Query the current usb mode of the st-link. A failed or short response is
+// retried after draining stale data from the rx endpoint as freshly plugged
+// devices sometimes answer the first query with garbage.
 func (h *StLink) UsbCurrentMode() (byte, error) {
+	h, unlock := h.lock()
+	defer unlock()
+
+	var err error
+	var mode byte
+
+	for retry := 0; retry <= h.config.retryPolicy.ModeQueryRetries; retry++ {
+		if retry > 0 {
+			h.log().Debugf("query of current mode failed (%s), retry %d", err, retry)
+			h.usbDrainRx()
+		}
+
+		mode, err = h.usbCurrentMode()
+
+		if err == nil {
+			return mode, nil
+		}
+	}
+
+	return 0, err
+}
+
+func (h *StLink) usbCurrentMode() (byte, error) {
 
 	ctx := h.initTransfer(transferIncoming)
 
@@ -69,58 +109,120 @@ func (h *StLink) UsbCurrentMode() (byte, error) {
 
 	if err != nil {
 		return 0, err
+	} else if ctx.rxSize < 2 {
+		return 0, errors.New("short response to current mode query")
 	} else {
 		return ctx.DataBytes()[0], nil
 	}
 }
 
-func (h *StLink) UsbInitMode(connectUnderReset bool, initialInterfaceSpeed uint32) error {
+// Map a device mode number to the mode used to leave it. The device does not
+// report the debug transport, debug mode is reported as swd.
+func deviceModeToStLinkMode(mode byte) StLinkMode {
+	switch mode {
+	case deviceModeDFU:
+		return StLinkModeDfu
+
+	case deviceModeDebug:
+		return StLinkModeDebugSwd
+
+	case deviceModeSwim:
+		return StLinkModeDebugSwim
+
+	case deviceModeMass:
+		return StLinkModeMass
+
+	default:
+		return StLinkModeUnknown
+	}
+}
+
+// Transport mode the handle was opened with, all debug commands are issued in this mode
+func (h *StLink) Mode() StLinkMode {
+	h, unlock := h.lock()
+	defer unlock()
+
+	return h.stMode
+}
+
+// Query the mode the st-link is actually in. As the device does not report the
+// debug transport, debug mode is returned as the transport of the handle.
+func (h *StLink) DeviceMode() (StLinkMode, error) {
+	h, unlock := h.lock()
+	defer unlock()
 
 	mode, err := h.UsbCurrentMode()
 
 	if err != nil {
-		logger.Error("could not get usb mode")
-		return err
+		return StLinkModeUnknown, err
 	}
 
-	logger.Tracef("device usb mode before switching: %s (0x%02x)", usbModeToString(mode), mode)
+	stLinkMode := deviceModeToStLinkMode(mode)
 
-	var stLinkMode StLinkMode
+	if stLinkMode == StLinkModeDebugSwd && h.stMode == StLinkModeDebugJtag {
+		stLinkMode = StLinkModeDebugJtag
+	}
 
-	switch mode {
-	case deviceModeDFU:
-		stLinkMode = StLinkModeDfu
+	return stLinkMode, nil
+}
 
-	case deviceModeDebug:
-		stLinkMode = StLinkModeDebugSwd
+// Bring the st-link back into the mode of the handle (see Mode), e.g. after an
+// operation failed halfway through a mode transition. A device in another mode
+// leaves it first, a device already in the right mode is left untouched.
+func (h *StLink) SyncMode() error {
+	h, unlock := h.lock()
+	defer unlock()
 
-	case deviceModeSwim:
-		stLinkMode = StLinkModeDebugSwim
+	actual, err := h.DeviceMode()
 
-	case deviceModeMass:
-		stLinkMode = StLinkModeMass
+	if err != nil {
+		return err
+	}
 
-	case deviceModeBootloader:
-		stLinkMode = StLinkModeUnknown
+	if actual == h.stMode {
+		return nil
+	}
 
-	default:
-		stLinkMode = StLinkModeUnknown
+	h.log().Debugf("st-link is in mode %d instead of %d, resyncing", actual, h.stMode)
+
+	if actual != StLinkModeUnknown {
+		if err = h.UsbLeaveMode(actual); err != nil {
+			h.log().Warn("error occured while trying to leave mode: ", err)
+		}
+	}
+
+	return h.UsbModeEnter(h.stMode)
+}
+
+func (h *StLink) UsbInitMode(connectUnderReset bool, initialInterfaceSpeed uint32) error {
+	h, unlock := h.lock()
+	defer unlock()
+
+	mode, err := h.UsbCurrentMode()
+
+	if err != nil {
+		h.log().Error("could not get usb mode")
+		return err
 	}
 
+	h.log().Tracef("device usb mode before switching: %s (0x%02x)", usbModeToString(mode), mode)
+
+	stLinkMode := deviceModeToStLinkMode(mode)
+
 	if stLinkMode != StLinkModeUnknown {
 		if err = h.UsbLeaveMode(stLinkMode); err != nil {
-			logger.Warn("error occured while trying to leave mode: ", err)
+			h.log().Warn("error occured while trying to leave mode: ", err)
 		}
 	}
 
 	mode, err = h.UsbCurrentMode()
 
 	if err != nil {
-		logger.Error("could not get usb mode")
+		h.log().Error("could not get usb mode")
 		return err
 	}
 
-	logger.Tracef("device usb mode after mode exit: %s (0x%02x)", usbModeToString(mode), mode)
+	h.log().Tracef("device usb mode after mode exit: %s (0x%02x)", usbModeToString(mode), mode)
 
 	/* we check the target voltage here as an aid to debugging connection problems.
 	 * the stlink requires the target Vdd to be connected for reliable debugging.
@@ -131,11 +233,11 @@ func (h *StLink) UsbInitMode(connectUnderReset bool, initialInterfaceSpeed uint3
 		voltage, err := h.GetTargetVoltage()
 
 		if err != nil {
-			logger.Error(err)
+			h.log().Error(err)
 			// attempt to continue as it is not a catastrophic failure
 		} else {
-			if voltage < 1.5 {
-				logger.Warn("target voltage may be too low for reliable debugging")
+			if voltage < minTargetVoltage {
+				h.log().Warn("target voltage may be too low for reliable debugging")
 			}
 		}
 	}
@@ -143,17 +245,17 @@ func (h *StLink) UsbInitMode(connectUnderReset bool, initialInterfaceSpeed uint3
 	stLinkMode = h.stMode
 
 	if stLinkMode == StLinkModeUnknown {
-		return errors.New("selected mode (transport) not supported")
+		return fmt.Errorf("selected mode (transport) not supported: %w", ErrNotSupported)
 	}
 
 	if stLinkMode == StLinkModeDebugJtag {
 		if h.version.flags.Get(flagHasJtagSetFreq) {
-			//dumpSpeedMap(jTAGkHzToSpeedMap[:])
+			//dumpSpeedMap(h.log(), jTAGkHzToSpeedMap[:])
 			h.SetSpeed(initialInterfaceSpeed, false)
 		}
 	} else if stLinkMode == StLinkModeDebugSwd {
 		if h.version.flags.Get(flagHasJtagSetFreq) {
-			//dumpSpeedMap(swdKHzToSpeedMap[:])
+			//dumpSpeedMap(h.log(), swdKHzToSpeedMap[:])
 			h.SetSpeed(initialInterfaceSpeed, false)
 		}
 	}
@@ -162,7 +264,7 @@ func (h *StLink) UsbInitMode(connectUnderReset bool, initialInterfaceSpeed uint3
 		var smap = make([]speedMap, v3MaxFreqNb)
 
 		h.usbGetComFreq(stLinkMode == StLinkModeDebugJtag, &smap)
-		dumpSpeedMap(smap)
+		dumpSpeedMap(h.log(), smap)
 		h.SetSpeed(initialInterfaceSpeed, false)
 	}
 
@@ -174,7 +276,7 @@ func (h *StLink) UsbInitMode(connectUnderReset bool, initialInterfaceSpeed uint3
 	//  after power on, SWIM_RST stays unchanged
 
 	if connectUnderReset && stLinkMode != StLinkModeDebugSwim {
-		logger.Trace("Assert RST line 1")
+		h.log().Trace("Assert RST line 1")
 
 		h.usbAssertSrst(0)
 		// do not check the return status here, we will
@@ -182,7 +284,7 @@ func (h *StLink) UsbInitMode(connectUnderReset bool, initialInterfaceSpeed uint3
 		// and try asserting srst again.
 	}
 
-	logger.Tracef("Entering usb mode %d", stLinkMode)
+	h.log().Tracef("Entering usb mode %d", stLinkMode)
 	err = h.UsbModeEnter(stLinkMode)
 
 	if err != nil {
@@ -190,7 +292,7 @@ func (h *StLink) UsbInitMode(connectUnderReset bool, initialInterfaceSpeed uint3
 	}
 
 	if connectUnderReset {
-		logger.Trace("Assert RST line 2")
+		h.log().Trace("Assert RST line 2")
 		err = h.usbAssertSrst(0)
 		if err != nil {
 			return err
@@ -203,12 +305,24 @@ func (h *StLink) UsbInitMode(connectUnderReset bool, initialInterfaceSpeed uint3
 		return err
 	}
 
-	logger.Tracef("device usb mode after mode enter: %s (0x%02x)", usbModeToString(mode), mode)
+	h.log().Tracef("device usb mode after mode enter: %s (0x%02x)", usbModeToString(mode), mode)
 
 	return nil
 }
 
+// Leave the given usb mode, debug modes are kept entered inside WithDebugMode
 func (h *StLink) UsbLeaveMode(mode StLinkMode) error {
+	h, unlock := h.lock()
+	defer unlock()
+
+	if h.modeHeld > 0 && isDebugMode(mode) {
+		return nil
+	}
+
+	return h.usbLeaveMode(mode)
+}
+
+func (h *StLink) usbLeaveMode(mode StLinkMode) error {
 	ctx := h.initTransfer(transferIncoming)
 
 	switch mode {
@@ -232,3 +346,39 @@ func (h *StLink) UsbLeaveMode(mode StLinkMode) error {
 
 	return h.usbTransferNoErrCheck(ctx, 0)
 }
+
+func isDebugMode(mode StLinkMode) bool {
+	return mode == StLinkModeDebugSwd || mode == StLinkModeDebugJtag
+}
+
+// Run fn with the debug mode entered once, instead of entering and leaving it
+// around every operation called by fn. As WithLock, fn holds the command lock
+// and operations have to be called from the goroutine running fn.
+func (h *StLink) WithDebugMode(fn func() error) error {
+	h, unlock := h.lock()
+	defer unlock()
+
+	mode := h.stMode
+
+	if !isDebugMode(mode) {
+		mode = StLinkModeDebugSwd
+	}
+
+	if h.modeHeld == 0 {
+		if err := h.usbModeEnter(mode); err != nil {
+			return err
+		}
+	}
+
+	h.modeHeld++
+
+	defer func() {
+		h.modeHeld--
+
+		if h.modeHeld == 0 {
+			h.usbLeaveMode(mode)
+		}
+	}()
+
+	return h.runLocked(fn)
+}
@@ -0,0 +1,91 @@
+// Copyright 2021 juju2013@github. All rights reserved.
+// Use of this source code is governed by a GNU-style
+// license that can be found in the LICENSE file.
+
+package gostlink
+
+const (
+	demcrMonEn   = 1 << 16
+	demcrMonPend = 1 << 17
+	demcrMonStep = 1 << 18
+	demcrMonReq  = 1 << 19
+)
+
+// Debug monitor configuration (DEMCR) and debug event status (DFSR)
+type DebugMonitorState struct {
+	Enabled  bool // DebugMonitor exception enabled
+	Pending  bool // DebugMonitor exception pending
+	Stepping bool // monitor single step requested
+	Request  bool // monitor request flag, free for use by the monitor software
+
+	Events      uint32 // raw DFSR content
+	Halted      bool   // halt request or step event
+	Breakpoint  bool   // breakpoint event
+	Watchpoint  bool   // DWT match event
+	VectorCatch bool   // vector catch event
+	External    bool   // external debug request
+}
+
+// Enable or disable the DebugMonitor exception for monitor mode debugging.
+// Debug events only enter the monitor while halting debug (C_DEBUGEN) is
+// disabled, otherwise they keep halting the core.
+func (h *StLink) ConfigureDebugMonitor(enable bool) error {
+	h, unlock := h.lock()
+	defer unlock()
+
+	demcr, err := h.readDebugReg(dcbDemcr)
+
+	if err != nil {
+		return err
+	}
+
+	if enable {
+		demcr |= demcrMonEn
+	} else {
+		demcr &^= demcrMonEn | demcrMonPend | demcrMonStep
+	}
+
+	return h.writeDebugReg(dcbDemcr, demcr)
+}
+
+// Read the debug monitor configuration and the debug events recorded in DFSR
+func (h *StLink) ReadDebugMonitorState() (DebugMonitorState, error) {
+	h, unlock := h.lock()
+	defer unlock()
+
+	state := DebugMonitorState{}
+
+	demcr, err := h.readDebugReg(dcbDemcr)
+
+	if err != nil {
+		return state, err
+	}
+
+	dfsr, err := h.readDebugReg(scbDfsr)
+
+	if err != nil {
+		return state, err
+	}
+
+	state.Enabled = (demcr & demcrMonEn) > 0
+	state.Pending = (demcr & demcrMonPend) > 0
+	state.Stepping = (demcr & demcrMonStep) > 0
+	state.Request = (demcr & demcrMonReq) > 0
+
+	state.Events = dfsr & dfsrAll
+	state.Halted = (dfsr & dfsrHalted) > 0
+	state.Breakpoint = (dfsr & dfsrBkpt) > 0
+	state.Watchpoint = (dfsr & dfsrDwtTrap) > 0
+	state.VectorCatch = (dfsr & dfsrVCatch) > 0
+	state.External = (dfsr & dfsrExternal) > 0
+
+	return state, nil
+}
+
+// Clear all debug events recorded in DFSR (the bits are write one to clear)
+func (h *StLink) ClearDebugEvents() error {
+	h, unlock := h.lock()
+	defer unlock()
+
+	return h.writeDebugReg(scbDfsr, dfsrAll)
+}
@@ -0,0 +1,155 @@
+// Copyright 2021 juju2013@github. All rights reserved.
+// Use of this source code is governed by a GNU-style
+// license that can be found in the LICENSE file.
+
+package gostlink
+
+import "fmt"
+
+// ARMv6-M/ARMv7-M memory protection unit registers
+const (
+	mpuType = 0xE000ED90
+	mpuCtrl = 0xE000ED94
+	mpuRnr  = 0xE000ED98
+	mpuRbar = 0xE000ED9C
+	mpuRasr = 0xE000EDA0
+
+	mpuCtrlEnable = 1 << 0
+
+	mpuRasrEnable = 1 << 0
+	mpuRasrXn     = 1 << 28
+	mpuRbarAddr   = 0xffffffe0
+)
+
+// One MPU region as configured in RBAR and RASR
+type MpuRegion struct {
+	Number int
+	RBAR   uint32
+	RASR   uint32
+
+	Enabled bool
+	Base    uint32
+	Size    uint64 // bytes, 2^(SIZE+1)
+	SRD     uint8  // subregion disable bits
+	AP      uint8  // access permissions
+	XN      bool   // execute never
+	TexScb  uint8  // memory attributes, TEX (3 bits), S, C and B
+}
+
+// MPU configuration, Regions is empty if no MPU is implemented
+type MpuState struct {
+	TYPE uint32
+	CTRL uint32
+
+	Enabled bool
+	Regions []MpuRegion
+}
+
+func decodeMpuRegion(number int, rbar uint32, rasr uint32) MpuRegion {
+	return MpuRegion{
+		Number:  number,
+		RBAR:    rbar,
+		RASR:    rasr,
+		Enabled: (rasr & mpuRasrEnable) > 0,
+		Base:    rbar & mpuRbarAddr,
+		Size:    1 << (((rasr >> 1) & 0x1f) + 1),
+		SRD:     uint8(rasr >> 8),
+		AP:      uint8((rasr >> 24) & 0x7),
+		XN:      (rasr & mpuRasrXn) > 0,
+		TexScb:  uint8((rasr >> 16) & 0x3f),
+	}
+}
+
+// Read MPU_TYPE, MPU_CTRL and all regions. Regions are selected through MPU_RNR,
+// which is restored afterwards. The core should be halted, as the firmware may
+// use MPU_RNR concurrently. ARMv8-M cores (RLAR instead of RASR) are not decoded.
+func (h *StLink) ReadMPU() (*MpuState, error) {
+	h, unlock := h.lock()
+	defer unlock()
+
+	typ, err := h.readDebugReg(mpuType)
+
+	if err != nil {
+		return nil, err
+	}
+
+	ctrl, err := h.readDebugReg(mpuCtrl)
+
+	if err != nil {
+		return nil, err
+	}
+
+	state := &MpuState{TYPE: typ, CTRL: ctrl, Enabled: (ctrl & mpuCtrlEnable) > 0}
+
+	regions := int((typ >> 8) & 0xff)
+
+	if regions == 0 {
+		return state, nil
+	}
+
+	rnr, err := h.readDebugReg(mpuRnr)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer h.writeDebugReg(mpuRnr, rnr)
+
+	for i := 0; i < regions; i++ {
+		if err = h.writeDebugReg(mpuRnr, uint32(i)); err != nil {
+			return nil, err
+		}
+
+		rbar, err := h.readDebugReg(mpuRbar)
+
+		if err != nil {
+			return nil, err
+		}
+
+		rasr, err := h.readDebugReg(mpuRasr)
+
+		if err != nil {
+			return nil, err
+		}
+
+		state.Regions = append(state.Regions, decodeMpuRegion(i, rbar, rasr))
+	}
+
+	return state, nil
+}
+
+// Configure MPU region with the raw RBAR and RASR values, MPU_RNR is restored
+// afterwards. The core should be halted.
+func (h *StLink) WriteMPURegion(region int, rbar uint32, rasr uint32) error {
+	h, unlock := h.lock()
+	defer unlock()
+
+	typ, err := h.readDebugReg(mpuType)
+
+	if err != nil {
+		return err
+	}
+
+	if region < 0 || region >= int((typ>>8)&0xff) {
+		return fmt.Errorf("invalid mpu region %d, %d regions implemented", region, (typ>>8)&0xff)
+	}
+
+	rnr, err := h.readDebugReg(mpuRnr)
+
+	if err != nil {
+		return err
+	}
+
+	defer h.writeDebugReg(mpuRnr, rnr)
+
+	if err = h.writeDebugReg(mpuRnr, uint32(region)); err != nil {
+		return err
+	}
+
+	// RBAR.VALID is cleared, so the region number is taken from MPU_RNR
+	if err = h.writeDebugReg(mpuRbar, rbar&mpuRbarAddr); err != nil {
+		return err
+	}
+
+	return h.writeDebugReg(mpuRasr, rasr)
+}
@@ -0,0 +1,121 @@
+// Copyright 2021 juju2013@github. All rights reserved.
+// Use of this source code is governed by a GNU-style
+// license that can be found in the LICENSE file.
+
+package gostlink
+
+import (
+	"bytes"
+	"fmt"
+)
+
+// Cortex-M interrupt controller registers
+const (
+	scsIctr   = 0xE000E004 // interrupt controller type, not implemented on ARMv6-M
+	nvicIser0 = 0xE000E100
+	nvicIspr0 = 0xE000E200
+	nvicIabr0 = 0xE000E300 // not implemented on ARMv6-M
+
+	ictrIntLinesMask = 0xf
+	armV6mIrqLines   = 32
+)
+
+// Enabled, pending and active state of the external interrupts, one bit per irq
+type NvicState struct {
+	Lines   int      // number of irq lines implemented (multiple of 32)
+	Enabled []uint32 // ISER words
+	Pending []uint32 // ISPR words
+	Active  []uint32 // IABR words, nil on ARMv6-M cores which lack IABR
+}
+
+func nvicBit(words []uint32, irq int) bool {
+	if irq < 0 || irq/32 >= len(words) {
+		return false
+	}
+	return words[irq/32]&(1<<uint(irq%32)) != 0
+}
+
+func (s *NvicState) IrqEnabled(irq int) bool {
+	return nvicBit(s.Enabled, irq)
+}
+
+func (s *NvicState) IrqPending(irq int) bool {
+	return nvicBit(s.Pending, irq)
+}
+
+func (s *NvicState) IrqActive(irq int) bool {
+	return nvicBit(s.Active, irq)
+}
+
+// Read the enable, pending and active state of all implemented external interrupts.
+// The number of irq lines is taken from ICTR, ARMv6-M cores always have 32 lines.
+func (h *StLink) ReadNVICState() (*NvicState, error) {
+	h, unlock := h.lock()
+	defer unlock()
+
+	cpuId, err := h.readDebugReg(cpuIdBaseRegister)
+
+	if err != nil {
+		return nil, err
+	}
+
+	armV6m := false
+
+	switch (cpuId >> 4) & 0xfff {
+	case cpuIdPartNoCortexM0, cpuIdPartNoCortexM0p:
+		armV6m = true
+	}
+
+	state := &NvicState{Lines: armV6mIrqLines}
+
+	if !armV6m {
+		ictr, err := h.readDebugReg(scsIctr)
+
+		if err != nil {
+			return nil, err
+		}
+
+		state.Lines = int(ictr&ictrIntLinesMask+1) * 32
+	}
+
+	words := state.Lines / 32
+
+	if state.Enabled, err = h.readNvicWords(nvicIser0, words); err != nil {
+		return nil, err
+	}
+
+	if state.Pending, err = h.readNvicWords(nvicIspr0, words); err != nil {
+		return nil, err
+	}
+
+	if !armV6m {
+		if state.Active, err = h.readNvicWords(nvicIabr0, words); err != nil {
+			return nil, err
+		}
+	}
+
+	return state, nil
+}
+
+// Read count consecutive system control space words, which are always little endian
+func (h *StLink) readNvicWords(addr uint32, count int) ([]uint32, error) {
+	buffer := bytes.NewBuffer([]byte{})
+
+	if err := h.ReadMem(addr, Memory32BitBlock, uint32(count), buffer); err != nil {
+		return nil, err
+	}
+
+	data := buffer.Bytes()
+
+	if len(data) < count*4 {
+		return nil, fmt.Errorf("short read of %d bytes", len(data))
+	}
+
+	words := make([]uint32, count)
+
+	for i := range words {
+		words[i] = convertToUint32(data[i*4:], littleEndian)
+	}
+
+	return words, nil
+}
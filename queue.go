@@ -0,0 +1,132 @@
+// Copyright 2021 juju2013@github. All rights reserved.
+// Use of this source code is governed by a GNU-style
+// license that can be found in the LICENSE file.
+
+package gostlink
+
+import (
+	"errors"
+	"sync"
+)
+
+var errQueueClosed = errors.New("command queue is closed")
+
+// Result of an asynchronous memory read
+type ReadResult struct {
+	Addr uint32
+	Data []byte
+	Err  error
+}
+
+// Result of an asynchronous register read
+type RegisterResult struct {
+	Register uint8
+	Value    uint32
+	Err      error
+}
+
+// Runs commands on a handle one after another in a worker goroutine, so callers
+// never block on usb latency. Results are delivered on a channel per request,
+// which receives exactly one value.
+type CommandQueue struct {
+	handle   *StLink
+	requests chan func()
+	done     chan struct{}
+
+	mu     sync.Mutex // protects closed and sending on requests
+	closed bool
+}
+
+// Start a command queue for the handle holding up to depth pending requests,
+// submitting to a full queue blocks. Stop it with Close.
+func (h *StLink) StartCommandQueue(depth int) *CommandQueue {
+	q := &CommandQueue{
+		handle:   h.unlocked(),
+		requests: make(chan func(), depth),
+		done:     make(chan struct{}),
+	}
+
+	go q.run()
+
+	return q
+}
+
+func (q *CommandQueue) run() {
+	defer close(q.done)
+
+	for request := range q.requests {
+		request()
+	}
+}
+
+func (q *CommandQueue) submit(request func()) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if q.closed {
+		return errQueueClosed
+	}
+
+	q.requests <- request
+	return nil
+}
+
+// Read length bytes at addr, using the widest access the alignment allows
+func (q *CommandQueue) ReadMemAsync(addr uint32, length uint32) <-chan ReadResult {
+	result := make(chan ReadResult, 1)
+
+	err := q.submit(func() {
+		data, err := q.handle.readRegion(addr, length)
+		result <- ReadResult{Addr: addr, Data: data, Err: err}
+	})
+
+	if err != nil {
+		result <- ReadResult{Addr: addr, Err: err}
+	}
+
+	return result
+}
+
+// Read one core register, see GetRegister
+func (q *CommandQueue) GetRegisterAsync(register uint8) <-chan RegisterResult {
+	result := make(chan RegisterResult, 1)
+
+	err := q.submit(func() {
+		value, err := q.handle.GetRegister(register)
+		result <- RegisterResult{Register: register, Value: value, Err: err}
+	})
+
+	if err != nil {
+		result <- RegisterResult{Register: register, Err: err}
+	}
+
+	return result
+}
+
+// Run fn on the worker, for any other operation on the handle
+func (q *CommandQueue) Do(fn func(h *StLink) error) <-chan error {
+	result := make(chan error, 1)
+
+	err := q.submit(func() {
+		result <- fn(q.handle)
+	})
+
+	if err != nil {
+		result <- err
+	}
+
+	return result
+}
+
+// Stop accepting requests, run the pending ones and wait for the worker to finish.
+// Requests submitted afterwards fail immediately.
+func (q *CommandQueue) Close() {
+	q.mu.Lock()
+	if !q.closed {
+		q.closed = true
+		close(q.requests)
+	}
+	q.mu.Unlock()
+
+	<-q.done
+}
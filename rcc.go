@@ -0,0 +1,197 @@
+// Copyright 2021 juju2013@github. All rights reserved.
+// Use of this source code is governed by a GNU-style
+// license that can be found in the LICENSE file.
+
+package gostlink
+
+import (
+	"fmt"
+)
+
+const stm32PeripheralSize = 0x400 // address space of one peripheral
+
+// RCC enable bit clocking the peripheral at base
+type stm32ClockBit struct {
+	name string
+	base uint32
+	reg  uint32
+	bit  uint
+}
+
+var stm32ClocksF4 = []stm32ClockBit{
+	{"GPIOA", 0x40020000, 0x40023830, 0},
+	{"GPIOB", 0x40020400, 0x40023830, 1},
+	{"GPIOC", 0x40020800, 0x40023830, 2},
+	{"GPIOD", 0x40020C00, 0x40023830, 3},
+	{"GPIOE", 0x40021000, 0x40023830, 4},
+	{"GPIOF", 0x40021400, 0x40023830, 5},
+	{"GPIOG", 0x40021800, 0x40023830, 6},
+	{"GPIOH", 0x40021C00, 0x40023830, 7},
+	{"GPIOI", 0x40022000, 0x40023830, 8},
+	{"TIM2", 0x40000000, 0x40023840, 0},
+	{"TIM3", 0x40000400, 0x40023840, 1},
+	{"USART2", 0x40004400, 0x40023840, 17},
+	{"USART3", 0x40004800, 0x40023840, 18},
+	{"I2C1", 0x40005400, 0x40023840, 21},
+	{"I2C2", 0x40005800, 0x40023840, 22},
+	{"TIM1", 0x40010000, 0x40023844, 0},
+	{"USART1", 0x40011000, 0x40023844, 4},
+	{"ADC1", 0x40012000, 0x40023844, 8},
+	{"SPI1", 0x40013000, 0x40023844, 12},
+}
+
+var stm32ClockBits = map[stm32Family][]stm32ClockBit{
+	stm32FamilyF0: {
+		{"GPIOA", 0x48000000, 0x40021014, 17},
+		{"GPIOB", 0x48000400, 0x40021014, 18},
+		{"GPIOC", 0x48000800, 0x40021014, 19},
+		{"GPIOD", 0x48000C00, 0x40021014, 20},
+		{"GPIOE", 0x48001000, 0x40021014, 21},
+		{"GPIOF", 0x48001400, 0x40021014, 22},
+		{"TIM2", 0x40000000, 0x4002101C, 0},
+		{"TIM3", 0x40000400, 0x4002101C, 1},
+		{"USART2", 0x40004400, 0x4002101C, 17},
+		{"I2C1", 0x40005400, 0x4002101C, 21},
+		{"ADC1", 0x40012400, 0x40021018, 9},
+		{"TIM1", 0x40012C00, 0x40021018, 11},
+		{"SPI1", 0x40013000, 0x40021018, 12},
+		{"USART1", 0x40013800, 0x40021018, 14},
+	},
+	stm32FamilyF1: {
+		{"AFIO", 0x40010000, 0x40021018, 0},
+		{"GPIOA", 0x40010800, 0x40021018, 2},
+		{"GPIOB", 0x40010C00, 0x40021018, 3},
+		{"GPIOC", 0x40011000, 0x40021018, 4},
+		{"GPIOD", 0x40011400, 0x40021018, 5},
+		{"GPIOE", 0x40011800, 0x40021018, 6},
+		{"ADC1", 0x40012400, 0x40021018, 9},
+		{"TIM1", 0x40012C00, 0x40021018, 11},
+		{"SPI1", 0x40013000, 0x40021018, 12},
+		{"USART1", 0x40013800, 0x40021018, 14},
+		{"TIM2", 0x40000000, 0x4002101C, 0},
+		{"TIM3", 0x40000400, 0x4002101C, 1},
+		{"TIM4", 0x40000800, 0x4002101C, 2},
+		{"USART2", 0x40004400, 0x4002101C, 17},
+		{"USART3", 0x40004800, 0x4002101C, 18},
+		{"I2C1", 0x40005400, 0x4002101C, 21},
+		{"I2C2", 0x40005800, 0x4002101C, 22},
+	},
+	stm32FamilyF2: stm32ClocksF4,
+	stm32FamilyF3: {
+		{"GPIOA", 0x48000000, 0x40021014, 17},
+		{"GPIOB", 0x48000400, 0x40021014, 18},
+		{"GPIOC", 0x48000800, 0x40021014, 19},
+		{"GPIOD", 0x48000C00, 0x40021014, 20},
+		{"GPIOE", 0x48001000, 0x40021014, 21},
+		{"GPIOF", 0x48001400, 0x40021014, 22},
+		{"TIM2", 0x40000000, 0x4002101C, 0},
+		{"TIM3", 0x40000400, 0x4002101C, 1},
+		{"USART2", 0x40004400, 0x4002101C, 17},
+		{"I2C1", 0x40005400, 0x4002101C, 21},
+		{"TIM1", 0x40012C00, 0x40021018, 11},
+		{"SPI1", 0x40013000, 0x40021018, 12},
+		{"USART1", 0x40013800, 0x40021018, 14},
+	},
+	stm32FamilyF4: stm32ClocksF4,
+	stm32FamilyF7: stm32ClocksF4,
+	stm32FamilyG0: {
+		{"GPIOA", 0x50000000, 0x40021034, 0},
+		{"GPIOB", 0x50000400, 0x40021034, 1},
+		{"GPIOC", 0x50000800, 0x40021034, 2},
+		{"GPIOD", 0x50000C00, 0x40021034, 3},
+		{"GPIOF", 0x50001400, 0x40021034, 5},
+	},
+	stm32FamilyG4: {
+		{"GPIOA", 0x48000000, 0x4002104C, 0},
+		{"GPIOB", 0x48000400, 0x4002104C, 1},
+		{"GPIOC", 0x48000800, 0x4002104C, 2},
+		{"GPIOD", 0x48000C00, 0x4002104C, 3},
+		{"GPIOE", 0x48001000, 0x4002104C, 4},
+		{"GPIOF", 0x48001400, 0x4002104C, 5},
+		{"GPIOG", 0x48001800, 0x4002104C, 6},
+	},
+	stm32FamilyH7: {
+		{"GPIOA", 0x58020000, 0x580244E0, 0},
+		{"GPIOB", 0x58020400, 0x580244E0, 1},
+		{"GPIOC", 0x58020800, 0x580244E0, 2},
+		{"GPIOD", 0x58020C00, 0x580244E0, 3},
+		{"GPIOE", 0x58021000, 0x580244E0, 4},
+		{"GPIOF", 0x58021400, 0x580244E0, 5},
+		{"GPIOG", 0x58021800, 0x580244E0, 6},
+		{"GPIOH", 0x58021C00, 0x580244E0, 7},
+	},
+	stm32FamilyL0: {
+		{"GPIOA", 0x50000000, 0x4002102C, 0},
+		{"GPIOB", 0x50000400, 0x4002102C, 1},
+		{"GPIOC", 0x50000800, 0x4002102C, 2},
+		{"GPIOD", 0x50000C00, 0x4002102C, 3},
+		{"GPIOH", 0x50001C00, 0x4002102C, 7},
+	},
+	stm32FamilyL1: {
+		{"GPIOA", 0x40020000, 0x4002381C, 0},
+		{"GPIOB", 0x40020400, 0x4002381C, 1},
+		{"GPIOC", 0x40020800, 0x4002381C, 2},
+		{"GPIOD", 0x40020C00, 0x4002381C, 3},
+		{"GPIOE", 0x40021000, 0x4002381C, 4},
+		{"GPIOH", 0x40021400, 0x4002381C, 5},
+	},
+	stm32FamilyL4: {
+		{"GPIOA", 0x48000000, 0x4002104C, 0},
+		{"GPIOB", 0x48000400, 0x4002104C, 1},
+		{"GPIOC", 0x48000800, 0x4002104C, 2},
+		{"GPIOD", 0x48000C00, 0x4002104C, 3},
+		{"GPIOE", 0x48001000, 0x4002104C, 4},
+		{"GPIOF", 0x48001400, 0x4002104C, 5},
+		{"GPIOG", 0x48001800, 0x4002104C, 6},
+		{"GPIOH", 0x48001C00, 0x4002104C, 7},
+	},
+}
+
+// Clock state of a peripheral as found in the RCC
+type PeripheralClock struct {
+	Name      string
+	Base      uint32
+	EnableReg uint32 // RCC register holding the enable bit
+	EnableBit uint
+	Enabled   bool
+}
+
+// Check the RCC enable bit of the peripheral at addr, e.g. when its registers
+// read as zero. A warning is logged if the clock is disabled. The tables cover
+// GPIO ports of the supported families and the common timers, serial, i2c, spi
+// and adc peripherals of the F0, F1, F2, F3, F4 and F7 families, other
+// peripherals fail with ErrNotSupported.
+func (h *StLink) CheckPeripheralClock(addr uint32) (*PeripheralClock, error) {
+	h, unlock := h.lock()
+	defer unlock()
+
+	device, err := h.IdentifyDevice()
+
+	if err != nil {
+		return nil, err
+	}
+
+	for _, c := range stm32ClockBits[device.family] {
+		if addr < c.base || addr >= c.base+stm32PeripheralSize {
+			continue
+		}
+
+		enr, err := h.readDebugReg(c.reg)
+
+		if err != nil {
+			return nil, err
+		}
+
+		clock := &PeripheralClock{Name: c.name, Base: c.base, EnableReg: c.reg, EnableBit: c.bit,
+			Enabled: (enr & (1 << c.bit)) > 0}
+
+		if !clock.Enabled {
+			h.log().Warnf("clock of %s is disabled (bit %d in RCC register 0x%08x), its registers read as zero",
+				c.name, c.bit, c.reg)
+		}
+
+		return clock, nil
+	}
+
+	return nil, fmt.Errorf("no clock enable known for address 0x%08x on %s: %w", addr, device.Family, ErrNotSupported)
+}
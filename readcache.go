@@ -0,0 +1,93 @@
+// Copyright 2021 juju2013@github. All rights reserved.
+// Use of this source code is governed by a GNU-style
+// license that can be found in the LICENSE file.
+
+package gostlink
+
+import (
+	"bytes"
+	"fmt"
+)
+
+const readCacheLineSize = 64 // bytes, cache lines are aligned to their size
+
+// Read length bytes at addr through a cache which is only used while the core
+// is halted, as memory cannot change then. The cache is dropped whenever the
+// core state changes (run, step, reset) and on every memory or debug register
+// write done by this handle. While the core is not known to be halted the
+// memory is read directly. Do not use it for peripheral registers, see RegisterCache.
+func (h *StLink) CachedRead(addr uint32, length uint32) ([]byte, error) {
+	h, unlock := h.lock()
+	defer unlock()
+
+	if h.state != StateHalted {
+		h.invalidateReadCache()
+		return h.readRegion(addr, length)
+	}
+
+	if h.readCache == nil {
+		h.readCache = make(map[uint32][]byte)
+	}
+
+	first := addr &^ (readCacheLineSize - 1)
+	end := uint64(addr) + uint64(length)
+
+	data := make([]byte, 0, length)
+
+	for line := uint64(first); line < end; line += readCacheLineSize {
+		content, err := h.readCacheLine(uint32(line))
+
+		if err != nil {
+			return nil, err
+		}
+
+		from := uint64(0)
+		if line < uint64(addr) {
+			from = uint64(addr) - line
+		}
+
+		to := uint64(readCacheLineSize)
+		if line+to > end {
+			to = end - line
+		}
+
+		data = append(data, content[from:to]...)
+	}
+
+	return data, nil
+}
+
+func (h *StLink) readCacheLine(line uint32) ([]byte, error) {
+	if content, ok := h.readCache[line]; ok {
+		return content, nil
+	}
+
+	buffer := bytes.NewBuffer([]byte{})
+
+	if err := h.ReadMem(line, Memory32BitBlock, readCacheLineSize/4, buffer); err != nil {
+		return nil, err
+	}
+
+	if buffer.Len() < readCacheLineSize {
+		return nil, fmt.Errorf("short read of %d bytes", buffer.Len())
+	}
+
+	content := buffer.Bytes()[:readCacheLineSize]
+	h.readCache[line] = content
+
+	return content, nil
+}
+
+// Drop all data cached by CachedRead
+func (h *StLink) InvalidateReadCache() {
+	h, unlock := h.lock()
+	defer unlock()
+
+	h.invalidateReadCache()
+}
+
+func (h *StLink) invalidateReadCache() {
+	if len(h.readCache) > 0 {
+		h.readCache = nil
+	}
+}
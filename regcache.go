@@ -0,0 +1,117 @@
+// Copyright 2021 juju2013@github. All rights reserved.
+// Use of this source code is governed by a GNU-style
+// license that can be found in the LICENSE file.
+
+package gostlink
+
+import (
+	"fmt"
+	"sort"
+)
+
+// Returned by RegisterCache for reads of volatile registers
+var ErrVolatileRegister = fmt.Errorf("register has read side effects, use ReadVolatile: %w", ErrNotSupported)
+
+// Caching 32bit register reads for peripheral viewers. Registers in ranges marked
+// volatile (e.g. data registers cleared or popped on read, SVD readAction) are
+// never read by Read or Refresh, so refreshing a view does not disturb the
+// peripheral. A RegisterCache is not safe for concurrent use.
+type RegisterCache struct {
+	handle   *StLink
+	values   map[uint32]uint32
+	volatile []MemoryRange
+}
+
+func (h *StLink) NewRegisterCache() *RegisterCache {
+	return &RegisterCache{handle: h.unlocked(), values: make(map[uint32]uint32)}
+}
+
+// Mark size bytes at addr as volatile, cached values inside the range are dropped
+func (c *RegisterCache) SetVolatile(addr uint32, size uint32) {
+	r := MemoryRange{Address: addr, Size: size}
+	c.volatile = append(c.volatile, r)
+
+	for a := range c.values {
+		if rangeContains(r, a) {
+			delete(c.values, a)
+		}
+	}
+}
+
+func (c *RegisterCache) IsVolatile(addr uint32) bool {
+	for _, r := range c.volatile {
+		if rangeContains(r, addr) {
+			return true
+		}
+	}
+	return false
+}
+
+func rangeContains(r MemoryRange, addr uint32) bool {
+	return addr >= r.Address && uint64(addr) < uint64(r.Address)+uint64(r.Size)
+}
+
+// Register value at the word aligned addr, read from the target only if not cached yet
+func (c *RegisterCache) Read(addr uint32) (uint32, error) {
+	if value, ok := c.values[addr]; ok {
+		return value, nil
+	}
+
+	if c.IsVolatile(addr) {
+		return 0, fmt.Errorf("read of 0x%08x: %w", addr, ErrVolatileRegister)
+	}
+
+	value, err := c.readTarget(addr)
+
+	if err != nil {
+		return 0, err
+	}
+
+	c.values[addr] = value
+	return value, nil
+}
+
+// Read a register from the target bypassing the cache, also in volatile ranges.
+// The value is never cached.
+func (c *RegisterCache) ReadVolatile(addr uint32) (uint32, error) {
+	return c.readTarget(addr)
+}
+
+// Read all cached registers again from the target, in address order
+func (c *RegisterCache) Refresh() error {
+	addrs := make([]uint32, 0, len(c.values))
+
+	for addr := range c.values {
+		addrs = append(addrs, addr)
+	}
+
+	sort.Slice(addrs, func(i, j int) bool { return addrs[i] < addrs[j] })
+
+	for _, addr := range addrs {
+		value, err := c.readTarget(addr)
+
+		if err != nil {
+			return err
+		}
+
+		c.values[addr] = value
+	}
+
+	return nil
+}
+
+// Drop all cached values, volatile ranges are kept
+func (c *RegisterCache) Invalidate() {
+	c.values = make(map[uint32]uint32)
+}
+
+func (c *RegisterCache) readTarget(addr uint32) (uint32, error) {
+	if addr%4 != 0 {
+		return 0, fmt.Errorf("register address 0x%08x: %w", addr, ErrUnalignedAccess)
+	}
+
+	h, unlock := c.handle.lock()
+	defer unlock()
+
+	return h.readDebugReg(addr)
+}
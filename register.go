@@ -6,6 +6,7 @@ package gostlink
 
 import (
   "encoding/binary"
+  "fmt"
 )
 
 type TargetRegisters struct {
@@ -18,13 +19,107 @@ type TargetRegisters struct {
   RW2         uint32
 }
 
-// Get all registers content
+// register indexes as used by the read/write register commands
+const (
+  registerSP        = 13
+  registerLR        = 14
+  registerPC        = 15
+  registerXPSR      = 16
+  registerMainSP    = 17
+  registerProcessSP = 18
+  registerMaxIndex  = 20 // last register covered by TargetRegisters
+)
+
+// fail for register indexes not covered by TargetRegisters
+func checkRegisterIndex(register uint8) error {
+  if register > registerMaxIndex {
+    return fmt.Errorf("invalid register index %d, valid range is 0-%d", register, registerMaxIndex)
+  }
+  return nil
+}
+
+// Get all registers content, fails with ErrTargetNotHalted unless the core is halted
 func (h *StLink) GetRegisters() (*TargetRegisters, error) {
-  if err:=h.UsbModeEnter(StLinkModeDebugSwd); err !=nil {
+  h, unlock := h.lock()
+  defer unlock()
+
+  if err:=h.UsbModeEnter(h.stMode); err !=nil {
+    return nil, err
+  }
+  defer h.UsbLeaveMode(h.stMode)
+
+  if err:=h.requireHalted(); err !=nil {
     return nil, err
   }
-  defer h.UsbLeaveMode(StLinkModeDebugSwd)
-  
+
+  return h.readRegisters()
+}
+
+// Get one register content, register is the index as in TargetRegisters (R0-R15, XPSR, MainSP, ProcessSP, ...).
+// Register values are only valid on a halted core, ErrTargetNotHalted is returned otherwise.
+func (h *StLink) GetRegister(register uint8) (uint32, error) {
+  h, unlock := h.lock()
+  defer unlock()
+
+  if err:=checkRegisterIndex(register); err !=nil {
+    return 0, err
+  }
+
+  if err:=h.UsbModeEnter(h.stMode); err !=nil {
+    return 0, err
+  }
+  defer h.UsbLeaveMode(h.stMode)
+
+  if err:=h.requireHalted(); err !=nil {
+    return 0, err
+  }
+
+  return h.readRegister(register)
+}
+
+// Write one register, register is the index as in TargetRegisters (R0-R15, XPSR, MainSP, ProcessSP, ...).
+// Fails with ErrTargetNotHalted unless the core is halted.
+func (h *StLink) WriteRegister(register uint8, value uint32) error {
+  h, unlock := h.lock()
+  defer unlock()
+
+  if err:=checkRegisterIndex(register); err !=nil {
+    return err
+  }
+
+  if err:=h.UsbModeEnter(h.stMode); err !=nil {
+    return err
+  }
+  defer h.UsbLeaveMode(h.stMode)
+
+  if err:=h.requireHalted(); err !=nil {
+    return err
+  }
+
+  if err:=h.writeRegister(register, value); err !=nil {
+    return fmt.Errorf("write of register %d failed: %w", register, err)
+  }
+  return nil
+}
+
+// Write core registers R0-R15, XPSR, MainSP and ProcessSP, the remaining fields are ignored
+func (h *StLink) WriteRegisters(regs *TargetRegisters) error {
+  h, unlock := h.lock()
+  defer unlock()
+
+  if err:=h.UsbModeEnter(h.stMode); err !=nil {
+    return err
+  }
+  defer h.UsbLeaveMode(h.stMode)
+
+  if err:=h.requireHalted(); err !=nil {
+    return err
+  }
+
+  return h.writeRegisters(regs)
+}
+
+func (h *StLink) readRegisters() (*TargetRegisters, error) {
   ctx := h.initTransfer(transferIncoming)
   ctx.cmdBuf.WriteByte(cmdDebug)
   ctx.cmdBuf.WriteByte(debugApiV2ReadAllRegs)
@@ -34,6 +129,9 @@ func (h *StLink) GetRegisters() (*TargetRegisters, error) {
   if err != nil {
     return nil, err
   }
+  if err = h.registerStatusCheck(ctx); err != nil {
+    return nil, fmt.Errorf("read of all registers failed: %w", err)
+  }
 
   regs.Status = ctx.dataBuf.ReadUint32LE()
   for i := range regs.R {
@@ -47,13 +145,7 @@ func (h *StLink) GetRegisters() (*TargetRegisters, error) {
   return &regs, nil
 }
 
-// Get one register content
-func (h *StLink) GetRegister(register uint8) (uint32, error) {
-  if err:=h.UsbModeEnter(StLinkModeDebugSwd); err !=nil {
-    return 0, err
-  }
-  defer h.UsbLeaveMode(StLinkModeDebugSwd)
-  
+func (h *StLink) readRegister(register uint8) (uint32, error) {
   ctx := h.initTransfer(transferIncoming)
   ctx.cmdBuf.WriteByte(cmdDebug)
   ctx.cmdBuf.WriteByte(debugApiV2ReadReg)
@@ -63,6 +155,49 @@ func (h *StLink) GetRegister(register uint8) (uint32, error) {
   if err != nil {
     return 0, err
   }
+  if err = h.registerStatusCheck(ctx); err != nil {
+    return 0, fmt.Errorf("read of register %d failed: %w", register, err)
+  }
   ctx.dataBuf.ReadUint32LE() // Status
   return ctx.dataBuf.ReadUint32LE(), nil
 }
+
+// Check the status word of a register read response. Registers are only
+// accessible on a halted core, so a failed read is reported as such if the core runs.
+func (h *StLink) registerStatusCheck(ctx *transferCtx) error {
+  err := h.usbErrorCheck(ctx)
+  if err == nil {
+    return nil
+  }
+
+  if halted, haltErr := h.usbCoreHalted(); haltErr == nil && !halted {
+    return fmt.Errorf("%v: %w", err, ErrTargetNotHalted)
+  }
+  return err
+}
+
+func (h *StLink) writeRegister(register uint8, value uint32) error {
+  ctx := h.initTransfer(transferIncoming)
+  ctx.cmdBuf.WriteByte(cmdDebug)
+  ctx.cmdBuf.WriteByte(debugApiV2WriteReg)
+  ctx.cmdBuf.WriteByte(register)
+  ctx.cmdBuf.WriteUint32LE(value)
+
+  return h.usbCmdAllowRetry(ctx, 2)
+}
+
+func (h *StLink) writeRegisters(regs *TargetRegisters) error {
+  for i, value := range regs.R {
+    if err := h.writeRegister(uint8(i), value); err != nil {
+      return err
+    }
+  }
+
+  if err := h.writeRegister(registerXPSR, regs.XPSR); err != nil {
+    return err
+  }
+  if err := h.writeRegister(registerMainSP, regs.MainSP); err != nil {
+    return err
+  }
+  return h.writeRegister(registerProcessSP, regs.ProcessSP)
+}
@@ -0,0 +1,40 @@
+// Copyright 2021 juju2013@github. All rights reserved.
+// Use of this source code is governed by a GNU-style
+// license that can be found in the LICENSE file.
+
+package gostlink
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestCheckRegisterIndex(t *testing.T) {
+	for _, register := range []uint8{0, registerSP, registerPC, registerXPSR, registerProcessSP, registerMaxIndex} {
+		if err := checkRegisterIndex(register); err != nil {
+			t.Errorf("register %d rejected: %v", register, err)
+		}
+	}
+
+	for _, register := range []uint8{registerMaxIndex + 1, 0x1f, 0xff} {
+		if err := checkRegisterIndex(register); err == nil {
+			t.Errorf("register %d accepted", register)
+		}
+	}
+}
+
+func TestCheckHalted(t *testing.T) {
+	if err := checkHalted(true, nil); err != nil {
+		t.Errorf("halted core rejected: %v", err)
+	}
+
+	if err := checkHalted(false, nil); !errors.Is(err, ErrTargetNotHalted) {
+		t.Errorf("running core not rejected with ErrTargetNotHalted: %v", err)
+	}
+
+	readErr := errors.New("read of DHCSR failed")
+
+	if err := checkHalted(true, readErr); err != readErr {
+		t.Errorf("expected the read error, got %v", err)
+	}
+}
@@ -0,0 +1,124 @@
+// Copyright 2021 juju2013@github. All rights reserved.
+// Use of this source code is governed by a GNU-style
+// license that can be found in the LICENSE file.
+
+package gostlink
+
+import (
+	"fmt"
+	"time"
+)
+
+const (
+	resetLoopSamples   = 50
+	resetLoopInterval  = 20 * time.Millisecond
+	resetLoopThreshold = 3 // resets seen within the window to consider the target looping
+)
+
+// What to do when the target is found in a reset loop at connect
+type ResetLoopCheck int
+
+const (
+	// Do not check for a reset loop (default)
+	ResetLoopIgnore ResetLoopCheck = iota
+	// Fail connecting with ErrTargetResetLooping
+	ResetLoopReport
+	// Catch the core on the reset vector with DEMCR.VC_CORERESET and keep it
+	// halted there, so the firmware causing the resets does not run anymore
+	ResetLoopCatch
+)
+
+func (c ResetLoopCheck) String() string {
+	switch c {
+	case ResetLoopIgnore:
+		return "ignore"
+	case ResetLoopReport:
+		return "report"
+	case ResetLoopCatch:
+		return "catch"
+	default:
+		return "unknown"
+	}
+}
+
+// Check for a target in a reset loop when connecting, which takes about 1s
+func (config *StLinkInterfaceConfig) SetResetLoopCheck(check ResetLoopCheck) {
+	config.resetLoopCheck = check
+}
+
+// Watch DHCSR.S_RESET_ST for about 1s and return ErrTargetResetLooping if the
+// core reset repeatedly, e.g. because of a watchdog or brown-out in the firmware.
+// Resets with a period above about 330ms are not detected, a failing read of
+// DHCSR is returned as is.
+func (h *StLink) CheckResetLoop() error {
+	h, unlock := h.lock()
+	defer unlock()
+
+	if err := h.UsbModeEnter(h.stMode); err != nil {
+		return err
+	}
+	defer h.UsbLeaveMode(h.stMode)
+
+	return h.checkResetLoop()
+}
+
+func (h *StLink) checkResetLoop() error {
+	// reading DHCSR clears a stale reset status
+	if _, err := h.usbReadDhcsr(); err != nil {
+		return err
+	}
+
+	resets := 0
+
+	for i := 0; i < resetLoopSamples; i++ {
+		time.Sleep(resetLoopInterval)
+
+		dhcsr, err := h.usbReadDhcsr()
+
+		if err != nil {
+			return err
+		}
+
+		// S_RESET_ST is cleared by the read, so a set bit is a reset since the last sample
+		if (dhcsr & dhcsrSResetSt) > 0 {
+			resets++
+		}
+	}
+
+	if resets >= resetLoopThreshold {
+		return fmt.Errorf("core reset %d times within %v: %w", resets, resetLoopSamples*resetLoopInterval, ErrTargetResetLooping)
+	}
+
+	return nil
+}
+
+func (h *StLink) connectResetLoopCheck() error {
+	err := h.checkResetLoop()
+
+	if err == nil || h.config.resetLoopCheck != ResetLoopCatch {
+		return err
+	}
+
+	h.log().Warn(err, ", catching the core on the reset vector")
+
+	demcr, err := h.readDebugReg(dcbDemcr)
+
+	if err != nil {
+		return err
+	}
+
+	// VC_CORERESET stays set, so the core does not run into the loop again when resumed
+	if err = h.writeDebugReg(dcbDemcr, demcr|demcrVcCoreReset); err != nil {
+		return err
+	}
+
+	if err = h.waitHalted(time.Second); err != nil {
+		// vector catch needs halting debug enabled, halting it enables that too
+		if err = h.ensureHalted(); err != nil {
+			return fmt.Errorf("could not catch the core in its reset loop: %w", err)
+		}
+	}
+
+	h.setState(StateHalted)
+	return nil
+}
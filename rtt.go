@@ -12,6 +12,8 @@ import (
 	"sort"
 )
 
+// Called by ReadRttChannels with the channel index and the data read from it.
+// Runs while the command lock is held, so it must not call the handle.
 type RttDataCb func(int, []byte) error
 
 const (
@@ -62,9 +64,11 @@ type seggerRttInfo struct {
 }
 
 func (h *StLink) InitializeRtt(rttSearchRanges [][2]uint64) error {
+	h, unlock := h.lock()
+	defer unlock()
 
 	for _, r := range rttSearchRanges {
-		logger.Infof("searching for SeggerRTT in range  [%08x, %08x]", r[0], r[0]+r[1])
+		h.log().Infof("searching for SeggerRTT in range  [%08x, %08x]", r[0], r[0]+r[1])
 
 		ramStart := uint32(r[0])
 		rangeSize := uint32(r[1])
@@ -82,13 +86,13 @@ func (h *StLink) InitializeRtt(rttSearchRanges [][2]uint64) error {
 			if occ != -1 {
 				h.seggerRtt.offset = uint32(occ)
 
-				logger.Infof("found RTT control block at address: 0x%08x", h.seggerRtt.ramStart+h.seggerRtt.offset)
+				h.log().Infof("found RTT control block at address: 0x%08x", h.seggerRtt.ramStart+h.seggerRtt.offset)
 				parseRttControlBlock(ramBuffer.Bytes()[h.seggerRtt.offset:], &h.seggerRtt.controlBlock)
 
 				if h.seggerRtt.controlBlock.maxNumDownBuffers == 0 || h.seggerRtt.controlBlock.maxNumUpBuffers == 0 {
 					return errors.New("could not find any up or downstream buffers in rtt block")
 				} else {
-					logger.Debugf("got AC-ID: %s, MaxNumUpBuffers: %d, MaxNumDownBuffers: %d",
+					h.log().Debugf("got AC-ID: %s, MaxNumUpBuffers: %d, MaxNumDownBuffers: %d",
 						h.seggerRtt.controlBlock.acId,
 						h.seggerRtt.controlBlock.maxNumUpBuffers,
 						h.seggerRtt.controlBlock.maxNumDownBuffers)
@@ -99,7 +103,7 @@ func (h *StLink) InitializeRtt(rttSearchRanges [][2]uint64) error {
 					return nil
 				}
 			} else {
-				logger.Warn("could not find Segger RTT control block id in this range")
+				h.log().Warn("could not find Segger RTT control block id in this range")
 			}
 		}
 	}
@@ -109,6 +113,9 @@ func (h *StLink) InitializeRtt(rttSearchRanges [][2]uint64) error {
 }
 
 func (h *StLink) UpdateRttChannels(readChannelNames bool) error {
+	h, unlock := h.lock()
+	defer unlock()
+
 	bufferAmount := h.seggerRtt.controlBlock.maxNumUpBuffers + h.seggerRtt.controlBlock.maxNumDownBuffers
 	ramBuffer := bytes.NewBuffer([]byte{})
 	size := bufferAmount * seggerRttBufferSize
@@ -142,12 +149,9 @@ func (h *StLink) UpdateRttChannels(readChannelNames bool) error {
 			controlBlockOffset += 4
 
 			if rttBuffer.name != 0 && readChannelNames == true {
-				channelNameBuf := bytes.NewBuffer([]byte{})
-
-				h.ReadMem(rttBuffer.name, 1, 64, channelNameBuf)
-				channelName, _ := channelNameBuf.ReadString(byte(0))
+				channelName, _ := h.ReadCString(rttBuffer.name, 64)
 
-				logger.Debugf("%d. Channel Name: %s, \tsize: %d, flags: %d, pBuffer 0x%08x, rdOff: %d, wrOff: %d", i,
+				h.log().Debugf("%d. Channel Name: %s, \tsize: %d, flags: %d, pBuffer 0x%08x, rdOff: %d, wrOff: %d", i,
 					channelName, rttBuffer.sizeOfBuffer, rttBuffer.flags, rttBuffer.buffer, rttBuffer.rdOff, rttBuffer.wrOff)
 
 			} else {
@@ -165,6 +169,9 @@ func (h *StLink) UpdateRttChannels(readChannelNames bool) error {
 }
 
 func (h *StLink) ReadRttChannels(callback RttDataCb) error {
+	h, unlock := h.lock()
+	defer unlock()
+
 	if h.seggerRtt.controlBlock.maxNumUpBuffers == 0 {
 		return errors.New("no channels for reading configured on target")
 	}
@@ -0,0 +1,322 @@
+// Copyright 2021 juju2013@github. All rights reserved.
+// Use of this source code is governed by a GNU-style
+// license that can be found in the LICENSE file.
+
+package gostlink
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Cortex-M debug control block
+const (
+	dcbDhcsr = 0xE000EDF0
+	dcbDemcr = 0xE000EDFC
+	scbDfsr  = 0xE000ED30
+
+	dhcsrDbgKey    = 0xA05F << 16
+	dhcsrCDebugEn  = 1 << 0
+	dhcsrCHalt     = 1 << 1
+	dhcsrCStep     = 1 << 2
+	dhcsrCMaskInts = 1 << 3
+	dhcsrSRegRdy   = 1 << 16
+	dhcsrSHalt     = 1 << 17
+	dhcsrSSleep    = 1 << 18
+	dhcsrSLockup   = 1 << 19
+	dhcsrSRetireSt = 1 << 24
+	dhcsrSResetSt  = 1 << 25
+
+	dfsrHalted   = 1 << 0
+	dfsrBkpt     = 1 << 1
+	dfsrDwtTrap  = 1 << 2
+	dfsrVCatch   = 1 << 3
+	dfsrExternal = 1 << 4
+	dfsrAll      = dfsrHalted | dfsrBkpt | dfsrDwtTrap | dfsrVCatch | dfsrExternal
+
+	aircrVectKey     = 0x05FA << 16
+	aircrSysResetReq = 1 << 2
+
+	haltPollInterval   = time.Millisecond
+	systemResetTimeout = time.Second
+)
+
+func (h *StLink) usbReadDhcsr() (uint32, error) {
+	return h.readDebugReg(dcbDhcsr)
+}
+
+func (h *StLink) usbCoreHalted() (bool, error) {
+	if h.version.jtagApi == jTagApiV1 {
+		ctx := h.initTransfer(transferIncoming)
+
+		ctx.cmdBuf.WriteByte(cmdDebug)
+		ctx.cmdBuf.WriteByte(debugGetStatus)
+
+		if err := h.usbTransferNoErrCheck(ctx, 2); err != nil {
+			return false, err
+		}
+
+		return h.updateHaltState(ctx.DataBytes()[0] == debugCoreHalted), nil
+	}
+
+	dhcsr, err := h.usbReadDhcsr()
+
+	if err != nil {
+		return false, err
+	}
+
+	return h.updateHaltState((dhcsr & dhcsrSHalt) > 0), nil
+}
+
+func (h *StLink) updateHaltState(halted bool) bool {
+	if halted {
+		h.setState(StateHalted)
+	} else {
+		h.setState(StateRunning)
+	}
+
+	return halted
+}
+
+func (h *StLink) usbHalt() error {
+	if h.version.jtagApi == jTagApiV1 {
+		return h.ForceDebug()
+	}
+
+	return h.writeDebugReg(dcbDhcsr, dhcsrDbgKey|dhcsrCHalt|dhcsrCDebugEn)
+}
+
+func (h *StLink) usbRun() error {
+	if h.version.jtagApi == jTagApiV1 {
+		return fmt.Errorf("run core not supported by jtag api v1: %w", ErrNotSupported)
+	}
+
+	if err := h.writeDebugReg(dcbDhcsr, dhcsrDbgKey|dhcsrCDebugEn); err != nil {
+		return err
+	}
+
+	h.setState(StateRunning)
+	return nil
+}
+
+func (h *StLink) usbStep() error {
+	if h.version.jtagApi == jTagApiV1 {
+		return fmt.Errorf("step core not supported by jtag api v1: %w", ErrNotSupported)
+	}
+
+	// interrupts are masked, otherwise a pending one would be entered instead.
+	// C_MASKINTS may only change while C_HALT stays set, so it is set in a write
+	// of its own before C_HALT is cleared by the step.
+	if err := h.writeDebugReg(dcbDhcsr, dhcsrDbgKey|dhcsrCHalt|dhcsrCMaskInts|dhcsrCDebugEn); err != nil {
+		return err
+	}
+
+	if err := h.writeDebugReg(dcbDhcsr, dhcsrDbgKey|dhcsrCStep|dhcsrCMaskInts|dhcsrCDebugEn); err != nil {
+		return err
+	}
+
+	h.setState(StateRunning)
+	return nil
+}
+
+// Halt the core and return once it reports halted state
+func (h *StLink) Halt() error {
+	h, unlock := h.lock()
+	defer unlock()
+
+	if err := h.UsbModeEnter(h.stMode); err != nil {
+		return err
+	}
+	defer h.UsbLeaveMode(h.stMode)
+
+	return h.ensureHalted()
+}
+
+// Resume the halted core. The debug state is polled afterwards, the core may
+// already be halted again by a breakpoint at the current instruction.
+func (h *StLink) Resume() error {
+	h, unlock := h.lock()
+	defer unlock()
+
+	if err := h.UsbModeEnter(h.stMode); err != nil {
+		return err
+	}
+	defer h.UsbLeaveMode(h.stMode)
+
+	if err := h.writeDebugReg(scbDfsr, dfsrAll); err != nil {
+		return err
+	}
+
+	if err := h.usbRun(); err != nil {
+		return err
+	}
+
+	_, err := h.usbCoreHalted()
+	return err
+}
+
+// Execute a single instruction on the halted core and return the new PC.
+// Interrupts are masked during the step.
+func (h *StLink) Step() (uint32, error) {
+	h, unlock := h.lock()
+	defer unlock()
+
+	if err := h.UsbModeEnter(h.stMode); err != nil {
+		return 0, err
+	}
+	defer h.UsbLeaveMode(h.stMode)
+
+	if err := h.requireHalted(); err != nil {
+		return 0, err
+	}
+
+	if err := h.usbStep(); err != nil {
+		return 0, err
+	}
+
+	if err := h.waitHalted(time.Second); err != nil {
+		return 0, err
+	}
+
+	// unmask the interrupts again with C_HALT set, the core stays halted
+	if err := h.usbHalt(); err != nil {
+		return 0, err
+	}
+
+	return h.readRegister(registerPC)
+}
+
+// wait until the core reports halted state or timeout elapsed
+func (h *StLink) waitHalted(timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+
+	for {
+		halted, err := h.usbCoreHalted()
+
+		if err != nil {
+			return err
+		}
+
+		if halted {
+			return nil
+		}
+
+		if time.Now().After(deadline) {
+			return fmt.Errorf("timeout while waiting for target to halt: %w", ErrTimeout)
+		}
+
+		time.Sleep(haltPollInterval)
+	}
+}
+
+// halt the core unless it is already halted
+func (h *StLink) ensureHalted() error {
+	halted, err := h.usbCoreHalted()
+
+	if err != nil {
+		return err
+	}
+
+	if halted {
+		return nil
+	}
+
+	if err = h.usbHalt(); err != nil {
+		return err
+	}
+
+	return h.waitHalted(time.Second)
+}
+
+// Reset the target by driving NRST low for width, independent of connecting
+// under reset. The NRST line has to be wired to the st-link, the firmware's own
+// pulse is not used as its width is fixed.
+func (h *StLink) PulseReset(width time.Duration) error {
+	h, unlock := h.lock()
+	defer unlock()
+
+	if width <= 0 {
+		return errors.New("invalid reset pulse width")
+	}
+
+	if err := h.UsbModeEnter(h.stMode); err != nil {
+		return err
+	}
+	defer h.UsbLeaveMode(h.stMode)
+
+	if err := h.usbAssertSrst(0); err != nil {
+		return err
+	}
+
+	h.setState(StateReset)
+	time.Sleep(width)
+
+	if err := h.usbAssertSrst(1); err != nil {
+		return err
+	}
+
+	if err := h.reopenAccessPorts(); err != nil {
+		return err
+	}
+
+	halted, err := h.usbCoreHalted()
+
+	if err != nil {
+		return err
+	}
+
+	h.updateHaltState(halted)
+	return nil
+}
+
+// Reset the target by requesting a system reset through AIRCR.SYSRESETREQ,
+// no reset line has to be connected. Returns when the core reported the
+// reset via DHCSR.S_RESET_ST, the debug connection is kept during the reset.
+func (h *StLink) SystemReset() error {
+	h, unlock := h.lock()
+	defer unlock()
+
+	if err := h.UsbModeEnter(h.stMode); err != nil {
+		return err
+	}
+	defer h.UsbLeaveMode(h.stMode)
+
+	return h.systemReset()
+}
+
+func (h *StLink) systemReset() error {
+	// reading DHCSR clears a stale reset status
+	if _, err := h.usbReadDhcsr(); err != nil {
+		return err
+	}
+
+	if err := h.writeDebugReg(scbAircr, aircrVectKey|aircrSysResetReq); err != nil {
+		return err
+	}
+
+	h.setState(StateReset)
+	h.forgetAccessPorts()
+
+	deadline := time.Now().Add(systemResetTimeout)
+
+	for {
+		// accesses may fail while the reset is in progress
+		dhcsr, err := h.usbReadDhcsr()
+
+		if err == nil && (dhcsr&dhcsrSResetSt) > 0 {
+			h.updateHaltState((dhcsr & dhcsrSHalt) > 0)
+			return h.reopenAccessPorts()
+		}
+
+		if time.Now().After(deadline) {
+			if err != nil {
+				return fmt.Errorf("timeout while waiting for system reset: %w", err)
+			}
+
+			return fmt.Errorf("timeout while waiting for system reset: %w", ErrTimeout)
+		}
+
+		time.Sleep(haltPollInterval)
+	}
+}
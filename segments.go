@@ -0,0 +1,177 @@
+// Copyright 2021 juju2013@github. All rights reserved.
+// Use of this source code is governed by a GNU-style
+// license that can be found in the LICENSE file.
+
+package gostlink
+
+import (
+	"fmt"
+	"strings"
+)
+
+const segmentChunkSize = 4 * kB // bytes written between progress reports
+
+// Data to be written at Address, e.g. a PT_LOAD segment of an elf file
+type MemorySegment struct {
+	Address uint32
+	Data    []byte
+}
+
+// Called with the bytes written so far and the total size of all segments.
+// Runs while the command lock is held, so it must not call the handle.
+type WriteProgressCb func(written uint64, total uint64)
+
+// Options of WriteSegments, the zero value stops at the first error and reports no progress
+type WriteSegmentsOptions struct {
+	ContinueOnError bool // write the remaining segments after a failed one
+	Progress        WriteProgressCb
+}
+
+// Write of a segment failed
+type SegmentError struct {
+	Segment int    // index of the segment
+	Address uint32 // start of the chunk whose write failed
+	Err     error
+}
+
+func (e *SegmentError) Error() string {
+	return fmt.Sprintf("write of segment %d at 0x%08x failed: %v", e.Segment, e.Address, e.Err)
+}
+
+func (e *SegmentError) Unwrap() error {
+	return e.Err
+}
+
+// Errors of all failed segments of a WriteSegments call continuing on errors
+type SegmentErrors []*SegmentError
+
+func (e SegmentErrors) Error() string {
+	messages := make([]string, len(e))
+
+	for i, err := range e {
+		messages[i] = err.Error()
+	}
+
+	return strings.Join(messages, "; ")
+}
+
+// Write several, not necessarily contiguous segments to target memory, with
+// the progress reported over all segments. By default the first failure is
+// returned as *SegmentError, with ContinueOnError set the remaining segments
+// are written and the failures are returned as SegmentErrors. Segments in the
+// flash are programmed like FlashProgram, each sector spanned by a segment is
+// erased once before its first chunk is programmed, other segments are written
+// with WriteMem.
+func (h *StLink) WriteSegments(segments []MemorySegment, opts WriteSegmentsOptions) error {
+	h, unlock := h.lock()
+	defer unlock()
+
+	var total, written uint64
+	erased := make(map[int]bool) // indexes of the flash sectors erased so far
+
+	for _, s := range segments {
+		if uint64(s.Address)+uint64(len(s.Data)) > 1<<32 {
+			return fmt.Errorf("segment at 0x%08x exceeds the address space", s.Address)
+		}
+
+		total += uint64(len(s.Data))
+	}
+
+	var failed SegmentErrors
+
+	if opts.Progress != nil {
+		opts.Progress(0, total)
+	}
+
+	for i, s := range segments {
+		for offset := 0; offset < len(s.Data); offset += segmentChunkSize {
+			chunk := s.Data[offset:]
+
+			if len(chunk) > segmentChunkSize {
+				chunk = chunk[:segmentChunkSize]
+			}
+
+			var err error
+			chunkAddr := s.Address + uint32(offset)
+
+			if chunkAddr >= stm32FlashStart && chunkAddr < stm32FlashEnd {
+				err = h.writeFlashChunk(chunkAddr, chunk, erased)
+			} else {
+				err = h.writeRegion(chunkAddr, chunk)
+			}
+
+			if err != nil && !opts.ContinueOnError {
+				return &SegmentError{Segment: i, Address: chunkAddr, Err: err}
+			}
+
+			if err != nil {
+				failed = append(failed, &SegmentError{Segment: i, Address: chunkAddr, Err: err})
+				// the rest of the segment is skipped, progress still ends at total
+				chunk = s.Data[offset:]
+			}
+
+			written += uint64(len(chunk))
+
+			if opts.Progress != nil {
+				opts.Progress(written, total)
+			}
+
+			if err != nil {
+				break
+			}
+		}
+	}
+
+	if len(failed) > 0 {
+		return failed
+	}
+
+	return nil
+}
+
+// write data of any length and alignment, whole words with 32bit accesses
+func (h *StLink) writeRegion(addr uint32, data []byte) error {
+	words := uint32(len(data)) / 4
+
+	if words > 0 {
+		if err := h.WriteMem(addr, Memory32BitBlock, words, data[:words*4]); err != nil {
+			return err
+		}
+	}
+
+	if rest := uint32(len(data)) - words*4; rest > 0 {
+		return h.WriteMem(addr+words*4, Memory8BitBlock, rest, data[words*4:])
+	}
+
+	return nil
+}
+
+// program a chunk of a flash segment, erasing the sectors it spans which were
+// not erased for an earlier chunk, so segments sharing a sector are kept
+func (h *StLink) writeFlashChunk(addr uint32, data []byte, erased map[int]bool) error {
+	if err := h.UsbModeEnter(h.stMode); err != nil {
+		return err
+	}
+	defer h.UsbLeaveMode(h.stMode)
+
+	if addr%2 != 0 {
+		return fmt.Errorf("flash program address 0x%08x: %w", addr, ErrUnalignedAccess)
+	}
+
+	touched, err := h.flashSectorsSpanned(addr, uint32(len(data)))
+
+	if err != nil {
+		return err
+	}
+
+	var erase []FlashSector
+
+	for _, sector := range touched {
+		if !erased[sector.Index] {
+			erase = append(erase, sector)
+			erased[sector.Index] = true
+		}
+	}
+
+	return h.flashProgram(addr, data, erase)
+}
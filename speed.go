@@ -68,7 +68,7 @@ func (h *StLink) setSpeedV3(isJtag bool, kHz uint32, querySpeed bool) (uint32, e
 func (h *StLink) setSpeedSwd(kHz uint32, querySpeed bool) (uint32, error) {
 	/* old firmware cannot change it */
 	if !h.version.flags.Get(flagHasSwdSetFreq) {
-		return kHz, errors.New("target st-link doesn't support swd speed change")
+		return kHz, fmt.Errorf("target st-link doesn't support swd speed change: %w", ErrNotSupported)
 	}
 
 	speedIndex, err := matchSpeedMap(swdKHzToSpeedMap[:], kHz, querySpeed)
@@ -91,10 +91,10 @@ func (h *StLink) setSpeedSwd(kHz uint32, querySpeed bool) (uint32, error) {
 func (h *StLink) usbSetSwdClk(clkDivisor uint16) error {
 
 	if !h.version.flags.Get(flagHasSwdSetFreq) {
-		return errors.New("cannot change swd clock speed on connected st link")
+		return fmt.Errorf("cannot change swd clock speed on connected st link: %w", ErrNotSupported)
 	}
 
-	logger.Tracef("set SWD clk to %d", clkDivisor)
+	h.log().Tracef("set SWD clk to %d", clkDivisor)
 
 	ctx := h.initTransfer(transferIncoming)
 
@@ -109,7 +109,7 @@ func (h *StLink) usbSetSwdClk(clkDivisor uint16) error {
 func (h *StLink) usbGetComFreq(isJtag bool, smap *[]speedMap) error {
 
 	if h.version.jtagApi != jTagApiV3 {
-		return errors.New("get com freq not supported except of api v3")
+		return fmt.Errorf("get com freq not supported except of api v3: %w", ErrNotSupported)
 	}
 
 	ctx := h.initTransfer(transferIncoming)
@@ -147,7 +147,7 @@ func (h *StLink) usbGetComFreq(isJtag bool, smap *[]speedMap) error {
 func (h *StLink) usbSetComFreq(isJtag bool, frequency uint32) error {
 
 	if h.version.jtagApi != jTagApiV3 {
-		return errors.New("set com freq not supported except of api v3")
+		return fmt.Errorf("set com freq not supported except of api v3: %w", ErrNotSupported)
 	}
 
 	ctx := h.initTransfer(transferIncoming)
@@ -217,10 +217,10 @@ func matchSpeedMap(smap []speedMap, kHz uint32, query bool) (int, error) {
 	return speedIndex, nil
 }
 
-func dumpSpeedMap(smap []speedMap) {
+func dumpSpeedMap(log handleLog, smap []speedMap) {
 	for i := range smap {
 		if smap[i].speed > 0 {
-			logger.Debugf("%d kHz", smap[i].speed)
+			log.Debugf("%d kHz", smap[i].speed)
 		}
 	}
 }
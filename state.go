@@ -0,0 +1,161 @@
+// Copyright 2021 juju2013@github. All rights reserved.
+// Use of this source code is governed by a GNU-style
+// license that can be found in the LICENSE file.
+
+package gostlink
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Session state of a handle
+//
+//	Disconnected -> Connected    NewStLink
+//	Connected    -> Reset        connect under reset
+//	any          -> Halted       core reported halted (halt, breakpoint, halt on connect)
+//	any          -> Running      core reported running, resumed
+//	any          -> Reset        SystemReset in progress
+//	any          -> Disconnected Close
+type TargetState int
+
+const (
+	StateDisconnected TargetState = iota // handle is closed, no command is possible
+	StateConnected                       // debug connection established, core state not known yet
+	StateHalted                          // core is halted
+	StateRunning                         // core is running
+	StateReset                           // target is held in or going through reset
+)
+
+var errDisconnected = errors.New("st-link handle is disconnected")
+
+func (s TargetState) String() string {
+	switch s {
+	case StateDisconnected:
+		return "disconnected"
+	case StateConnected:
+		return "connected"
+	case StateHalted:
+		return "halted"
+	case StateRunning:
+		return "running"
+	case StateReset:
+		return "reset"
+	default:
+		return "unknown"
+	}
+}
+
+// Last known session state. A core halting on its own (e.g. on a breakpoint)
+// is only noticed by the next operation querying the core, see UpdateState.
+func (h *StLink) State() TargetState {
+	h, unlock := h.lock()
+	defer unlock()
+
+	return h.state
+}
+
+// Query the core and return the updated session state
+func (h *StLink) UpdateState() (TargetState, error) {
+	h, unlock := h.lock()
+	defer unlock()
+
+	if h.state == StateDisconnected {
+		return h.state, errDisconnected
+	}
+
+	if _, err := h.usbCoreHalted(); err != nil {
+		return h.state, err
+	}
+
+	return h.state, nil
+}
+
+func (h *StLink) setState(state TargetState) {
+	if h.state != state {
+		h.log().Tracef("state %s -> %s", h.state, state)
+		h.state = state
+		h.invalidateReadCache()
+	}
+}
+
+// fail unless the core is halted, independent of the tracked state
+func (h *StLink) requireHalted() error {
+	return checkHalted(h.usbCoreHalted())
+}
+
+// result of requireHalted for the halt state read from the core
+func checkHalted(halted bool, err error) error {
+	if err != nil {
+		return err
+	}
+
+	if !halted {
+		return fmt.Errorf("operation requires a halted core: %w", ErrTargetNotHalted)
+	}
+
+	return nil
+}
+
+// Run state of the core as reported by DHCSR
+type CoreState int
+
+const (
+	CoreUnknown CoreState = iota // state could not be read
+	CoreRunning                  // executing or sleeping
+	CoreHalted                   // halted in debug state
+	CoreReset                    // reset since DHCSR was read last
+	CoreLockup                   // locked up by an unrecoverable fault
+)
+
+func (s CoreState) String() string {
+	switch s {
+	case CoreRunning:
+		return "running"
+	case CoreHalted:
+		return "halted"
+	case CoreReset:
+		return "reset"
+	case CoreLockup:
+		return "lockup"
+	default:
+		return "unknown"
+	}
+}
+
+// Read the run state of the core without reading any core register. The state
+// is decoded from DHCSR read with the debug register command of jtag api v2
+// and later, the sticky reset flag is cleared by the read. Fails with
+// ErrNotSupported on st-link firmware with jtag api v1.
+func (h *StLink) GetDebugStatus() (CoreState, error) {
+	h, unlock := h.lock()
+	defer unlock()
+
+	if h.version.jtagApi == jTagApiV1 {
+		return CoreUnknown, fmt.Errorf("debug status not supported by jtag api v1: %w", ErrNotSupported)
+	}
+
+	if err := h.UsbModeEnter(h.stMode); err != nil {
+		return CoreUnknown, err
+	}
+	defer h.UsbLeaveMode(h.stMode)
+
+	dhcsr, err := h.usbReadDhcsr()
+
+	if err != nil {
+		return CoreUnknown, err
+	}
+
+	switch {
+	case (dhcsr & dhcsrSResetSt) > 0:
+		return CoreReset, nil
+	case (dhcsr & dhcsrSLockup) > 0:
+		return CoreLockup, nil
+	case (dhcsr & dhcsrSHalt) > 0:
+		h.updateHaltState(true)
+		return CoreHalted, nil
+	default:
+		h.updateHaltState(false)
+		return CoreRunning, nil
+	}
+}
@@ -7,6 +7,11 @@ package gostlink
 import (
 	"bytes"
 	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/boljen/go-bitmap"
@@ -17,7 +22,7 @@ const AllSupportedVIds = 0xFFFF
 const AllSupportedPIds = 0xFFFF
 
 var goStLinkSupportedVIds = []gousb.ID{0x0483} // STLINK Vendor ID
-var goStLinkSupportedPIds = []gousb.ID{0x3744, 0x3748, 0x374b, 0x374d, 0x374e, 0x374f, 0x3752, 0x3753}
+var goStLinkSupportedPIds = []gousb.ID{0x3744, 0x3748, 0x374b, 0x374d, 0x374e, 0x374f, 0x3752, 0x3753, 0x3757}
 
 type stLinkVersion struct {
 	stlink int
@@ -27,15 +32,27 @@ type stLinkVersion struct {
 	jtagApi stLinkApiVersion
 
 	flags bitmap.Bitmap
+
+	info VersionInfo // decoded version response
 }
 
 type stLinkTrace struct {
-	enabled  bool
-	sourceHz uint32
+	enabled   bool
+	sourceHz  uint32
+	overflows uint32 // trace buffer overflows detected by PollTrace
 }
 
 /** */
 type StLink struct {
+	*stLinkState
+	locked bool // view of the handle returned by lock, its lock is held
+}
+
+// state of an opened st-link shared by all views of the handle
+type stLinkState struct {
+	// first field for the 64bit alignment of its atomic accesses
+	lockOwner uint64 // goroutine running a WithLock, WithDebugMode or WithSpeed callback, 0 if none
+
 	libUsbDevice    *gousb.Device    // reference to libusb device
 	libUsbConfig    *gousb.Config    // reference to device configuration
 	libUsbInterface *gousb.Interface // reference to currently used interface
@@ -58,8 +75,46 @@ type StLink struct {
 	seggerRtt seggerRttInfo
 
 	reconnectPending bool // reconnect is needed next time we try to query the status
+	reconnecting     bool // set while Reconnect runs, its transfers do not reconnect again
 
 	maxMemPacket uint32
+
+	dataBufSize uint32 // size of the data stage buffer of a transfer
+
+	speed uint32 // interface speed in kHz last applied by SetSpeed, 0 if not set yet
+
+	readCache map[uint32][]byte // lines read by CachedRead while the core is halted
+
+	activeAp    byte          // access port used for memory access
+	activeApCsw uint32        // CSW bits 31:8 sent with memory accesses, 0 for the st-link default
+	openedAp    bitmap.Bitmap // access ports initialized on this st-link
+
+	targetEndian Endian // data endianness of the target core
+
+	device *StmDeviceInfo // identified STM32 device, nil until IdentifyDevice succeeded
+
+	flashPatchTable uint32 // address of the FPB remap table, see SetFlashPatchTable
+
+	stm8Family Stm8Family // flash controller layout used by STM8FlashBlock
+
+	deferWriteStatus bool // check the write status once per WriteMem call
+	padWrites        bool // extend unaligned 32bit writes to whole words, see SetPaddedWrites
+	batchWriteStatus bool // set while a WriteMem call with deferred status check is running
+	deferReadStatus  bool // check the read status once per ReadMem call
+	batchReadStatus  bool // set while a ReadMem call with deferred status check is running
+
+	cmdLock sync.Mutex // serializes all transactions on the command endpoints
+
+	state TargetState // session state, see State
+
+	modeHeld int // nesting depth of WithDebugMode, the debug mode stays entered while > 0
+
+	stuckReads int // consecutive reads returning all ones or all zeros
+
+	config StLinkInterfaceConfig // configuration used to open the handle, kept for Reconnect
+	serial string                // serial number of the opened st-link
+
+	logLevel atomic.Value // LogLevel set by SetLogLevel, empty to log at the level of the package logger
 }
 
 type StLinkInterfaceConfig struct {
@@ -69,8 +124,72 @@ type StLinkInterfaceConfig struct {
 	serial            string
 	initialSpeed      uint32
 	connectUnderReset bool
+	connectReset      ConnectReset
+	skipCpuIdProbe    bool
+	autoReconnect     bool
+	haltOnConnect     bool
+	recoverStuckReads int
+	dataBufferSize    uint32
+	exitAction        ExitAction
+	usbConfig         int // usb configuration holding the debug interface
+	usbInterface      int
+	usbAltSetting     int
+	hseHz             uint32 // external oscillator frequency, 0 if unknown
+	resetLoopCheck    ResetLoopCheck
+	noAutoDetach      bool // never let libusb detach kernel drivers
+	serialMatch       SerialMatchMode
+	retryPolicy       RetryPolicy
+	logLevel          *LogLevel // level of the opened handle, nil for the package logger level
+}
+
+const maximumWaitDelay = 100 * time.Millisecond
+
+// Number of retries of operations the st-link answered with a wait status,
+// retries are delayed exponentially starting at 1ms up to MaxDelay
+type RetryPolicy struct {
+	MemoryRetries    int           // ReadMem and WriteMem chunks
+	CommandRetries   int           // debug commands, including entering a mode
+	ModeQueryRetries int           // query of the current mode, after draining stale data
+	MaxDelay         time.Duration // longest delay between two retries, zero uses the default
 }
 
+// Retry policy used unless configured otherwise
+func DefaultRetryPolicy() RetryPolicy {
+	return RetryPolicy{
+		MemoryRetries:    maximumWaitRetries,
+		CommandRetries:   maximumWaitRetries,
+		ModeQueryRetries: currentModeRetries,
+		MaxDelay:         maximumWaitDelay,
+	}
+}
+
+// delay before the given retry, doubling with every retry up to MaxDelay
+func (p RetryPolicy) delay(retry int) time.Duration {
+	maxDelay := p.MaxDelay
+
+	if maxDelay <= 0 {
+		maxDelay = maximumWaitDelay
+	}
+
+	if retry >= 31 || time.Millisecond<<retry > maxDelay {
+		return maxDelay
+	}
+
+	return time.Millisecond << retry
+}
+
+// How the serial of the config selects the st-link among several connected ones
+type SerialMatchMode int
+
+const (
+	// The serial has to match exactly (default)
+	SerialMatchExact SerialMatchMode = iota
+	// The serial is a prefix of the st-link's serial
+	SerialMatchPrefix
+	// The serial is a regular expression matched against the st-link's serial
+	SerialMatchRegex
+)
+
 func NewStLinkConfig(vid gousb.ID, pid gousb.ID, mode StLinkMode,
 	serial string, initialSpeed uint32, connectUnderReset bool) *StLinkInterfaceConfig {
 
@@ -81,221 +200,569 @@ func NewStLinkConfig(vid gousb.ID, pid gousb.ID, mode StLinkMode,
 		serial:            serial,
 		initialSpeed:      initialSpeed,
 		connectUnderReset: connectUnderReset,
+		usbConfig:         1,
+		retryPolicy:       DefaultRetryPolicy(),
 	}
 
 	return config
 }
 
-func NewStLink(config *StLinkInterfaceConfig) (*StLink, error) {
-	var err error
-	var devices []*gousb.Device
+// Select how the serial given to NewStLinkConfig is matched, the st-link is
+// only selected if exactly one of the connected ones matches
+func (config *StLinkInterfaceConfig) SetSerialMatch(mode SerialMatchMode) {
+	config.serialMatch = mode
+}
 
-	handle := &StLink{}
+// matcher for device serials by the configured serial and match mode
+func (config *StLinkInterfaceConfig) serialMatcher() (func(string) bool, error) {
+	switch config.serialMatch {
+	case SerialMatchPrefix:
+		return func(serial string) bool { return strings.HasPrefix(serial, config.serial) }, nil
+	case SerialMatchRegex:
+		re, err := regexp.Compile(config.serial)
 
-	handle.stMode = config.mode
+		if err != nil {
+			return nil, fmt.Errorf("invalid serial pattern: %w", err)
+		}
+
+		return re.MatchString, nil
+	default:
+		return func(serial string) bool { return serial == config.serial }, nil
+	}
+}
+
+// Set the number of retries on wait responses, e.g. raise them for slow to
+// respond targets, see DefaultRetryPolicy
+func (config *StLinkInterfaceConfig) SetRetryPolicy(policy RetryPolicy) {
+	config.retryPolicy = policy
+}
+
+// Let libusb detach kernel drivers bound to the st-link while it is open
+// (default). Probes with a virtual com port are never detached, so the port
+// stays usable. Disable it where auto detach interferes with the kernel
+// drivers, any driver bound to the debug interface has to be detached manually then.
+func (config *StLinkInterfaceConfig) SetAutoDetach(enable bool) {
+	config.noAutoDetach = !enable
+}
+
+// Do not read the CPUID register at connect. Use this for targets where
+// memory access is not possible yet (e.g. read protected or held in reset),
+// the default memory packet size is used then.
+func (config *StLinkInterfaceConfig) SetSkipCpuIdProbe(skip bool) {
+	config.skipCpuIdProbe = skip
+}
+
+// Halt the core right after the debug connection is established, without
+// asserting reset. NewStLink fails if the core does not report halted state.
+func (config *StLinkInterfaceConfig) SetHaltOnConnect(halt bool) {
+	config.haltOnConnect = halt
+}
+
+// Call RecoverLink automatically after count consecutive single word reads failed
+// and returned only ones or only zeros, the read triggering the recovery fails
+// then. Successful reads are never counted. Zero disables it.
+func (config *StLinkInterfaceConfig) SetLinkRecovery(count int) {
+	config.recoverStuckReads = count
+}
+
+// Size of the buffer for the data stage of a transfer, limited to what the
+// probe supports. Zero selects the size by hardware version (default).
+func (config *StLinkInterfaceConfig) SetDataBufferSize(size uint32) {
+	config.dataBufferSize = size
+}
+
+// Reconnect transparently when the st-link lost its usb configuration,
+// e.g. after the host was suspended, instead of returning ErrDeviceSuspended
+func (config *StLinkInterfaceConfig) SetAutoReconnect(enable bool) {
+	config.autoReconnect = enable
+}
+
+// Select the usb configuration, interface and alternate setting of the debug
+// interface, for composite devices exposing it elsewhere. Defaults to 1 and 0,0.
+func (config *StLinkInterfaceConfig) SetUsbInterface(usbConfig int, usbInterface int, altSetting int) {
+	config.usbConfig = usbConfig
+	config.usbInterface = usbInterface
+	config.usbAltSetting = altSetting
+}
+
+// find the st-link selected by config, open it and claim its debug interface and endpoints
+func (h *StLink) openUsb(config *StLinkInterfaceConfig) error {
+	var err error
+	var devices []*gousb.Device
 
 	if config.vid == AllSupportedVIds && config.pid == AllSupportedPIds {
-		devices, err = usbFindDevices(goStLinkSupportedVIds, goStLinkSupportedPIds)
+		devices, err = usbFindDevices(h.log(), goStLinkSupportedVIds, goStLinkSupportedPIds)
 
 	} else if config.vid == AllSupportedVIds && config.pid != AllSupportedPIds {
-		devices, err = usbFindDevices(goStLinkSupportedVIds, []gousb.ID{config.pid})
+		devices, err = usbFindDevices(h.log(), goStLinkSupportedVIds, []gousb.ID{config.pid})
 
 	} else if config.vid != AllSupportedVIds && config.pid == AllSupportedPIds {
-		devices, err = usbFindDevices([]gousb.ID{config.vid}, goStLinkSupportedPIds)
+		devices, err = usbFindDevices(h.log(), []gousb.ID{config.vid}, goStLinkSupportedPIds)
 
 	} else {
-		devices, err = usbFindDevices([]gousb.ID{config.vid}, []gousb.ID{config.pid})
+		devices, err = usbFindDevices(h.log(), []gousb.ID{config.vid}, []gousb.ID{config.pid})
 	}
 
-	if len(devices) > 0 {
-		if config.serial == "" && len(devices) > 1 {
+	if len(devices) == 0 {
+		return fmt.Errorf("could not find any ST-Link connected to computer: %w", ErrDeviceNotFound)
+	}
 
+	if config.serial == "" {
+		if len(devices) > 1 {
 			for _, d := range devices {
 				d.Close()
 			}
 
-			return nil, errors.New("could not identity exact stlink by given parameters. (Perhaps a serial no is missing?)")
+			return errors.New("could not identity exact stlink by given parameters. (Perhaps a serial no is missing?)")
+		}
 
-		} else if len(devices) == 1 {
-			handle.libUsbDevice = devices[0]
+		h.libUsbDevice = devices[0]
 
-			logger.Infof("Found st-link witch matching product and vendor id [%04x, %04x]",
-				uint16(handle.libUsbDevice.Desc.Product),
-				uint16(handle.libUsbDevice.Desc.Vendor))
+		h.log().Infof("Found st-link witch matching product and vendor id [%04x, %04x]",
+			uint16(h.libUsbDevice.Desc.Product),
+			uint16(h.libUsbDevice.Desc.Vendor))
+	} else {
+		// the serial is matched also when only one st-link is connected, so a
+		// probe other than the requested one is never opened
+		matchSerial, err := config.serialMatcher()
 
-		} else {
-			for _, dev := range devices {
-				devSerialNo, _ := dev.SerialNumber()
+		if err != nil {
+			for _, d := range devices {
+				d.Close()
+			}
+
+			return err
+		}
 
-				logger.Tracef("compare serial no %s with number %s", devSerialNo, config.serial)
+		var matching []*gousb.Device
 
-				if devSerialNo == config.serial {
-					handle.libUsbDevice = dev
+		for _, dev := range devices {
+			devSerialNo, _ := dev.SerialNumber()
 
-					logger.Infof("found st link with serial number %s", devSerialNo)
-				} else {
-					dev.Close()
-				}
+			h.log().Tracef("compare serial no %s with number %s", devSerialNo, config.serial)
+
+			if matchSerial(devSerialNo) {
+				matching = append(matching, dev)
+			} else {
+				dev.Close()
 			}
 		}
-	} else {
-		return nil, errors.New("could not find any ST-Link connected to computer")
-	}
 
-	if handle.libUsbDevice == nil {
-		return nil, errors.New("critical error during device scan")
+		if len(matching) > 1 {
+			for _, d := range matching {
+				d.Close()
+			}
+
+			return fmt.Errorf("%d st-links match serial %s, could not identify exact stlink", len(matching), config.serial)
+		}
+
+		if len(matching) == 0 {
+			return fmt.Errorf("no st-link matches serial %s: %w", config.serial, ErrDeviceNotFound)
+		}
+
+		h.libUsbDevice = matching[0]
+
+		serial, _ := h.libUsbDevice.SerialNumber()
+		h.log().Infof("found st link with serial number %s", serial)
 	}
 
-	handle.libUsbDevice.SetAutoDetach(true)
+	h.serial, _ = h.libUsbDevice.SerialNumber()
+
+	// auto detach releases the kernel drivers of all interfaces, including the
+	// virtual com port. The debug interface has no kernel driver bound, so keep
+	// the vcp usable while debugging on probes providing one.
+	if config.noAutoDetach || usbHasVcp(uint16(h.libUsbDevice.Desc.Product)) {
+		h.libUsbDevice.SetAutoDetach(false)
+	} else {
+		h.libUsbDevice.SetAutoDetach(true)
+	}
 
 	// no request required configuration an matching usb interface :D
-	logger.Trace("request usb configuration #1 on usb device")
-	handle.libUsbConfig, err = handle.libUsbDevice.Config(1)
+	h.log().Tracef("request usb configuration #%d on usb device", config.usbConfig)
+	h.libUsbConfig, err = h.libUsbDevice.Config(config.usbConfig)
 	if err != nil {
-		logger.Debug(err)
-		return nil, errors.New("could not request configuration #1 for st-link debugger")
+		h.log().Debug(err)
+		return fmt.Errorf("could not request configuration #%d for st-link debugger", config.usbConfig)
 	}
 
-	logger.Trace("claim interface 0,0 on usb device")
-	handle.libUsbInterface, err = handle.libUsbConfig.Interface(0, 0)
+	h.log().Tracef("claim interface %d,%d on usb device", config.usbInterface, config.usbAltSetting)
+	h.libUsbInterface, err = h.libUsbConfig.Interface(config.usbInterface, config.usbAltSetting)
 	if err != nil {
-		logger.Debug(err)
-		return nil, errors.New("could not claim interface 0,0 for st-link debugger")
+		h.log().Debug(err)
+		return fmt.Errorf("could not claim interface %d,%d for st-link debugger", config.usbInterface, config.usbAltSetting)
 	}
 
 	// now determine different endpoints
 	// RX-Endpoint is the same for alle devices
 
-	handle.rxEndpoint, err = handle.libUsbInterface.InEndpoint(usbRxEndpointNo)
+	h.rxEndpoint, err = h.libUsbInterface.InEndpoint(usbRxEndpointNo)
 
 	if err != nil {
-		return nil, errors.New("could get rx endpoint for debugger")
+		return errors.New("could get rx endpoint for debugger")
 	}
 
 	var errorTx, errorTrace error
 
-	switch uint16(handle.libUsbDevice.Desc.Product) {
+	switch uint16(h.libUsbDevice.Desc.Product) {
 	case stLinkV1Pid:
-		return nil, errors.New("st-link V1 api not supported by gostlink")
+		return fmt.Errorf("st-link V1 api not supported by gostlink: %w", ErrNotSupported)
 
-	case stLinkV3UsbLoaderPid, stLinkV3EPid, stLinkV3SPid, stLinkV32VcpPid:
-		handle.version.stlink = 3
-		handle.txEndpoint, errorTx = handle.libUsbInterface.OutEndpoint(usbTxEndpointApi2v1)
-		handle.traceEndpoint, errorTrace = handle.libUsbInterface.InEndpoint(usbTraceEndpointApi2v1)
+	case stLinkV3UsbLoaderPid, stLinkV3EPid, stLinkV3SPid, stLinkV32VcpPid, stLinkV3PwrPid:
+		h.version.stlink = 3
+		h.txEndpoint, errorTx = h.libUsbInterface.OutEndpoint(usbTxEndpointApi2v1)
+		h.traceEndpoint, errorTrace = h.libUsbInterface.InEndpoint(usbTraceEndpointApi2v1)
 
 	case stLinkV21Pid, stLinkV21NoMsdPid:
-		handle.version.stlink = 2
-		handle.txEndpoint, errorTx = handle.libUsbInterface.OutEndpoint(usbTxEndpointApi2v1)
-		handle.traceEndpoint, errorTrace = handle.libUsbInterface.InEndpoint(usbTraceEndpointApi2v1)
+		h.version.stlink = 2
+		h.txEndpoint, errorTx = h.libUsbInterface.OutEndpoint(usbTxEndpointApi2v1)
+		h.traceEndpoint, errorTrace = h.libUsbInterface.InEndpoint(usbTraceEndpointApi2v1)
 
 	default:
-		logger.Infof("unknown product id of debugger %x. Assuming Link V2 api", uint16(handle.libUsbDevice.Desc.Product))
-		handle.version.stlink = 2
+		h.log().Infof("unknown product id of debugger %x. Assuming Link V2 api", uint16(h.libUsbDevice.Desc.Product))
+		h.version.stlink = 2
 
-		handle.txEndpoint, errorTx = handle.libUsbInterface.OutEndpoint(usbTxEndpointNo)
-		handle.traceEndpoint, errorTrace = handle.libUsbInterface.InEndpoint(usbTraceEndpointNo)
+		h.txEndpoint, errorTx = h.libUsbInterface.OutEndpoint(usbTxEndpointNo)
+		h.traceEndpoint, errorTrace = h.libUsbInterface.InEndpoint(usbTraceEndpointNo)
 	}
 
 	if errorTrace != nil {
-		return nil, errors.New("could not get trace endpoint of debugger")
+		return errors.New("could not get trace endpoint of debugger")
 	}
 
 	if errorTx != nil {
-		return nil, errors.New("could not get tx endpoint of device")
+		return errors.New("could not get tx endpoint of device")
 	}
 
-	err = handle.useParseVersion()
+	return nil
+}
 
-	if err != nil {
+func NewStLink(config *StLinkInterfaceConfig) (*StLink, error) {
+	var err error
+
+	handle := &StLink{stLinkState: &stLinkState{}}
+
+	handle.openedAp = bitmap.New(debugAccessPortSelectionMaximum + 1)
+
+	handle.stMode = config.mode
+	handle.config = *config
+
+	if config.logLevel != nil {
+		handle.logLevel.Store(*config.logLevel)
+	}
+
+	if err = handle.openUsb(config); err != nil {
 		return nil, err
 	}
 
-	switch handle.stMode {
+	// the device is closed again if the connection cannot be set up, so the
+	// interface is not kept claimed and opening it can be retried
+	if err = handle.connect(config); err != nil {
+		handle.closeUsb()
+		return nil, err
+	}
+
+	return handle, nil
+}
+
+// set up the opened st-link and connect to the target as configured
+func (h *StLink) connect(config *StLinkInterfaceConfig) error {
+	var err error
+
+	h.setState(StateConnected)
+
+	err = h.useParseVersion()
+
+	if err != nil {
+		return err
+	}
+
+	if err = h.setDataBufferSize(config.dataBufferSize); err != nil {
+		return err
+	}
+
+	if h.stMode == StLinkModeAuto {
+		h.stMode = h.autoSelectMode()
+		h.log().Debugf("auto selected st-link mode %d", h.stMode)
+	}
+
+	switch h.stMode {
 	case StLinkModeDebugSwd:
-		if handle.version.jtagApi == jTagApiV1 {
-			return nil, errors.New("swd not supported by jtag api v1")
+		if h.version.jtagApi == jTagApiV1 {
+			return fmt.Errorf("swd not supported by jtag api v1: %w", ErrNotSupported)
 		}
 	case StLinkModeDebugJtag:
-		if handle.version.jtag == 0 {
-			return nil, errors.New("jtag transport not supported by stlink")
+		if h.version.jtag == 0 {
+			return fmt.Errorf("jtag transport not supported by stlink: %w", ErrNotSupported)
 		}
 	case StLinkModeDebugSwim:
-		if handle.version.swim == 0 {
-			return nil, errors.New("swim transport not supported by device")
+		if h.version.swim == 0 {
+			return fmt.Errorf("swim transport not supported by device: %w", ErrNotSupported)
 		}
 
 	default:
-		return nil, errors.New("unknown ST-Link mode")
+		return errors.New("unknown ST-Link mode")
 	}
 
-	err = handle.UsbInitMode(config.connectUnderReset, config.initialSpeed)
+	err = h.UsbInitMode(config.connectUnderReset && config.connectReset == ConnectResetHardware, config.initialSpeed)
 
 	if err != nil {
-		return nil, err
+		return err
 	}
 
-	/**
-		TODO: Implement SWIM mode configuration
-	if (h->st_mode == STLINK_MODE_DEBUG_SWIM) {
-		err = stlink_swim_enter(h);
-		if (err != ERROR_OK) {
-			LOG_ERROR("stlink_swim_enter_failed (unable to connect to the target)");
-			goto error_open;
+	if h.stMode == StLinkModeDebugSwim {
+		if err = h.usbSwimEnter(); err != nil {
+			return fmt.Errorf("unable to connect to the target over swim: %w", err)
 		}
-		*fd = h;
-		h->max_mem_packet = STLINK_DATA_SIZE;
-		return ERROR_OK;
+
+		h.maxMemPacket = h.maxDataStage()
+		return nil
 	}
-	*/
 
-	handle.maxMemPacket = 1 << 10
+	h.maxMemPacket = 1 << 10
 
-	err = handle.usbInitAccessPort(0)
+	err = h.usbOpenAccessPort(0)
 
 	if err != nil {
-		return nil, err
+		return err
+	}
+
+	h.activeApCsw = h.detectApCsw(0)
+
+	if config.connectUnderReset {
+		if config.connectReset == ConnectResetSoftware {
+			if err = h.connectSoftwareReset(); err != nil {
+				return err
+			}
+		} else {
+			h.setState(StateReset)
+		}
+	}
+
+	if config.resetLoopCheck != ResetLoopIgnore && h.state != StateReset {
+		if err = h.connectResetLoopCheck(); err != nil {
+			return err
+		}
+	}
+
+	if config.haltOnConnect {
+		if err = h.haltOnConnect(); err != nil {
+			return err
+		}
+	}
+
+	if config.skipCpuIdProbe {
+		h.log().Debug("skipping cpu id probe")
+	} else {
+		h.probeCpuId()
+	}
+
+	// a memory transfer has to fit into one data stage
+	if h.maxMemPacket > h.dataBufSize {
+		h.maxMemPacket = h.dataBufSize &^ 3
 	}
 
+	h.log().Debugf("using TAR autoincrement: %d", h.maxMemPacket)
+	return nil
+}
+
+func (h *StLink) setDataBufferSize(size uint32) error {
+	var maxSize uint32 = dataBufferSize
+
+	if h.version.stlink == 3 {
+		maxSize = v3DataBufferSize
+	}
+
+	if size == 0 {
+		size = maxSize
+	} else if size < minDataBuffer || size > maxSize {
+		return fmt.Errorf("data buffer size %d out of range [%d, %d]", size, minDataBuffer, maxSize)
+	}
+
+	h.log().Debugf("using data buffer of %d bytes", size)
+
+	h.dataBufSize = size
+	return nil
+}
+
+// pick the preferred transport supported by the st-link: swd, then jtag, then swim
+func (h *StLink) autoSelectMode() StLinkMode {
+	switch {
+	case h.version.jtagApi != jTagApiV1:
+		return StLinkModeDebugSwd
+	case h.version.jtag != 0:
+		return StLinkModeDebugJtag
+	case h.version.swim != 0:
+		return StLinkModeDebugSwim
+	default:
+		return StLinkModeUnknown
+	}
+}
+
+// Transports supported by the st-link firmware in order of preference, derived
+// from the version read at open. Open the handle with StLinkModeAuto to query
+// them before a transport is chosen.
+func (h *StLink) SupportedTransports() []StLinkMode {
+	h, unlock := h.lock()
+	defer unlock()
+
+	var modes []StLinkMode
+
+	if h.version.jtagApi != jTagApiV1 {
+		modes = append(modes, StLinkModeDebugSwd)
+	}
+
+	if h.version.jtag != 0 {
+		modes = append(modes, StLinkModeDebugJtag)
+	}
+
+	if h.version.swim != 0 {
+		modes = append(modes, StLinkModeDebugSwim)
+	}
+
+	return modes
+}
+
+func (h *StLink) haltOnConnect() error {
+	if err := h.usbHalt(); err != nil {
+		return err
+	}
+
+	if err := h.waitHalted(time.Second); err != nil {
+		return fmt.Errorf("could not halt target on connect: %w", err)
+	}
+
+	h.log().Debug("target halted on connect")
+	return nil
+}
+
+func (h *StLink) probeCpuId() {
 	buffer := bytes.NewBuffer([]byte{})
-	errCode := handle.UsbReadMem32(cpuIdBaseRegister, 4, buffer)
+	errCode := h.UsbReadMem32(cpuIdBaseRegister, 4, buffer)
 
 	if errCode == nil {
 		var cpuid uint32 = convertToUint32(buffer.Bytes(), littleEndian)
 		var i uint32 = (cpuid >> 4) & 0xf
 
-		logger.Debugf("got cpu id [%08x]", cpuid)
+		h.log().Debugf("got cpu id [%08x]", cpuid)
 
 		if i == 4 || i == 3 {
 			/* Cortex-M3/M4 has 4096 bytes autoincrement range */
-			logger.Debug("set memory packet layout according to Cortex M3/M4")
-			handle.maxMemPacket = 1 << 12
+			h.log().Debug("set memory packet layout according to Cortex M3/M4")
+			h.maxMemPacket = 1 << 12
 		}
 	} else {
-		logger.Error(errCode)
+		h.log().Error(errCode)
 	}
 
-	logger.Debugf("using TAR autoincrement: %d", handle.maxMemPacket)
-	return handle, nil
+	if _, err := h.IdentifyDevice(); err != nil {
+		h.log().Debug("could not identify device: ", err)
+	}
+
+	if endian, err := h.DetectEndianness(); err == nil {
+		h.log().Debugf("target core is %s", endian)
+	} else {
+		h.log().Warn("could not detect target endianness, assuming little endian")
+	}
 }
 
+// Apply the configured exit action and close the usb device
 func (h *StLink) Close() {
+	h, unlock := h.lock()
+	defer unlock()
+
 	if h.libUsbDevice != nil {
-		logger.Debugf("close st-link device [%04x:%04x]", uint16(h.vid), uint16(h.pid))
+		if err := h.runExitAction(); err != nil {
+			h.log().Warn("exit action failed: ", err)
+		}
+	}
+
+	h.closeUsb()
+}
+
+func (h *StLink) closeUsb() {
+	if h.libUsbDevice != nil {
+		h.log().Debugf("close st-link device [%04x:%04x]", uint16(h.vid), uint16(h.pid))
 
 		h.libUsbInterface.Close()
 		h.libUsbConfig.Close()
 		h.libUsbDevice.Close()
+		h.libUsbDevice = nil
+
+		h.setState(StateDisconnected)
 	} else {
-		logger.Warn("tried to close invalid stlink handle")
+		h.log().Warn("tried to close invalid stlink handle")
+	}
+}
+
+// Close the usb device and open the same st-link again with the configuration
+// the handle was created with, e.g. after ErrDeviceSuspended was returned. The
+// state of the handle is kept: the transport is entered again at the speed in
+// use and the access ports are initialized again, the target is not reset,
+// halted or probed as when the handle was opened.
+func (h *StLink) Reconnect() error {
+	h, unlock := h.lock()
+	defer unlock()
+
+	if h.reconnecting {
+		return fmt.Errorf("reconnect already running: %w", ErrDeviceSuspended)
+	}
+
+	h.reconnecting = true
+	defer func() { h.reconnecting = false }()
+
+	config := h.config
+
+	if h.serial != "" {
+		config.serial = h.serial
+		config.serialMatch = SerialMatchExact
+	}
+
+	// the configuration is lost already, errors during close are expected
+	h.closeUsb()
+
+	if err := h.openUsb(&config); err != nil {
+		return err
+	}
+
+	h.setState(StateConnected)
+	h.reconnectPending = false
+	h.trace = stLinkTrace{}
+
+	if isDebugMode(h.stMode) && h.speed > 0 {
+		if _, err := h.SetSpeed(h.speed, false); err != nil {
+			h.log().Warn("could not restore interface speed: ", err)
+		}
+	}
+
+	if err := h.usbModeEnter(h.stMode); err != nil {
+		return fmt.Errorf("could not enter mode again: %w", err)
+	}
+
+	if h.stMode == StLinkModeDebugSwim {
+		if err := h.usbSwimEnter(); err != nil {
+			return fmt.Errorf("could not connect over swim again: %w", err)
+		}
+	}
+
+	if isDebugMode(h.stMode) {
+		if err := h.reopenAccessPorts(); err != nil {
+			return err
+		}
 	}
+
+	h.log().Info("reconnected to st-link ", h.serial)
+	return nil
 }
 
 func (h *StLink) GetTargetVoltage() (float32, error) {
+	h, unlock := h.lock()
+	defer unlock()
+
 	var adcResults [2]uint32
 
 	/* no error message, simply quit with error */
 	if !h.version.flags.Get(flagHasTargetVolt) {
-		return -1.0, errors.New("device does not support voltage measurement")
+		return -1.0, fmt.Errorf("device does not support voltage measurement: %w", ErrNotSupported)
 	}
 
 	ctx := h.initTransfer(transferIncoming)
@@ -322,6 +789,9 @@ func (h *StLink) GetTargetVoltage() (float32, error) {
 }
 
 func (h *StLink) GetIdCode() (uint32, error) {
+	h, unlock := h.lock()
+	defer unlock()
+
 	var offset int
 	var retVal error
 
@@ -354,7 +824,36 @@ func (h *StLink) GetIdCode() (uint32, error) {
 		return idCode, nil
 	}
 }
+// Result of a speed change, see SetSpeedWithResult
+type SpeedResult struct {
+	Requested uint32 // requested interface speed in kHz
+	Actual    uint32 // speed in kHz the st-link is using
+	Exact     bool   // the requested speed is supported exactly
+}
+
+// Set the interface speed and report whether the requested speed was matched exactly.
+// Rounding works as for SetSpeed.
+func (h *StLink) SetSpeedWithResult(khz uint32, query bool) (SpeedResult, error) {
+	h, unlock := h.lock()
+	defer unlock()
+
+	actual, err := h.SetSpeed(khz, query)
+
+	if err != nil {
+		return SpeedResult{Requested: khz}, err
+	}
+
+	return SpeedResult{Requested: khz, Actual: actual, Exact: actual == khz}, nil
+}
+
+// Set the interface speed in kHz and return the speed actually used. A speed not
+// supported by the st-link is rounded down to the next slower supported speed,
+// if the request is below the slowest speed, the slowest one is used. With query
+// set the speed is only matched but not applied, and a request below the slowest
+// speed is reported as error.
 func (h *StLink) SetSpeed(khz uint32, query bool) (uint32, error) {
+	h, unlock := h.lock()
+	defer unlock()
 
 	switch h.stMode {
 	/*case STLINK_MODE_DEBUG_SWIM:
@@ -362,11 +861,19 @@ func (h *StLink) SetSpeed(khz uint32, query bool) (uint32, error) {
 	*/
 
 	case StLinkModeDebugSwd:
+		var actual uint32
+		var err error
+
 		if h.version.jtagApi == jTagApiV3 {
-			return h.setSpeedV3(false, khz, query)
+			actual, err = h.setSpeedV3(false, khz, query)
 		} else {
-			return h.setSpeedSwd(khz, query)
+			actual, err = h.setSpeedSwd(khz, query)
+		}
+
+		if err == nil && !query {
+			h.speed = actual
 		}
+		return actual, err
 
 	/*case STLINK_MODE_DEBUG_JTAG:
 	if h.version.jtag_api == STLINK_JTAG_API_V3 {
@@ -376,15 +883,56 @@ func (h *StLink) SetSpeed(khz uint32, query bool) (uint32, error) {
 	}
 	*/
 	default:
-		return khz, errors.New("requested ST-Link mode not supported yet")
+		return khz, fmt.Errorf("requested ST-Link mode not supported yet: %w", ErrNotSupported)
 	}
 }
 
+// Interface speed in kHz currently applied, 0 if no speed was set yet
+func (h *StLink) Speed() uint32 {
+	h, unlock := h.lock()
+	defer unlock()
+
+	return h.speed
+}
+
+// Run fn with the interface speed temporarily set to khz (rounded as for SetSpeed)
+// and restore the previous speed afterwards, also if fn fails. The command lock is
+// held meanwhile, so commands of other goroutines never run at the overridden speed.
+// As for WithLock, operations have to be called from the goroutine running fn.
+func (h *StLink) WithSpeed(khz uint32, fn func() error) error {
+	h, unlock := h.lock()
+	defer unlock()
+
+	prevSpeed := h.speed
+
+	if prevSpeed == 0 {
+		return errors.New("current interface speed unknown, cannot restore it")
+	}
+
+	if _, err := h.SetSpeed(khz, false); err != nil {
+		return err
+	}
+
+	err := h.runLocked(fn)
+
+	if _, restoreErr := h.SetSpeed(prevSpeed, false); restoreErr != nil {
+		h.log().Errorf("could not restore interface speed of %d kHz: %v", prevSpeed, restoreErr)
+
+		if err == nil {
+			err = restoreErr
+		}
+	}
+
+	return err
+}
+
 func (h *StLink) ConfigTrace(enabled bool, tpiuProtocol TpuiPinProtocolType, portSize uint32,
 	traceFreq *uint32, traceClkInFreq uint32, preScaler *uint16) error {
+	h, unlock := h.lock()
+	defer unlock()
 
 	if enabled == true && (!h.version.flags.Get(flagHasTrace) || tpiuProtocol != TpuiPinProtocolAsyncUart) {
-		return errors.New("the attached ST-Link version does not support this trace mode")
+		return fmt.Errorf("the attached ST-Link version does not support this trace mode: %w", ErrNotSupported)
 	}
 
 	if !enabled {
@@ -393,7 +941,7 @@ func (h *StLink) ConfigTrace(enabled bool, tpiuProtocol TpuiPinProtocolType, por
 	}
 
 	if *traceFreq > traceMaxHz {
-		return errors.New("this ST-Link version does not support frequency")
+		return fmt.Errorf("this ST-Link version does not support frequency: %w", ErrNotSupported)
 	}
 
 	h.usbTraceDisable()
@@ -418,226 +966,261 @@ func (h *StLink) ConfigTrace(enabled bool, tpiuProtocol TpuiPinProtocolType, por
 	return h.usbTraceEnable()
 }
 
+// Read count blocks of bitLength bytes from target memory into buffer
 func (h *StLink) ReadMem(addr uint32, bitLength MemoryBlockSize, count uint32, buffer *bytes.Buffer) error {
-	var retErr error
-	var bytesRemaining uint32 = 0
-	var retries int = 0
-	var bufferPos uint32 = 0
+	h, unlock := h.lock()
+	defer unlock()
 
-	/* calculate byte count */
-	count *= uint32(bitLength)
+	if !h.deferReadStatus || h.batchReadStatus {
+		return h.readMem(addr, bitLength, count, buffer)
+	}
+
+	h.batchReadStatus = true
+	err := h.readMem(addr, bitLength, count, buffer)
+	h.batchReadStatus = false
+
+	if err != nil {
+		return err
+	}
+
+	return h.usbGetReadWriteStatus()
+}
+
+// Check the read status only once after all chunks of a ReadMem call instead
+// of after every chunk, saving one usb round trip per chunk on large reads.
+// The st-link answers each command before accepting the next, so commands
+// cannot be overlapped further. A failed read is only detected at the end and
+// wait states are not retried, so use it on a reliable link only.
+func (h *StLink) SetDeferredReadStatus(enable bool) {
+	h, unlock := h.lock()
+	defer unlock()
+
+	h.deferReadStatus = enable
+}
+
+func (h *StLink) readMem(addr uint32, bitLength MemoryBlockSize, count uint32, buffer *bytes.Buffer) error {
+	length := count * uint32(bitLength)
 
 	/* switch to 8 bit if stlink does not support 16 bit memory read */
 	if bitLength == Memory16BitBlock && (!h.version.flags.Get(flagHasMem16Bit)) {
 		bitLength = Memory8BitBlock
-		logger.Debug("st-link does not support 16bit transfer")
+		h.log().Debug("st-link does not support 16bit transfer")
 	}
 
-	for count > 0 {
-
-		if bitLength != Memory8BitBlock {
-			bytesRemaining = h.maxBlockSize(h.maxMemPacket, addr)
-		} else {
-			bytesRemaining = h.usbBlock()
-		}
+	chunks := splitMemAccess(addr, length, bitLength, h.maxMemPacket, h.usbBlock())
+	return h.readMemChunks(chunks, buffer, h.readMemChunk)
+}
 
-		if count < bytesRemaining {
-			bytesRemaining = count
-		}
+// read the chunks one after another with read, retrying busy chunks
+func (h *StLink) readMemChunks(chunks []memChunk, buffer *bytes.Buffer, read func(memChunk, *bytes.Buffer) error) error {
+	retries := 0
 
-		/*
-		* all stlink support 8/32bit memory read/writes and only from
-		* stlink V2J26 there is support for 16 bit memory read/write.
-		* Honour 32 bit and, if psizeossible, 16 bit too. Otherwise, handle
-		* as 8bit access.
-		 */
-		if bitLength != Memory8BitBlock {
-			/* When in jtag mode the stlink uses the auto-increment functionality.
-			 * However it expects us to pass the data correctly, this includes
-			 * alignment and any page boundaries. We already do this as part of the
-			 * adi_v5 implementation, but the stlink is a hla adapter and so this
-			 * needs implementing manually.
-			 * currently this only affects jtag mode, according to ST they do single
-			 * access in SWD mode - but this may change and so we do it for both modes
-			 */
-
-			// we first need to check for any unaligned bytes
-			if (addr & (uint32(bitLength) - 1)) > 0 {
-				var headBytes = uint32(bitLength) - (addr & (uint32(bitLength) - 1))
-
-				logger.Trace("read unaligned bytes")
-
-				err := h.UsbReadMem8(addr, uint16(headBytes), buffer)
-
-				if err != nil {
-					usbError := err.(*usbError)
-
-					if usbError.UsbErrorCode == usbErrorWait && retries < maximumWaitRetries {
-						var sleepDur time.Duration = 1 << retries
-						retries++
-
-						time.Sleep(sleepDur * 1000000)
-						continue
-					}
-
-					return err
-				}
-
-				bufferPos += headBytes
-				addr += headBytes
-				count -= headBytes
-				bytesRemaining -= headBytes
-
-				logger.Tracef("BufPos: %d, Addr: %08x, Count: %d, BytesRemain: %d", bufferPos, addr, count, bytesRemaining)
-			}
+	for _, chunk := range chunks {
+		for {
+			bufferLen := buffer.Len()
+			err := read(chunk, buffer)
 
-			if (bytesRemaining & (uint32(bitLength) - 1)) > 0 {
-				retErr = h.ReadMem(addr, 1, bytesRemaining, buffer)
-			} else if bitLength == Memory16BitBlock {
-				retErr = h.UsbReadMem16(addr, uint16(bytesRemaining), buffer)
-			} else {
-				retErr = h.UsbReadMem32(addr, uint16(bytesRemaining), buffer)
+			if err == nil {
+				break
 			}
-		} else {
-			retErr = h.UsbReadMem8(addr, uint16(bytesRemaining), buffer)
-		}
 
-		if retErr != nil {
-			usbError := retErr.(*usbError)
+			// drop data of a transfer whose status reported a failure
+			buffer.Truncate(bufferLen)
 
-			if usbError.UsbErrorCode == usbErrorWait && retries < maximumWaitRetries {
-				var sleepDur time.Duration = 1 << retries
+			if errors.Is(err, ErrProbeBusy) && retries < h.config.retryPolicy.MemoryRetries {
+				time.Sleep(h.config.retryPolicy.delay(retries))
 				retries++
-
-				time.Sleep(sleepDur * 1000000)
 				continue
 			}
 
-			return retErr
+			return err
 		}
+	}
+
+	return nil
+}
 
-		bufferPos += bytesRemaining
-		addr += bytesRemaining
-		count -= bytesRemaining
+func (h *StLink) readMemChunk(chunk memChunk, buffer *bytes.Buffer) error {
+	switch chunk.width {
+	case Memory32BitBlock:
+		return h.UsbReadMem32(chunk.addr, uint16(chunk.length), buffer)
+	case Memory16BitBlock:
+		return h.UsbReadMem16(chunk.addr, uint16(chunk.length), buffer)
+	default:
+		return h.UsbReadMem8(chunk.addr, uint16(chunk.length), buffer)
 	}
+}
+
+// Check the write status only once after all chunks of a WriteMem call
+// instead of after every chunk. This roughly doubles the throughput of bulk
+// RAM loads, but due to the missing per-chunk status a failed write is only
+// detected at the end and wait states are not retried, so the written data
+// should be verified afterwards. Not recommended for peripheral registers.
+func (h *StLink) SetDeferredWriteStatus(enable bool) {
+	h, unlock := h.lock()
+	defer unlock()
 
-	return retErr
+	h.deferWriteStatus = enable
 }
 
-func (h *StLink) WriteMem(address uint32, bitLength MemoryBlockSize, count uint32, buffer []byte) error {
-	var retError error
-	var bytesRemaining uint32
-	retries := 0
-	var bufferPos uint32 = 0
+// Write unaligned 32bit block regions entirely in 32bit transfers by extending
+// them to word boundaries. The bytes around the region in the first and last
+// word are read before and written back unchanged, which is not atomic against
+// a running core or DMA. Not suitable for peripheral registers.
+func (h *StLink) SetPaddedWrites(enable bool) {
+	h, unlock := h.lock()
+	defer unlock()
 
-	count *= uint32(bitLength)
+	h.padWrites = enable
+}
 
-	if bitLength == Memory16BitBlock && (!h.version.flags.Get(flagHasMem16Bit)) {
-		logger.Debug("set 16bit memory read to 8bit")
-		bitLength = Memory8BitBlock
+// extend data written at an unaligned address to whole words, preserving the surrounding bytes
+func (h *StLink) padWrite(address uint32, data []byte) ([]byte, error) {
+	start := address &^ 3
+	head := address - start
+
+	buffer := bytes.NewBuffer([]byte{})
+
+	if err := h.ReadMem(start, Memory32BitBlock, 1, buffer); err != nil {
+		return nil, err
 	}
 
-	for count > 0 {
-		if bitLength != Memory8BitBlock {
-			bytesRemaining = h.maxBlockSize(h.maxMemPacket, address)
-		} else {
-			bytesRemaining = h.usbBlock()
-		}
+	if err := h.ReadMem(start+uint32(len(data)), Memory32BitBlock, 1, buffer); err != nil {
+		return nil, err
+	}
 
-		if count < bytesRemaining {
-			bytesRemaining = count
-		}
+	edges := buffer.Bytes()
 
-		//	all stlink support 8/32bit memory read/writes and only from
-		//	stlink V2J26 there is support for 16 bit memory read/write.
-		//  Honour 32 bit and, if possible, 16 bit too. Otherwise, handle
-		//  as 8bit access.
+	if len(edges) < 8 {
+		return nil, fmt.Errorf("short read of %d bytes", len(edges))
+	}
 
-		if bitLength != Memory8BitBlock {
+	padded := make([]byte, len(data)+4)
 
-			/* When in jtag mode the stlink uses the auto-increment functionality.
-			 * However it expects us to pass the data correctly, this includes
-			 * alignment and any page boundaries. We already do this as part of the
-			 * adi_v5 implementation, but the stlink is a hla adapter and so this
-			 * needs implementing manually.
-			 * currently this only affects jtag mode, according to ST they do single
-			 * access in SWD mode - but this may change and so we do it for both modes
-			 */
+	copy(padded, edges[:4])
+	copy(padded[len(data):], edges[4:8])
+	copy(padded[head:], data)
 
-			// we first need to check for any unaligned bytes
-			if (address & (uint32(bitLength) - 1)) > 0 {
-				var headBytes = uint32(bitLength) - (address & (uint32(bitLength) - 1))
+	return padded, nil
+}
 
-				err := h.UsbWriteMem8(address, uint16(headBytes), buffer)
+// Write count blocks of bitLength bytes to target memory. The core does not
+// need to be halted, see WriteLive for writing ram of a running target.
+func (h *StLink) WriteMem(address uint32, bitLength MemoryBlockSize, count uint32, buffer []byte) error {
+	h, unlock := h.lock()
+	defer unlock()
 
-				if err != nil {
-					usbError := err.(*usbError)
+	if !h.batchWriteStatus {
+		if err := h.checkFlashWrite(address, count*uint32(bitLength)); err != nil {
+			return err
+		}
+	}
 
-					if usbError.UsbErrorCode == usbErrorWait && retries < maximumWaitRetries {
-						var sleepDur time.Duration = 1 << retries
-						retries++
+	if h.padWrites && bitLength == Memory32BitBlock && (address%4) != 0 && count > 0 {
+		padded, err := h.padWrite(address, buffer[:count*4])
 
-						time.Sleep(sleepDur * 1000000)
-						continue
-					}
+		if err != nil {
+			return err
+		}
 
-					return err
-				}
+		address &^= 3
+		count++
+		buffer = padded
+	}
 
-				bufferPos += headBytes
-				address += headBytes
-				count -= headBytes
-				bytesRemaining -= headBytes
+	if !h.deferWriteStatus || h.batchWriteStatus {
+		return h.writeMem(address, bitLength, count, buffer)
+	}
 
-				logger.Tracef("BufPos: %d, Addr: %08x, Count: %d, BytesRemain: %d", bufferPos, address, count, bytesRemaining)
-			}
+	h.batchWriteStatus = true
+	err := h.writeMem(address, bitLength, count, buffer)
+	h.batchWriteStatus = false
 
-			if (bytesRemaining & (uint32(bitLength) - 1)) > 0 {
-				retError = h.WriteMem(address, 1, bytesRemaining, buffer[bufferPos:])
-			} else if bitLength == Memory16BitBlock {
-				retError = h.UsbWriteMem16(address, uint16(bytesRemaining), buffer[bufferPos:])
-			} else {
-				retError = h.UsbWriteMem32(address, uint16(bytesRemaining), buffer[bufferPos:])
-			}
-		} else {
-			retError = h.UsbWriteMem8(address, uint16(bytesRemaining), buffer)
-		}
+	if err != nil {
+		return err
+	}
 
-		if retError != nil {
-			switch retError.(type) {
-			case gousb.TransferStatus:
-				logger.Error("got usb transfer error state ", retError)
+	return h.usbGetReadWriteStatus()
+}
 
-				var sleepDur time.Duration = 1 << retries
-				retries++
+func (h *StLink) writeMem(address uint32, bitLength MemoryBlockSize, count uint32, buffer []byte) error {
+	retries := 0
+	length := count * uint32(bitLength)
 
-				time.Sleep(sleepDur * 1000000)
-				continue
+	if bitLength == Memory16BitBlock && (!h.version.flags.Get(flagHasMem16Bit)) {
+		h.log().Debug("set 16bit memory read to 8bit")
+		bitLength = Memory8BitBlock
+	}
 
-			case *usbError:
-				usbError := retError.(*usbError)
+	//	all stlink support 8/32bit memory read/writes and only from
+	//	stlink V2J26 there is support for 16 bit memory read/write.
+	//  Honour 32 bit and, if possible, 16 bit too. Otherwise, handle
+	//  as 8bit access.
+	chunks := splitMemAccess(address, length, bitLength, h.maxMemPacket, h.usbBlock())
 
-				if usbError.UsbErrorCode == usbErrorWait && retries < maximumWaitRetries {
-					var sleepDur time.Duration = 1 << retries
-					retries++
+	for _, chunk := range chunks {
+		for {
+			err := h.writeMemChunk(chunk, buffer[chunk.offset:chunk.offset+chunk.length])
 
-					time.Sleep(sleepDur * 1000000)
-					continue
-				}
+			if err == nil {
+				break
 			}
 
-			return retError
+			if _, ok := err.(gousb.TransferStatus); ok {
+				h.log().Error("got usb transfer error state ", err)
+			} else if !errors.Is(err, ErrProbeBusy) {
+				return err
+			}
+
+			if retries >= h.config.retryPolicy.MemoryRetries {
+				return err
+			}
+
+			time.Sleep(h.config.retryPolicy.delay(retries))
+			retries++
 		}
+	}
 
-		bufferPos += bytesRemaining
-		address += bytesRemaining
-		count -= bytesRemaining
+	return nil
+}
+
+func (h *StLink) writeMemChunk(chunk memChunk, data []byte) error {
+	switch chunk.width {
+	case Memory32BitBlock:
+		return h.UsbWriteMem32(chunk.addr, uint16(chunk.length), data)
+	case Memory16BitBlock:
+		return h.UsbWriteMem16(chunk.addr, uint16(chunk.length), data)
+	default:
+		return h.UsbWriteMem8(chunk.addr, uint16(chunk.length), data)
 	}
+}
+
+// Number of trace buffer overflows detected by PollTrace since trace was enabled
+func (h *StLink) TraceOverflows() uint32 {
+	h, unlock := h.lock()
+	defer unlock()
 
-	return retError
+	return h.trace.overflows
 }
 
 func (h *StLink) PollTrace(buffer []byte, size *uint32) error {
+	h, unlock := h.lock()
+	defer unlock()
+
+	_, err := h.PollTraceWithOverflow(buffer, size)
+
+	return err
+}
+
+// Poll trace data like PollTrace and report whether the trace buffer of the
+// st-link overflowed. The st-link does not report dropped bytes, an overflow
+// is assumed when its buffer was completely filled since the last poll. The
+// amount of lost data is unknown, so trace decoders should resync then.
+func (h *StLink) PollTraceWithOverflow(buffer []byte, size *uint32) (bool, error) {
+	h, unlock := h.lock()
+	defer unlock()
+
+	overflow := false
 
 	if h.trace.enabled == true && h.version.flags.Get(flagHasTrace) {
 		ctx := h.initTransfer(transferIncoming)
@@ -648,11 +1231,18 @@ func (h *StLink) PollTrace(buffer []byte, size *uint32) error {
 		err := h.usbTransferNoErrCheck(ctx, 2)
 
 		if err != nil {
-			return err
+			return false, err
 		}
 
 		bytesAvailable := uint32(ctx.dataBuf.ReadUint16LE())
 
+		if bytesAvailable >= traceSize {
+			overflow = true
+			h.trace.overflows++
+
+			h.log().Warn("trace buffer overflow, trace data was lost")
+		}
+
 		if bytesAvailable < *size {
 			*size = bytesAvailable
 		} else {
@@ -660,16 +1250,29 @@ func (h *StLink) PollTrace(buffer []byte, size *uint32) error {
 		}
 
 		if *size > 0 {
-			return h.usbReadTrace(buffer, *size)
+			return overflow, h.usbReadTrace(buffer, *size)
 		}
 	}
 
 	*size = 0
-	return nil
+	return overflow, nil
+}
+
+// Read trace data directly from the trace endpoint without querying the amount
+// of available bytes first. Returns whatever arrived within timeout, which keeps
+// up with high SWO rates better than PollTrace. Use a buffer of at least 4096 bytes.
+func (h *StLink) ReadTraceDirect(buffer []byte, timeout time.Duration) (int, error) {
+	if !h.trace.enabled || !h.version.flags.Get(flagHasTrace) {
+		return 0, errors.New("trace is not enabled")
+	}
+
+	return usbRawReadPartial(h.log(), h.traceEndpoint, buffer, timeout)
 }
 
 // Force the target go to debug mode
 func (h *StLink) ForceDebug() error {
+  h, unlock := h.lock()
+  defer unlock()
 
   ctx := h.initTransfer(transferOutgoing)
   ctx.cmdBuf.WriteByte(cmdDebug)
@@ -684,5 +1287,8 @@ func (h *StLink) ForceDebug() error {
 }
 
 func (h *StLink) Reset() {
+	h, unlock := h.lock()
+	defer unlock()
+
 	h.libUsbDevice.Reset()
 }
@@ -0,0 +1,364 @@
+// Copyright 2021 juju2013@github. All rights reserved.
+// Use of this source code is governed by a GNU-style
+// license that can be found in the LICENSE file.
+
+package gostlink
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+)
+
+// location of the DBGMCU_IDCODE register depending on the core
+const (
+	dbgmcuIdCodeCortexM  = 0xE0042000 // Cortex-M3/M4/M7/M33 based parts
+	dbgmcuIdCodeCortexM0 = 0x40015800 // Cortex-M0/M0+ based parts
+	dbgmcuIdCodeH7       = 0x5C001000 // Cortex-M7 based H7 series
+
+	cpuIdPartNoCortexM0  = 0xC20
+	cpuIdPartNoCortexM0p = 0xC60
+	cpuIdPartNoCortexM7  = 0xC27
+
+	// main flash (and data eeprom of L0/L1 parts) is mapped into this range
+	stm32FlashStart = 0x08000000
+	stm32FlashEnd   = 0x10000000
+
+	flashBlankCheckChunk = 1024 // bytes read per transfer, bounds the overread past a programmed byte
+)
+
+// Returned by WriteMem when writing into flash while the flash controller is locked
+var ErrFlashLocked = errors.New("flash is locked, unlock the flash controller or use a flash programming routine to write flash")
+
+type stm32Family int
+
+const (
+	stm32FamilyUnknown stm32Family = iota
+	stm32FamilyF0
+	stm32FamilyF1
+	stm32FamilyF2
+	stm32FamilyF3
+	stm32FamilyF4
+	stm32FamilyF7
+	stm32FamilyG0
+	stm32FamilyG4
+	stm32FamilyH7
+	stm32FamilyL0
+	stm32FamilyL1
+	stm32FamilyL4
+)
+
+// family specific debug configuration
+type stm32FamilyInfo struct {
+	name string
+
+	watchdogFreezeReg  uint32 // register holding the watchdog freeze bits
+	watchdogFreezeBits uint32 // bits stopping IWDG and WWDG while the core is halted
+
+	flashLockReg uint32 // flash control register holding the lock bit
+	flashLockBit uint32 // set while the flash controller is locked
+
+	flashErasedZero bool // erased flash reads as 0x00 instead of 0xFF
+}
+
+var stm32Families = map[stm32Family]stm32FamilyInfo{
+	stm32FamilyF0: {name: "STM32F0", watchdogFreezeReg: 0x40015808, watchdogFreezeBits: 1<<11 | 1<<12,
+		flashLockReg: 0x40022010, flashLockBit: 1 << 7},
+	stm32FamilyF1: {name: "STM32F1", watchdogFreezeReg: 0xE0042004, watchdogFreezeBits: 1<<8 | 1<<9,
+		flashLockReg: 0x40022010, flashLockBit: 1 << 7},
+	stm32FamilyF2: {name: "STM32F2", watchdogFreezeReg: 0xE0042008, watchdogFreezeBits: 1<<11 | 1<<12,
+		flashLockReg: 0x40023C10, flashLockBit: 1 << 31},
+	stm32FamilyF3: {name: "STM32F3", watchdogFreezeReg: 0xE0042008, watchdogFreezeBits: 1<<11 | 1<<12,
+		flashLockReg: 0x40022010, flashLockBit: 1 << 7},
+	stm32FamilyF4: {name: "STM32F4", watchdogFreezeReg: 0xE0042008, watchdogFreezeBits: 1<<11 | 1<<12,
+		flashLockReg: 0x40023C10, flashLockBit: 1 << 31},
+	stm32FamilyF7: {name: "STM32F7", watchdogFreezeReg: 0xE0042008, watchdogFreezeBits: 1<<11 | 1<<12,
+		flashLockReg: 0x40023C10, flashLockBit: 1 << 31},
+	stm32FamilyG0: {name: "STM32G0", watchdogFreezeReg: 0x40015808, watchdogFreezeBits: 1<<11 | 1<<12,
+		flashLockReg: 0x40022014, flashLockBit: 1 << 31},
+	stm32FamilyG4: {name: "STM32G4", watchdogFreezeReg: 0xE0042008, watchdogFreezeBits: 1<<11 | 1<<12,
+		flashLockReg: 0x40022014, flashLockBit: 1 << 31},
+	stm32FamilyH7: {name: "STM32H7",
+		flashLockReg: 0x5200200C, flashLockBit: 1 << 0},
+	stm32FamilyL0: {name: "STM32L0", watchdogFreezeReg: 0x40015808, watchdogFreezeBits: 1<<11 | 1<<12,
+		flashLockReg: 0x40022004, flashLockBit: 1 << 0, flashErasedZero: true},
+	stm32FamilyL1: {name: "STM32L1", watchdogFreezeReg: 0xE0042008, watchdogFreezeBits: 1<<11 | 1<<12,
+		flashLockReg: 0x40023C04, flashLockBit: 1 << 0, flashErasedZero: true},
+	stm32FamilyL4: {name: "STM32L4", watchdogFreezeReg: 0xE0042008, watchdogFreezeBits: 1<<11 | 1<<12,
+		flashLockReg: 0x40022014, flashLockBit: 1 << 31},
+}
+
+type stm32DeviceEntry struct {
+	name   string
+	family stm32Family
+	ram    []MemoryRange // sram banks, for lines with several sizes those of the smallest part
+}
+
+const kB = 1024
+
+// known device ids (DEV_ID field of DBGMCU_IDCODE)
+var stm32Devices = map[uint16]stm32DeviceEntry{
+	0x440: {"STM32F030x8/F05x", stm32FamilyF0, []MemoryRange{{0x20000000, 8 * kB}}},
+	0x442: {"STM32F030xC/F09x", stm32FamilyF0, []MemoryRange{{0x20000000, 32 * kB}}},
+	0x444: {"STM32F03x", stm32FamilyF0, []MemoryRange{{0x20000000, 4 * kB}}},
+	0x445: {"STM32F04x", stm32FamilyF0, []MemoryRange{{0x20000000, 6 * kB}}},
+	0x448: {"STM32F07x", stm32FamilyF0, []MemoryRange{{0x20000000, 16 * kB}}},
+	0x410: {"STM32F1 medium density", stm32FamilyF1, []MemoryRange{{0x20000000, 10 * kB}}},
+	0x412: {"STM32F1 low density", stm32FamilyF1, []MemoryRange{{0x20000000, 4 * kB}}},
+	0x414: {"STM32F1 high density", stm32FamilyF1, []MemoryRange{{0x20000000, 32 * kB}}},
+	0x418: {"STM32F1 connectivity line", stm32FamilyF1, []MemoryRange{{0x20000000, 64 * kB}}},
+	0x420: {"STM32F1 value line", stm32FamilyF1, []MemoryRange{{0x20000000, 4 * kB}}},
+	0x428: {"STM32F1 high density value line", stm32FamilyF1, []MemoryRange{{0x20000000, 24 * kB}}},
+	0x430: {"STM32F1 XL density", stm32FamilyF1, []MemoryRange{{0x20000000, 80 * kB}}},
+	0x411: {"STM32F2", stm32FamilyF2, []MemoryRange{{0x20000000, 64 * kB}}},
+	0x422: {"STM32F30x/F31x", stm32FamilyF3, []MemoryRange{{0x20000000, 32 * kB}}},
+	0x432: {"STM32F37x", stm32FamilyF3, []MemoryRange{{0x20000000, 16 * kB}}},
+	0x438: {"STM32F334", stm32FamilyF3, []MemoryRange{{0x20000000, 12 * kB}, {0x10000000, 4 * kB}}},
+	0x439: {"STM32F301/F302x6-8", stm32FamilyF3, []MemoryRange{{0x20000000, 16 * kB}}},
+	0x446: {"STM32F302xD-E/F303xD-E", stm32FamilyF3, []MemoryRange{{0x20000000, 64 * kB}}},
+	0x413: {"STM32F405/407/415/417", stm32FamilyF4, []MemoryRange{{0x20000000, 128 * kB}, {0x10000000, 64 * kB}}},
+	0x419: {"STM32F42x/43x", stm32FamilyF4, []MemoryRange{{0x20000000, 192 * kB}, {0x10000000, 64 * kB}}},
+	0x421: {"STM32F446", stm32FamilyF4, []MemoryRange{{0x20000000, 128 * kB}}},
+	0x423: {"STM32F401xB/C", stm32FamilyF4, []MemoryRange{{0x20000000, 64 * kB}}},
+	0x431: {"STM32F411", stm32FamilyF4, []MemoryRange{{0x20000000, 128 * kB}}},
+	0x433: {"STM32F401xD/E", stm32FamilyF4, []MemoryRange{{0x20000000, 96 * kB}}},
+	0x434: {"STM32F469/479", stm32FamilyF4, []MemoryRange{{0x20000000, 320 * kB}, {0x10000000, 64 * kB}}},
+	0x441: {"STM32F412", stm32FamilyF4, []MemoryRange{{0x20000000, 256 * kB}}},
+	0x458: {"STM32F410", stm32FamilyF4, []MemoryRange{{0x20000000, 32 * kB}}},
+	0x463: {"STM32F413/423", stm32FamilyF4, []MemoryRange{{0x20000000, 320 * kB}}},
+	0x449: {"STM32F74x/75x", stm32FamilyF7, []MemoryRange{{0x20000000, 320 * kB}}},
+	0x451: {"STM32F76x/77x", stm32FamilyF7, []MemoryRange{{0x20000000, 512 * kB}}},
+	0x452: {"STM32F72x/73x", stm32FamilyF7, []MemoryRange{{0x20000000, 256 * kB}}},
+	0x460: {"STM32G07x/08x", stm32FamilyG0, []MemoryRange{{0x20000000, 36 * kB}}},
+	0x466: {"STM32G03x/04x", stm32FamilyG0, []MemoryRange{{0x20000000, 8 * kB}}},
+	0x467: {"STM32G0Bx/0Cx", stm32FamilyG0, []MemoryRange{{0x20000000, 144 * kB}}},
+	0x468: {"STM32G431/441", stm32FamilyG4, []MemoryRange{{0x20000000, 32 * kB}}},
+	0x469: {"STM32G47x/48x", stm32FamilyG4, []MemoryRange{{0x20000000, 128 * kB}}},
+	0x479: {"STM32G491/4A1", stm32FamilyG4, []MemoryRange{{0x20000000, 112 * kB}}},
+	0x450: {"STM32H74x/75x", stm32FamilyH7, []MemoryRange{{0x20000000, 128 * kB}, {0x24000000, 512 * kB}, {0x30000000, 288 * kB}, {0x38000000, 64 * kB}}},
+	0x480: {"STM32H7Ax/7Bx", stm32FamilyH7, []MemoryRange{{0x20000000, 128 * kB}, {0x24000000, 1024 * kB}, {0x30000000, 128 * kB}, {0x38000000, 32 * kB}}},
+	0x483: {"STM32H72x/73x", stm32FamilyH7, []MemoryRange{{0x20000000, 128 * kB}, {0x24000000, 320 * kB}, {0x30000000, 32 * kB}, {0x38000000, 16 * kB}}},
+	0x417: {"STM32L0 category 3", stm32FamilyL0, []MemoryRange{{0x20000000, 8 * kB}}},
+	0x425: {"STM32L0 category 2", stm32FamilyL0, []MemoryRange{{0x20000000, 8 * kB}}},
+	0x447: {"STM32L0 category 5", stm32FamilyL0, []MemoryRange{{0x20000000, 20 * kB}}},
+	0x457: {"STM32L0 category 1", stm32FamilyL0, []MemoryRange{{0x20000000, 2 * kB}}},
+	0x416: {"STM32L1 category 1", stm32FamilyL1, []MemoryRange{{0x20000000, 10 * kB}}},
+	0x427: {"STM32L1 category 3", stm32FamilyL1, []MemoryRange{{0x20000000, 32 * kB}}},
+	0x429: {"STM32L1 category 2", stm32FamilyL1, []MemoryRange{{0x20000000, 16 * kB}}},
+	0x436: {"STM32L1 category 4", stm32FamilyL1, []MemoryRange{{0x20000000, 48 * kB}}},
+	0x437: {"STM32L1 category 5", stm32FamilyL1, []MemoryRange{{0x20000000, 80 * kB}}},
+	0x415: {"STM32L47x/48x", stm32FamilyL4, []MemoryRange{{0x20000000, 96 * kB}, {0x10000000, 32 * kB}}},
+	0x435: {"STM32L43x/44x", stm32FamilyL4, []MemoryRange{{0x20000000, 48 * kB}, {0x10000000, 16 * kB}}},
+	0x461: {"STM32L49x/4Ax", stm32FamilyL4, []MemoryRange{{0x20000000, 256 * kB}, {0x10000000, 64 * kB}}},
+	0x462: {"STM32L45x/46x", stm32FamilyL4, []MemoryRange{{0x20000000, 128 * kB}, {0x10000000, 32 * kB}}},
+	0x464: {"STM32L41x/42x", stm32FamilyL4, []MemoryRange{{0x20000000, 32 * kB}, {0x10000000, 8 * kB}}},
+	0x470: {"STM32L4Rx/4Sx", stm32FamilyL4, []MemoryRange{{0x20000000, 640 * kB}, {0x10000000, 64 * kB}}},
+}
+
+// Identification of the connected STM32 device
+type StmDeviceInfo struct {
+	IdCode uint32 // raw DBGMCU_IDCODE
+	DevId  uint16
+	RevId  uint16
+	Name   string
+	Family string
+
+	family stm32Family
+}
+
+// Identify the connected STM32 device by its DBGMCU_IDCODE.
+// The result is cached for the lifetime of the handle.
+func (h *StLink) IdentifyDevice() (*StmDeviceInfo, error) {
+	h, unlock := h.lock()
+	defer unlock()
+
+	if h.device != nil {
+		return h.device, nil
+	}
+
+	cpuId, err := h.readDebugReg(cpuIdBaseRegister)
+
+	if err != nil {
+		return nil, err
+	}
+
+	var idCodeAddr uint32 = dbgmcuIdCodeCortexM
+
+	switch (cpuId >> 4) & 0xfff {
+	case cpuIdPartNoCortexM0, cpuIdPartNoCortexM0p:
+		idCodeAddr = dbgmcuIdCodeCortexM0
+	case cpuIdPartNoCortexM7:
+		// H7 parts moved the DBGMCU, F7 parts did not
+		if idCode, err := h.readDebugReg(dbgmcuIdCodeH7); err == nil && idCode != 0 {
+			idCodeAddr = dbgmcuIdCodeH7
+		}
+	}
+
+	idCode, err := h.readDebugReg(idCodeAddr)
+
+	if err != nil {
+		return nil, err
+	}
+
+	info := &StmDeviceInfo{
+		IdCode: idCode,
+		DevId:  uint16(idCode & 0xfff),
+		RevId:  uint16(idCode >> 16),
+	}
+
+	entry, ok := stm32Devices[info.DevId]
+
+	if !ok {
+		return nil, fmt.Errorf("unknown device id 0x%03x", info.DevId)
+	}
+
+	info.Name = entry.name
+	info.family = entry.family
+	info.Family = stm32Families[entry.family].name
+
+	h.log().Debugf("identified device %s (id 0x%03x, rev 0x%04x)", info.Name, info.DevId, info.RevId)
+
+	h.device = info
+	return info, nil
+}
+
+// Stop the independent and window watchdog while the core is halted,
+// so a halted target is not reset by its watchdog
+func (h *StLink) FreezeWatchdogs() error {
+	h, unlock := h.lock()
+	defer unlock()
+
+	device, err := h.IdentifyDevice()
+
+	if err != nil {
+		return err
+	}
+
+	family := stm32Families[device.family]
+
+	if family.watchdogFreezeReg == 0 {
+		return fmt.Errorf("watchdog freeze not supported for %s: %w", family.name, ErrNotSupported)
+	}
+
+	value, err := h.readDebugReg(family.watchdogFreezeReg)
+
+	if err != nil {
+		return err
+	}
+
+	return h.writeDebugReg(family.watchdogFreezeReg, value|family.watchdogFreezeBits)
+}
+
+// refuse plain memory writes into locked flash, the flash controller ignores them silently
+func (h *StLink) checkFlashWrite(addr uint32, size uint32) error {
+	if h.device == nil || size == 0 {
+		return nil
+	}
+
+	if uint64(addr)+uint64(size) <= stm32FlashStart || addr >= stm32FlashEnd {
+		return nil
+	}
+
+	family := stm32Families[h.device.family]
+
+	if family.flashLockReg == 0 {
+		return nil
+	}
+
+	cr, err := h.readDebugReg(family.flashLockReg)
+
+	if err != nil {
+		return err
+	}
+
+	if (cr & family.flashLockBit) > 0 {
+		return fmt.Errorf("write to 0x%08x: %w", addr, ErrFlashLocked)
+	}
+
+	return nil
+}
+
+// Check whether length bytes of flash at addr are erased. The region is read in
+// word accesses and the check stops at the first programmed byte, whose address
+// is returned as firstNonBlank. Flash of STM32L0/L1 parts erases to 0x00, if the
+// device is not identified the erased value 0xFF is assumed.
+func (h *StLink) FlashBlankCheck(addr uint32, length uint32) (blank bool, firstNonBlank uint32, err error) {
+	h, unlock := h.lock()
+	defer unlock()
+
+	var erased byte = 0xff
+
+	if h.device != nil && stm32Families[h.device.family].flashErasedZero {
+		erased = 0x00
+	}
+
+	regionEnd := uint64(addr) + uint64(length)
+	end := (regionEnd + 3) &^ 3
+
+	for pos := uint64(addr &^ 3); pos < end; pos += flashBlankCheckChunk {
+		size := end - pos
+
+		if size > flashBlankCheckChunk {
+			size = flashBlankCheckChunk
+		}
+
+		buffer := bytes.NewBuffer([]byte{})
+
+		if err = h.ReadMem(uint32(pos), Memory32BitBlock, uint32(size/4), buffer); err != nil {
+			return false, 0, err
+		}
+
+		data := buffer.Bytes()
+
+		if uint64(len(data)) < size {
+			return false, 0, fmt.Errorf("short read of %d bytes", len(data))
+		}
+
+		for i, b := range data[:size] {
+			byteAddr := pos + uint64(i)
+
+			if b != erased && byteAddr >= uint64(addr) && byteAddr < regionEnd {
+				return false, uint32(byteAddr), nil
+			}
+		}
+	}
+
+	return true, 0, nil
+}
+
+// Sram banks of the connected device. Parts of a device line may have more
+// ram than reported, the banks of its smallest part are returned.
+func (h *StLink) ReadRAMBanks() ([]MemoryRange, error) {
+	h, unlock := h.lock()
+	defer unlock()
+
+	device, err := h.IdentifyDevice()
+
+	if err != nil {
+		return nil, err
+	}
+
+	banks := stm32Devices[device.DevId].ram
+
+	return append([]MemoryRange(nil), banks...), nil
+}
+
+// Total sram size in bytes of the connected device, see ReadRAMBanks
+func (h *StLink) ReadRAMSize() (uint32, error) {
+	h, unlock := h.lock()
+	defer unlock()
+
+	banks, err := h.ReadRAMBanks()
+
+	if err != nil {
+		return 0, err
+	}
+
+	var size uint32
+
+	for _, bank := range banks {
+		size += bank.Size
+	}
+
+	return size, nil
+}
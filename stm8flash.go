@@ -0,0 +1,166 @@
+// Copyright 2021 juju2013@github. All rights reserved.
+// Use of this source code is governed by a GNU-style
+// license that can be found in the LICENSE file.
+
+package gostlink
+
+import (
+	"fmt"
+	"time"
+)
+
+// STM8 line, selects the flash controller registers used by STM8FlashBlock
+type Stm8Family int
+
+const (
+	Stm8FamilyS Stm8Family = iota // STM8S and STM8AF
+	Stm8FamilyL                   // STM8L and STM8AL
+)
+
+// flash controller registers of an STM8 line
+type stm8FlashRegs struct {
+	cr2     uint32
+	hasNcr2 bool // NCR2 follows CR2 and has to be written with its complement
+	iapsr   uint32
+	pukr    uint32 // program memory unprotection key
+	dukr    uint32 // data eeprom unprotection key
+}
+
+var stm8FlashRegisters = map[Stm8Family]stm8FlashRegs{
+	Stm8FamilyS: {cr2: 0x505B, hasNcr2: true, iapsr: 0x505F, pukr: 0x5062, dukr: 0x5064},
+	Stm8FamilyL: {cr2: 0x5051, iapsr: 0x5054, pukr: 0x5052, dukr: 0x5053},
+}
+
+const (
+	stm8ProgramStart = 0x8000 // program memory, the data eeprom is below
+
+	stm8Cr2Prg       = 0x01 // standard block programming, erase then program
+	stm8IapsrWrPgDis = 0x01 // write to a protected page was attempted
+	stm8IapsrPul     = 0x02 // program memory unlocked
+	stm8IapsrEop     = 0x04 // end of programming
+	stm8IapsrDul     = 0x08 // data eeprom unlocked
+
+	stm8BlockTimeout      = 100 * time.Millisecond
+	stm8BlockPollInterval = time.Millisecond
+)
+
+var (
+	stm8ProgramKeys = []byte{0x56, 0xAE}
+	stm8DataKeys    = []byte{0xAE, 0x56}
+)
+
+// Select the STM8 line of the target, STM8FlashBlock uses the flash controller
+// registers of the STM8S line unless set otherwise
+func (h *StLink) SetStm8Family(family Stm8Family) {
+	h, unlock := h.lock()
+	defer unlock()
+
+	h.stm8Family = family
+}
+
+// Program one block of STM8 program memory or data eeprom at addr over SWIM
+// with the standard block programming, the block is erased and programmed in
+// one go. data has the block size of the part (64 or 128 bytes) and addr is
+// aligned to it. The memory is unlocked with its key sequence before and locked
+// again afterwards, the block is verified by reading it back. Fails with
+// ErrNotSupported unless the handle was opened in swim mode.
+func (h *StLink) STM8FlashBlock(addr uint32, data []byte) error {
+	h, unlock := h.lock()
+	defer unlock()
+
+	if err := h.requireSwim(); err != nil {
+		return err
+	}
+
+	if len(data) != 64 && len(data) != 128 {
+		return fmt.Errorf("stm8 flash block of %d bytes, blocks are 64 or 128 bytes", len(data))
+	}
+
+	if addr%uint32(len(data)) != 0 {
+		return fmt.Errorf("stm8 flash block at 0x%06x: %w", addr, ErrUnalignedAccess)
+	}
+
+	regs, ok := stm8FlashRegisters[h.stm8Family]
+
+	if !ok {
+		return fmt.Errorf("unknown stm8 family %d: %w", h.stm8Family, ErrNotSupported)
+	}
+
+	keyReg, keys, unlocked := regs.dukr, stm8DataKeys, byte(stm8IapsrDul)
+
+	if addr >= stm8ProgramStart {
+		keyReg, keys, unlocked = regs.pukr, stm8ProgramKeys, stm8IapsrPul
+	}
+
+	for _, key := range keys {
+		if err := h.usbSwimWriteMem(keyReg, []byte{key}); err != nil {
+			return err
+		}
+	}
+
+	// reading IAPSR also clears an EOP left over from an earlier program
+	iapsr, err := h.usbSwimReadMem(regs.iapsr, 1)
+
+	if err != nil {
+		return err
+	}
+
+	if iapsr[0]&unlocked == 0 {
+		return fmt.Errorf("stm8 memory at 0x%06x stays locked after the key sequence (IAPSR 0x%02x)", addr, iapsr[0])
+	}
+
+	defer func() {
+		if err := h.usbSwimWriteMem(regs.iapsr, []byte{iapsr[0] &^ (stm8IapsrPul | stm8IapsrDul)}); err != nil {
+			h.log().Warn("could not lock stm8 memory again: ", err)
+		}
+	}()
+
+	mode := []byte{stm8Cr2Prg}
+
+	if regs.hasNcr2 {
+		mode = append(mode, ^byte(stm8Cr2Prg))
+	}
+
+	if err = h.usbSwimWriteMem(regs.cr2, mode); err != nil {
+		return err
+	}
+
+	// programming starts once the last byte of the block was written
+	if err = h.usbSwimWriteMem(addr, data); err != nil {
+		return err
+	}
+
+	err = waitForValue(func() (uint32, error) {
+		status, err := h.usbSwimReadMem(regs.iapsr, 1)
+
+		if err != nil {
+			return 0, err
+		}
+
+		if status[0]&stm8IapsrWrPgDis > 0 {
+			return 0, fmt.Errorf("stm8 flash block at 0x%06x is write protected", addr)
+		}
+
+		return uint32(status[0]), nil
+	}, stm8IapsrEop, stm8IapsrEop, stm8BlockTimeout, stm8BlockPollInterval, func(status uint32) string {
+		return fmt.Sprintf("stm8 block program at 0x%06x not finished (IAPSR 0x%02x)", addr, status)
+	})
+
+	if err != nil {
+		return err
+	}
+
+	written, err := h.usbSwimReadMem(addr, uint32(len(data)))
+
+	if err != nil {
+		return err
+	}
+
+	for i := range data {
+		if written[i] != data[i] {
+			return &VerifyError{Addr: addr + uint32(i), Expected: data[i], Actual: written[i]}
+		}
+	}
+
+	return nil
+}
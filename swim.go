@@ -0,0 +1,210 @@
+// Copyright 2021 juju2013@github. All rights reserved.
+// Use of this source code is governed by a GNU-style
+// license that can be found in the LICENSE file.
+
+package gostlink
+
+import (
+	"fmt"
+)
+
+// STM8 debug registers accessed over SWIM
+const (
+	swimCsr         = 0x7F80 // SWIM control status register
+	swimCsrSafeMask = 0x80   // reset of the target by SWIM is masked
+	swimCsrDm       = 0x20   // SWIM has access to the whole memory
+	stm8DmCsr2      = 0x7F99
+	stm8DmCsr2Stall = 0x08 // core is stalled
+
+	swimStatusSize = 4
+	swimInlineData = cmdSizeV2 - 8 // data bytes sent within the WRITEMEM command itself
+)
+
+// fail unless the handle was opened in swim mode
+func (h *StLink) requireSwim() error {
+	if h.stMode != StLinkModeDebugSwim {
+		return fmt.Errorf("operation needs the swim mode, st-link is in mode %d: %w", h.stMode, ErrNotSupported)
+	}
+
+	return nil
+}
+
+// Read length bytes of STM8 memory at addr over SWIM
+func (h *StLink) SwimReadMem(addr uint32, length uint32) ([]byte, error) {
+	h, unlock := h.lock()
+	defer unlock()
+
+	if err := h.requireSwim(); err != nil {
+		return nil, err
+	}
+
+	data := make([]byte, 0, length)
+
+	for length > 0 {
+		chunk := length
+
+		if chunk > h.maxDataStage() {
+			chunk = h.maxDataStage()
+		}
+
+		read, err := h.usbSwimReadMem(addr, chunk)
+
+		if err != nil {
+			return nil, err
+		}
+
+		data = append(data, read...)
+		addr += chunk
+		length -= chunk
+	}
+
+	return data, nil
+}
+
+// Write data to STM8 memory at addr over SWIM. Flash and data eeprom have to
+// be unlocked and programmed as done by STM8FlashBlock.
+func (h *StLink) SwimWriteMem(addr uint32, data []byte) error {
+	h, unlock := h.lock()
+	defer unlock()
+
+	if err := h.requireSwim(); err != nil {
+		return err
+	}
+
+	for len(data) > 0 {
+		chunk := data
+
+		if uint32(len(chunk)) > h.maxDataStage() {
+			chunk = chunk[:h.maxDataStage()]
+		}
+
+		if err := h.usbSwimWriteMem(addr, chunk); err != nil {
+			return err
+		}
+
+		addr += uint32(len(chunk))
+		data = data[len(chunk):]
+	}
+
+	return nil
+}
+
+// start the swim communication and stall the core, the counterpart of the
+// debug mode enter for STM8 targets
+func (h *StLink) usbSwimEnter() error {
+	if err := h.usbSwimAssertReset(true); err != nil {
+		return err
+	}
+
+	if err := h.usbSwimCommand(swimEnterSeq); err != nil {
+		return fmt.Errorf("swim entry sequence failed: %w", err)
+	}
+
+	if err := h.usbSwimWriteMem(swimCsr, []byte{swimCsrSafeMask | swimCsrDm}); err != nil {
+		return err
+	}
+
+	// releasing the reset loads the option bytes, SWIM keeps access
+	if err := h.usbSwimAssertReset(false); err != nil {
+		return err
+	}
+
+	csr2, err := h.usbSwimReadMem(stm8DmCsr2, 1)
+
+	if err != nil {
+		return err
+	}
+
+	return h.usbSwimWriteMem(stm8DmCsr2, []byte{csr2[0] | stm8DmCsr2Stall})
+}
+
+// query the status of the last swim command, it is held in the first byte
+func (h *StLink) usbSwimStatus() (*transferCtx, error) {
+	ctx := h.initTransfer(transferIncoming)
+
+	ctx.cmdBuf.WriteByte(cmdSwim)
+	ctx.cmdBuf.WriteByte(swimReadStatus)
+
+	// error is checked by the caller
+	if err := h.usbTransferNoErrCheck(ctx, swimStatusSize); err != nil {
+		return nil, err
+	}
+
+	return ctx, nil
+}
+
+// swim command without parameters and data
+func (h *StLink) usbSwimCommand(command byte) error {
+	ctx := h.initTransfer(transferIncoming)
+
+	ctx.cmdBuf.WriteByte(cmdSwim)
+	ctx.cmdBuf.WriteByte(command)
+
+	return h.usbCmdAllowRetry(ctx, 0)
+}
+
+func (h *StLink) usbSwimAssertReset(assert bool) error {
+	if assert {
+		return h.usbSwimCommand(swimAssertReset)
+	}
+
+	return h.usbSwimCommand(swimDeassertReset)
+}
+
+// write at most one data stage, the first bytes are sent with the command
+func (h *StLink) usbSwimWriteMem(addr uint32, data []byte) error {
+	if uint32(len(data)) > h.maxDataStage() {
+		return newUsbError(fmt.Sprintf("max buffer (%d) length exceeded", h.maxDataStage()), usbErrorFail)
+	}
+
+	ctx := h.initTransfer(transferOutgoing)
+
+	ctx.cmdBuf.WriteByte(cmdSwim)
+	ctx.cmdBuf.WriteByte(swimWriteMem)
+	ctx.cmdBuf.WriteUint16BE(uint16(len(data)))
+	ctx.cmdBuf.WriteUint32BE(addr)
+
+	inline := len(data)
+
+	if inline > swimInlineData {
+		inline = swimInlineData
+	}
+
+	ctx.cmdBuf.Write(data[:inline])
+	ctx.dataBuf.Write(data[inline:])
+
+	return h.usbCmdAllowRetry(ctx, uint32(len(data)-inline))
+}
+
+// read at most one data stage, READMEM fills the st-link buffer fetched by READBUF
+func (h *StLink) usbSwimReadMem(addr uint32, length uint32) ([]byte, error) {
+	if length > h.maxDataStage() {
+		return nil, newUsbError(fmt.Sprintf("max buffer (%d) length exceeded", h.maxDataStage()), usbErrorFail)
+	}
+
+	ctx := h.initTransfer(transferIncoming)
+
+	ctx.cmdBuf.WriteByte(cmdSwim)
+	ctx.cmdBuf.WriteByte(swimReadMem)
+	ctx.cmdBuf.WriteUint16BE(uint16(length))
+	ctx.cmdBuf.WriteUint32BE(addr)
+
+	if err := h.usbCmdAllowRetry(ctx, 0); err != nil {
+		return nil, err
+	}
+
+	ctx = h.initTransfer(transferIncoming)
+
+	ctx.cmdBuf.WriteByte(cmdSwim)
+	ctx.cmdBuf.WriteByte(swimReadBuf)
+
+	if err := h.usbTransferNoErrCheck(ctx, length); err != nil {
+		return nil, err
+	}
+
+	if ctx.rxSize < int(length) {
+		return nil, fmt.Errorf("short swim read of %d bytes at 0x%06x", ctx.rxSize, addr)
+	}
+
+	return ctx.DataBytes()[:length], nil
+}
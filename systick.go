@@ -0,0 +1,65 @@
+// Copyright 2021 juju2013@github. All rights reserved.
+// Use of this source code is governed by a GNU-style
+// license that can be found in the LICENSE file.
+
+package gostlink
+
+// Cortex-M SysTick timer registers
+const (
+	sysTickCtrl = 0xE000E010 // followed by LOAD, VAL and CALIB
+
+	sysTickCtrlEnable    = 1 << 0
+	sysTickCtrlTickInt   = 1 << 1
+	sysTickCtrlClkSource = 1 << 2
+	sysTickCtrlCountFlag = 1 << 16
+
+	sysTickCalibNoRef     = 1 << 31
+	sysTickCalibSkew      = 1 << 30
+	sysTickCalibTenMsMask = 0xffffff
+	sysTickCounterMask    = 0xffffff
+)
+
+// SysTick registers and their decoded fields
+type SysTick struct {
+	CTRL  uint32
+	LOAD  uint32
+	VAL   uint32
+	CALIB uint32
+
+	Enabled   bool   // counter is running
+	TickInt   bool   // exception on reaching zero
+	CoreClock bool   // counts the processor clock, otherwise the external reference clock
+	CountFlag bool   // counted to zero since the last read of CTRL
+	Reload    uint32 // reload value, 24 bits
+	Current   uint32 // current counter value, 24 bits
+	NoRef     bool   // no external reference clock implemented
+	Skew      bool   // TenMs is not exactly 10ms
+	TenMs     uint32 // reload value for 10ms, 0 if unknown
+}
+
+// Read the SysTick registers. Reading CTRL may clear COUNTFLAG, as on reads by
+// the firmware.
+func (h *StLink) ReadSysTick() (*SysTick, error) {
+	h, unlock := h.lock()
+	defer unlock()
+
+	words, err := h.readNvicWords(sysTickCtrl, 4)
+
+	if err != nil {
+		return nil, err
+	}
+
+	tick := &SysTick{CTRL: words[0], LOAD: words[1], VAL: words[2], CALIB: words[3]}
+
+	tick.Enabled = (tick.CTRL & sysTickCtrlEnable) > 0
+	tick.TickInt = (tick.CTRL & sysTickCtrlTickInt) > 0
+	tick.CoreClock = (tick.CTRL & sysTickCtrlClkSource) > 0
+	tick.CountFlag = (tick.CTRL & sysTickCtrlCountFlag) > 0
+	tick.Reload = tick.LOAD & sysTickCounterMask
+	tick.Current = tick.VAL & sysTickCounterMask
+	tick.NoRef = (tick.CALIB & sysTickCalibNoRef) > 0
+	tick.Skew = (tick.CALIB & sysTickCalibSkew) > 0
+	tick.TenMs = tick.CALIB & sysTickCalibTenMsMask
+
+	return tick, nil
+}
@@ -0,0 +1,182 @@
+// Copyright 2021 juju2013@github. All rights reserved.
+// Use of this source code is governed by a GNU-style
+// license that can be found in the LICENSE file.
+
+package gostlink
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+const (
+	targetVerifyBlockSize = 4096 // bytes covered by one checksum pair
+	targetVerifyThreshold = 4 * targetVerifyBlockSize
+)
+
+// Thumb routine comparing the checksums of blocks of r3 words starting at r0
+// against the pairs of the table from r2 up to r1. Returns the address of the
+// pair of the first mismatching block, or r1 if all blocks match. Uses only
+// ARMv6-M instructions, r4-r7 are clobbered.
+var verifyRoutine = []byte{
+	0x8a, 0x42, // block:    cmp   r2, r1
+	0x0d, 0xd0, //           beq   done
+	0x00, 0x24, //           movs  r4, #0
+	0x00, 0x25, //           movs  r5, #0
+	0x1e, 0x00, //           movs  r6, r3
+	0x80, 0xc8, // word:     ldmia r0!, {r7}
+	0xe4, 0x19, //           adds  r4, r4, r7
+	0x2d, 0x19, //           adds  r5, r5, r4
+	0x01, 0x3e, //           subs  r6, #1
+	0xfa, 0xd1, //           bne   word
+	0xc0, 0xca, //           ldmia r2!, {r6, r7}
+	0xb4, 0x42, //           cmp   r4, r6
+	0x01, 0xd1, //           bne   mismatch
+	0xbd, 0x42, //           cmp   r5, r7
+	0xf0, 0xd0, //           beq   block
+	0x08, 0x3a, // mismatch: subs  r2, #8
+	0x10, 0x00, // done:     movs  r0, r2
+	0x70, 0x47, //           bx    lr
+}
+
+// checksum pair of a block as computed by verifyRoutine: the sum of the words
+// and the sum of the running sums, so swapped words are detected too. This is
+// a Fletcher style checksum, not a CRC32.
+func verifyBlockChecksum(block []byte, order binary.ByteOrder) (uint32, uint32) {
+	var a, b uint32
+
+	for i := 0; i+4 <= len(block); i += 4 {
+		a += order.Uint32(block[i:])
+		b += a
+	}
+
+	return a, b
+}
+
+// Verify target memory at addr against expected like VerifyMem, but compare
+// checksums of 4kB blocks on the target only, so just a mismatching block is
+// read back. Greatly speeds up verifying large flash images. A small routine
+// and the checksum table are loaded into the scratch ram of scratchSize bytes at
+// scratch, which must not be used by the application, e.g. ram the application
+// does not use or that it initializes at startup anyway. A scratch area too small
+// for the whole table is used for several calls of the routine. The core is
+// halted and its registers are restored afterwards, the routine's return address
+// is placed in the 8 bytes below the stack pointer. addr has to be word aligned.
+// Small regions are verified with VerifyMem. The first difference is returned
+// as *VerifyError.
+func (h *StLink) VerifyMemOnTarget(addr uint32, expected []byte, scratch uint32, scratchSize uint32) error {
+	h, unlock := h.lock()
+	defer unlock()
+
+	if addr%4 != 0 {
+		return fmt.Errorf("verify at 0x%08x is not word aligned: %w", addr, ErrUnalignedAccess)
+	}
+
+	blocks := len(expected) / targetVerifyBlockSize
+
+	if len(expected) < targetVerifyThreshold {
+		return h.VerifyMem(addr, expected)
+	}
+
+	routineAddr := (scratch + 3) &^ 3
+	tableAddr := routineAddr + uint32(len(verifyRoutine))
+	scratchEnd := uint64(scratch) + uint64(scratchSize)
+	end := addr + uint32(blocks*targetVerifyBlockSize)
+
+	if scratchEnd < uint64(tableAddr)+8 {
+		return fmt.Errorf("scratch area of %d bytes too small for the verify routine, %d bytes needed",
+			scratchSize, uint64(tableAddr)+8-uint64(scratch))
+	}
+
+	if uint64(scratch) < uint64(end) && uint64(addr) < scratchEnd {
+		return fmt.Errorf("scratch area at 0x%08x overlaps the verified region", scratch)
+	}
+
+	// checksum pairs fitting into the scratch area per call of the routine
+	perCall := int((scratchEnd - uint64(tableAddr)) / 8)
+
+	if err := h.UsbModeEnter(h.stMode); err != nil {
+		return err
+	}
+	defer h.UsbLeaveMode(h.stMode)
+
+	if err := h.ensureHalted(); err != nil {
+		return err
+	}
+
+	saved, err := h.readRegisters()
+
+	if err != nil {
+		return err
+	}
+
+	var order binary.ByteOrder = binary.LittleEndian
+
+	if h.targetEndian == bigEndian {
+		order = binary.BigEndian
+	}
+
+	if err := h.WriteMem(routineAddr, Memory8BitBlock, uint32(len(verifyRoutine)), verifyRoutine); err != nil {
+		return err
+	}
+
+	for first := 0; first < blocks; first += perCall {
+		n := blocks - first
+
+		if n > perCall {
+			n = perCall
+		}
+
+		block, err := h.verifyBlocksOnTarget(saved, addr+uint32(first*targetVerifyBlockSize),
+			expected[first*targetVerifyBlockSize:(first+n)*targetVerifyBlockSize], routineAddr, tableAddr, order)
+
+		if err != nil {
+			return err
+		}
+
+		if block < n {
+			// only the mismatching block is read back to find the first difference
+			offset := (first + block) * targetVerifyBlockSize
+			return h.VerifyMem(addr+uint32(offset), expected[offset:offset+targetVerifyBlockSize])
+		}
+	}
+
+	// the tail not covering a whole block
+	return h.VerifyMem(end, expected[blocks*targetVerifyBlockSize:])
+}
+
+// compare the whole blocks of expected at addr with the loaded routine, returns
+// the index of the first mismatching block or the number of blocks if all match
+func (h *StLink) verifyBlocksOnTarget(saved *TargetRegisters, addr uint32, expected []byte,
+	routineAddr uint32, tableAddr uint32, order binary.ByteOrder) (int, error) {
+
+	blocks := len(expected) / targetVerifyBlockSize
+	table := make([]byte, blocks*8)
+
+	for i := 0; i < blocks; i++ {
+		a, b := verifyBlockChecksum(expected[i*targetVerifyBlockSize:(i+1)*targetVerifyBlockSize], order)
+		order.PutUint32(table[i*8:], a)
+		order.PutUint32(table[i*8+4:], b)
+	}
+
+	if err := h.writeRegion(tableAddr, table); err != nil {
+		return 0, err
+	}
+
+	result, callErr := h.callFunction(saved, routineAddr|1,
+		[]uint32{addr, tableAddr + uint32(len(table)), tableAddr, targetVerifyBlockSize / 4})
+
+	if err := h.writeRegisters(saved); err != nil {
+		return 0, err
+	}
+
+	if callErr != nil {
+		return 0, callErr
+	}
+
+	if result < tableAddr || result > tableAddr+uint32(len(table)) || (result-tableAddr)%8 != 0 {
+		return 0, fmt.Errorf("verify routine returned invalid result 0x%08x", result)
+	}
+
+	return int(result-tableAddr) / 8, nil
+}
@@ -6,6 +6,7 @@ package gostlink
 
 import (
 	"errors"
+	"fmt"
 )
 
 type TraceConfigType int
@@ -38,7 +39,7 @@ const tpuiAcprMaxSwoScaler = 0x1fff
 func (h *StLink) usbTraceDisable() error {
 
 	if !h.version.flags.Get(flagHasTrace) {
-		return errors.New("st-link does not support trace")
+		return fmt.Errorf("st-link does not support trace: %w", ErrNotSupported)
 	}
 
 	ctx := h.initTransfer(transferIncoming)
@@ -71,28 +72,29 @@ func (h *StLink) usbTraceEnable() error {
 
 		if err == nil {
 			h.trace.enabled = true
-			logger.Debugf("enabled trace recording at %d Hz", h.trace.sourceHz)
+			h.trace.overflows = 0
+			h.log().Debugf("enabled trace recording at %d Hz", h.trace.sourceHz)
 
 			return nil
 		} else {
 			return errors.New("usb xfer error at enabling trace")
 		}
 	} else {
-		return errors.New("tracing not supported by st-link")
+		return fmt.Errorf("tracing not supported by st-link: %w", ErrNotSupported)
 	}
 }
 
 func (h *StLink) usbReadTrace(buffer []byte, size uint32) error {
 	if !h.version.flags.Get(flagHasTrace) {
-		return errors.New("trace is not supported by connected device")
+		return fmt.Errorf("trace is not supported by connected device: %w", ErrNotSupported)
 	}
 
-	bytesRead, err := usbRawRead(h.traceEndpoint, buffer)
+	bytesRead, err := usbRawRead(h.log(), h.traceEndpoint, buffer)
 
 	if err != nil {
 		return err
 	} else {
-		logger.Debugf("Read [%d from %d] bytes from trace channel", bytesRead, size)
+		h.log().Debugf("Read [%d from %d] bytes from trace channel", bytesRead, size)
 		return nil
 	}
 }
@@ -0,0 +1,184 @@
+// Copyright 2021 juju2013@github. All rights reserved.
+// Use of this source code is governed by a GNU-style
+// license that can be found in the LICENSE file.
+
+package gostlink
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+type TraceDataCb func([]byte)
+
+const (
+	traceReaderTimeout    = 10 * time.Millisecond
+	traceReaderBufferSize = 64 * 1024 // default size of the buffer between usb reads and the callback
+)
+
+// Reads trace data in the background from the dedicated trace endpoint.
+// It never uses the command endpoint, so memory and register access on the
+// same handle may continue while the reader is running. Received data is
+// passed through a bounded buffer to the callback, so a callback stalling
+// for a moment does not lose data.
+type TraceReader struct {
+	handle   *StLink
+	callback TraceDataCb
+
+	stop chan struct{}
+	done chan struct{}
+	err  error
+
+	mu      sync.Mutex // protects the fields below
+	cond    *sync.Cond // signalled on new data and when reading stopped
+	ring    []byte
+	start   int
+	length  int
+	dropped uint64
+	stopped bool
+}
+
+// Start reading trace data in the background, callback is called from a
+// separate goroutine for the buffered chunks. Trace has to be configured and
+// enabled with ConfigTrace before.
+func (h *StLink) StartTraceReader(callback TraceDataCb) (*TraceReader, error) {
+	return h.StartBufferedTraceReader(callback, traceReaderBufferSize)
+}
+
+// As StartTraceReader, with a buffer of bufferSize bytes between the usb reads
+// and the callback. Data not fitting into a full buffer is dropped and counted,
+// see Dropped.
+func (h *StLink) StartBufferedTraceReader(callback TraceDataCb, bufferSize int) (*TraceReader, error) {
+	if !h.trace.enabled || !h.version.flags.Get(flagHasTrace) {
+		return nil, errors.New("trace is not enabled")
+	}
+
+	if bufferSize <= 0 {
+		return nil, errors.New("invalid trace buffer size")
+	}
+
+	r := &TraceReader{
+		handle:   h.unlocked(),
+		callback: callback,
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+		ring:     make([]byte, bufferSize),
+	}
+
+	r.cond = sync.NewCond(&r.mu)
+
+	go r.run()
+	go r.deliver()
+
+	return r, nil
+}
+
+func (r *TraceReader) run() {
+	defer func() {
+		r.mu.Lock()
+		r.stopped = true
+		r.cond.Signal()
+		r.mu.Unlock()
+	}()
+
+	buffer := make([]byte, traceSize)
+
+	for {
+		select {
+		case <-r.stop:
+			return
+		default:
+		}
+
+		bytesRead, err := usbRawReadPartial(r.handle.log(), r.handle.traceEndpoint, buffer, traceReaderTimeout)
+
+		if err != nil {
+			r.handle.log().Error("trace reader stopped: ", err)
+			r.err = err
+			return
+		}
+
+		if bytesRead > 0 {
+			r.push(buffer[:bytesRead])
+		}
+	}
+}
+
+// append data to the ring buffer, dropping what does not fit
+func (r *TraceReader) push(data []byte) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	free := len(r.ring) - r.length
+
+	if len(data) > free {
+		if r.dropped == 0 {
+			r.handle.log().Warn("trace buffer overflow, dropping trace data")
+		}
+
+		r.dropped += uint64(len(data) - free)
+		data = data[:free]
+	}
+
+	for _, b := range data {
+		r.ring[(r.start+r.length)%len(r.ring)] = b
+		r.length++
+	}
+
+	if len(data) > 0 {
+		r.cond.Signal()
+	}
+}
+
+// pass buffered data to the callback until reading stopped and the buffer is drained
+func (r *TraceReader) deliver() {
+	defer close(r.done)
+
+	for {
+		r.mu.Lock()
+
+		for r.length == 0 && !r.stopped {
+			r.cond.Wait()
+		}
+
+		if r.length == 0 {
+			r.mu.Unlock()
+			return
+		}
+
+		data := make([]byte, r.length)
+
+		for i := range data {
+			data[i] = r.ring[(r.start+i)%len(r.ring)]
+		}
+
+		r.start = (r.start + r.length) % len(r.ring)
+		r.length = 0
+
+		r.mu.Unlock()
+
+		r.callback(data)
+	}
+}
+
+// Number of trace bytes dropped because the buffer was full
+func (r *TraceReader) Dropped() uint64 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.dropped
+}
+
+// Stop the reader and wait until the buffered data was passed to the callback.
+// Returns the error which stopped the reader early, if any.
+func (r *TraceReader) Close() error {
+	select {
+	case <-r.done:
+	default:
+		close(r.stop)
+		<-r.done
+	}
+
+	return r.err
+}
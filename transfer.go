@@ -6,9 +6,16 @@ package gostlink
 
 import (
 	"errors"
+	"fmt"
 	"time"
 )
 
+const (
+	usbDrainBufferSize = 512
+	usbDrainMaxReads   = 8
+	usbDrainTimeout    = 10 * time.Millisecond
+)
+
 type transferCtx struct {
 	cmdBuf  *Buffer
 	dataBuf *Buffer
@@ -16,6 +23,7 @@ type transferCtx struct {
 	direction usbTransferDirection
 
 	cmdSize uint32
+	rxSize  int // bytes actually received on last incoming transfer
 }
 
 func (t *transferCtx) CmdBytes() []byte {
@@ -30,7 +38,7 @@ func (h *StLink) initTransfer(dir usbTransferDirection) *transferCtx {
 	ctx := &transferCtx{cmdSize: 0}
 
 	ctx.cmdBuf = NewBuffer(cmdBufferSize)
-	ctx.dataBuf = NewBuffer(dataBufferSize)
+	ctx.dataBuf = NewBuffer(int(h.maxDataStage()))
 
 	ctx.direction = dir
 
@@ -42,7 +50,7 @@ func (h *StLink) usbTransferErrCheck(ctx *transferCtx, dataLength uint32) error
 	err := h.usbTransferNoErrCheck(ctx, dataLength)
 
 	if err != nil {
-		logger.Error("during usb transfer with error check ", err)
+		h.log().Error("during usb transfer with error check ", err)
 		return err
 	}
 
@@ -53,15 +61,34 @@ func (h *StLink) usbTransferNoErrCheck(ctx *transferCtx, dataLength uint32) erro
 	ctx.cmdSize = cmdSizeV2
 
 	if h.version.stlink == 1 {
-		return errors.New("st-link V1 api commands not supported")
+		return fmt.Errorf("st-link V1 api commands not supported: %w", ErrNotSupported)
 	}
 
 	return h.usbTransferReadWrite(ctx, dataLength)
 }
 
 func (h *StLink) usbTransferReadWrite(ctx *transferCtx, dataLength uint32) error {
+	err := h.usbTransferOnce(ctx, dataLength)
+
+	if errors.Is(err, ErrDeviceSuspended) && h.config.autoReconnect && !h.reconnecting {
+		h.log().Warn("st-link lost its usb configuration, reconnecting")
+
+		if reconnectErr := h.Reconnect(); reconnectErr != nil {
+			return fmt.Errorf("%w, reconnect failed: %v", ErrDeviceSuspended, reconnectErr)
+		}
+
+		err = h.usbTransferOnce(ctx, dataLength)
+	}
 
-	_, err := usbRawWrite(h.txEndpoint, ctx.cmdBuf.Bytes()[:ctx.cmdSize])
+	return err
+}
+
+func (h *StLink) usbTransferOnce(ctx *transferCtx, dataLength uint32) error {
+	if h.state == StateDisconnected {
+		return errDisconnected
+	}
+
+	_, err := usbRawWrite(h.log(), h.txEndpoint, ctx.cmdBuf.Bytes()[:ctx.cmdSize])
 
 	if err != nil {
 		return err
@@ -71,7 +98,7 @@ func (h *StLink) usbTransferReadWrite(ctx *transferCtx, dataLength uint32) error
 
 		time.Sleep(time.Millisecond * 10)
 
-		_, err = usbRawWrite(h.txEndpoint, ctx.dataBuf.Bytes()[:dataLength])
+		_, err = usbRawWrite(h.log(), h.txEndpoint, ctx.dataBuf.Bytes()[:dataLength])
 
 		if err != nil {
 			return err
@@ -81,7 +108,7 @@ func (h *StLink) usbTransferReadWrite(ctx *transferCtx, dataLength uint32) error
 
 		readBuffer := make([]byte, dataLength)
 
-		_, err = usbRawRead(h.rxEndpoint, readBuffer)
+		ctx.rxSize, err = usbRawRead(h.log(), h.rxEndpoint, readBuffer)
 
 		if err != nil {
 			return err
@@ -93,10 +120,43 @@ func (h *StLink) usbTransferReadWrite(ctx *transferCtx, dataLength uint32) error
 	return nil
 }
 
+// discard stale data pending on the rx endpoint, e.g. left over from a previous session
+func (h *StLink) usbDrainRx() {
+	buffer := make([]byte, usbDrainBufferSize)
+
+	for i := 0; i < usbDrainMaxReads; i++ {
+		bytesRead, err := usbRawReadPartial(h.log(), h.rxEndpoint, buffer, usbDrainTimeout)
+
+		if err != nil || bytesRead <= 0 {
+			return
+		}
+
+		h.log().Debugf("drained %d stale bytes from rx endpoint", bytesRead)
+	}
+}
+
+// status check after a memory read, skipped while ReadMem defers it
+func (h *StLink) usbReadStatusCheck() error {
+	if h.batchReadStatus {
+		return nil
+	}
+
+	return h.usbGetReadWriteStatus()
+}
+
+// status check after a memory write, skipped while WriteMem defers it
+func (h *StLink) usbWriteStatusCheck() error {
+	if h.batchWriteStatus {
+		return nil
+	}
+
+	return h.usbGetReadWriteStatus()
+}
+
 func (h *StLink) usbGetReadWriteStatus() error {
 
 	if h.version.jtagApi == jTagApiV1 {
-		logger.Warn("get read write status not supported in jTag api V1")
+		h.log().Warn("get read write status not supported in jTag api V1")
 		return nil
 	}
 
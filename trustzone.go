@@ -0,0 +1,208 @@
+// Copyright 2021 juju2013@github. All rights reserved.
+// Use of this source code is governed by a GNU-style
+// license that can be found in the LICENSE file.
+
+package gostlink
+
+import (
+	"fmt"
+)
+
+// ARMv8-M security extension debug registers
+const (
+	scbIdPfr1 = 0xE000ED44
+	dcbDscsr  = 0xE000EE08
+
+	idPfr1SecurityShift = 4
+	idPfr1SecurityMask  = 0xf
+
+	dscsrSbrSelEn = 1 << 0  // select banked registers by SBRSEL instead of the current state
+	dscsrSbrSel   = 1 << 1  // debugger accesses the secure banked registers
+	dscsrCds      = 1 << 16 // core is in secure state
+	dscsrCdsKey   = 1 << 17 // writes to CDS are ignored while set
+
+	cswAhbHnonsec     = 1 << 30
+	cswAhbMasterDebug = 1 << 29
+	cswAhbHprotPriv   = 1 << 25
+)
+
+// register indexes of the stack pointers of both security states, the limits
+// are found with the other ARMv8-M registers
+const (
+	registerMainSPNonSecure    = 0x18
+	registerProcessSPNonSecure = 0x19
+	registerMainSPSecure       = 0x1A
+	registerProcessSPSecure    = 0x1B
+)
+
+// Security state of an ARMv8-M core with TrustZone
+type SecurityState int
+
+const (
+	SecurityStateSecure SecurityState = iota
+	SecurityStateNonSecure
+)
+
+func (s SecurityState) String() string {
+	if s == SecurityStateNonSecure {
+		return "non-secure"
+	}
+
+	return "secure"
+}
+
+// Stack pointers and their limits of one security state
+type BankedStackPointers struct {
+	MainSP         uint32
+	ProcessSP      uint32
+	MainSPLimit    uint32
+	ProcessSPLimit uint32
+}
+
+// check ID_PFR1 of an ARMv8-M core for the security extension
+func (h *StLink) hasSecurityExtension() (bool, error) {
+	v8m, err := h.isArmV8M()
+
+	if err != nil || !v8m {
+		return false, err
+	}
+
+	pfr1, err := h.readDebugReg(scbIdPfr1)
+
+	if err != nil {
+		return false, err
+	}
+
+	return (pfr1>>idPfr1SecurityShift)&idPfr1SecurityMask != 0, nil
+}
+
+// fail with ErrNotSupported unless the core implements the security extension
+func (h *StLink) requireSecurityExtension() error {
+	security, err := h.hasSecurityExtension()
+
+	if err != nil {
+		return err
+	}
+
+	if !security {
+		return fmt.Errorf("core does not implement the security extension: %w", ErrNotSupported)
+	}
+
+	return nil
+}
+
+// Read the security state the core is currently in from DSCSR.CDS
+func (h *StLink) ReadSecurityState() (SecurityState, error) {
+	h, unlock := h.lock()
+	defer unlock()
+
+	if err := h.UsbModeEnter(h.stMode); err != nil {
+		return SecurityStateSecure, err
+	}
+	defer h.UsbLeaveMode(h.stMode)
+
+	if err := h.requireSecurityExtension(); err != nil {
+		return SecurityStateSecure, err
+	}
+
+	dscsr, err := h.readDebugReg(dcbDscsr)
+
+	if err != nil {
+		return SecurityStateSecure, err
+	}
+
+	if (dscsr & dscsrCds) > 0 {
+		return SecurityStateSecure, nil
+	}
+
+	return SecurityStateNonSecure, nil
+}
+
+// Select the security state whose view is used by following accesses: the
+// banked system control registers through DSCSR.SBRSEL and memory accesses
+// through the HNONSEC bit of the access port CSW. Non-secure memory access
+// requires st-link firmware supporting CSW, the setting is reset by SetActiveAP.
+// The security state the core executes in is not changed.
+func (h *StLink) SetAccessDomain(state SecurityState) error {
+	h, unlock := h.lock()
+	defer unlock()
+
+	if err := h.UsbModeEnter(h.stMode); err != nil {
+		return err
+	}
+	defer h.UsbLeaveMode(h.stMode)
+
+	if err := h.requireSecurityExtension(); err != nil {
+		return err
+	}
+
+	if state == SecurityStateNonSecure && !h.version.flags.Get(flagHasCsw) {
+		return fmt.Errorf("st-link firmware does not support non-secure memory access: %w", ErrNotSupported)
+	}
+
+	dscsr, err := h.readDebugReg(dcbDscsr)
+
+	if err != nil {
+		return err
+	}
+
+	dscsr = (dscsr &^ dscsrSbrSel) | dscsrSbrSelEn | dscsrCdsKey
+
+	if state == SecurityStateSecure {
+		dscsr |= dscsrSbrSel
+	}
+
+	if err = h.writeDebugReg(dcbDscsr, dscsr); err != nil {
+		return err
+	}
+
+	if state == SecurityStateNonSecure {
+		h.activeApCsw = cswDbgSwEnable | cswAhbHnonsec | cswAhbMasterDebug | cswAhbHprotPriv
+	} else {
+		h.activeApCsw = h.detectApCsw(h.activeAp)
+	}
+
+	h.log().Debugf("accessing the %s state", state)
+	return nil
+}
+
+// Read the stack pointers and stack limits banked for the given security
+// state, fails with ErrNotSupported without security extension
+func (h *StLink) ReadBankedStackPointers(state SecurityState) (*BankedStackPointers, error) {
+	h, unlock := h.lock()
+	defer unlock()
+
+	if err := h.UsbModeEnter(h.stMode); err != nil {
+		return nil, err
+	}
+	defer h.UsbLeaveMode(h.stMode)
+
+	if err := h.requireSecurityExtension(); err != nil {
+		return nil, err
+	}
+
+	if err := h.requireHalted(); err != nil {
+		return nil, err
+	}
+
+	indexes := []uint8{registerMainSPSecure, registerProcessSPSecure, registerMainSPLimitSecure, registerProcessSPLimitSecure}
+
+	if state == SecurityStateNonSecure {
+		indexes = []uint8{registerMainSPNonSecure, registerProcessSPNonSecure,
+			registerMainSPLimitNonSecure, registerProcessSPLimitNonSecure}
+	}
+
+	values := make([]uint32, len(indexes))
+
+	for i, index := range indexes {
+		value, err := h.readRegister(index)
+
+		if err != nil {
+			return nil, err
+		}
+
+		values[i] = value
+	}
+
+	return &BankedStackPointers{MainSP: values[0], ProcessSP: values[1], MainSPLimit: values[2], ProcessSPLimit: values[3]}, nil
+}
@@ -41,10 +41,56 @@ func CloseUSB() {
 	}
 }
 
-func usbFindDevices(vids []gousb.ID, pids []gousb.ID) ([]*gousb.Device, error) {
+// Usb descriptor information of a connected st-link
+type StLinkDeviceInfo struct {
+	Vid          gousb.ID
+	Pid          gousb.ID
+	Bus          int
+	Address      int
+	Serial       string
+	Product      string
+	Manufacturer string
+}
+
+// List all connected st-links by reading their usb descriptors only. No interface
+// is claimed and no command is sent, so a probe used by another debugger is not disturbed.
+func ListDevices() ([]*StLinkDeviceInfo, error) {
+	if libUsbCtx == nil {
+		return nil, errors.New("libusb context not initialized")
+	}
+
+	devices, err := usbFindDevices(handleLog{}, goStLinkSupportedVIds, goStLinkSupportedPIds)
+
+	if err != nil {
+		return nil, err
+	}
+
+	infos := make([]*StLinkDeviceInfo, 0, len(devices))
+
+	for _, dev := range devices {
+		info := &StLinkDeviceInfo{
+			Vid:     dev.Desc.Vendor,
+			Pid:     dev.Desc.Product,
+			Bus:     dev.Desc.Bus,
+			Address: dev.Desc.Address,
+		}
+
+		info.Serial, _ = dev.SerialNumber()
+		info.Product, _ = dev.Product()
+		info.Manufacturer, _ = dev.Manufacturer()
+
+		dev.Close()
+
+		infos = append(infos, info)
+	}
+
+	return infos, nil
+}
+
+func usbFindDevices(log handleLog, vids []gousb.ID, pids []gousb.ID) ([]*gousb.Device, error) {
 	devices, err := libUsbCtx.OpenDevices(func(desc *gousb.DeviceDesc) bool {
 		if idExists(vids, desc.Vendor) == true && idExists(pids, desc.Product) == true {
-			logger.Debugf("inspecting usb device [%04x:%04x] on bus %03d:%03d...", uint16(desc.Vendor), uint16(desc.Product), desc.Bus, desc.Address)
+			log.Debugf("inspecting usb device [%04x:%04x] on bus %03d:%03d...", uint16(desc.Vendor), uint16(desc.Product), desc.Bus, desc.Address)
 
 			return true
 		} else {
@@ -64,7 +110,7 @@ func usbFindDevices(vids []gousb.ID, pids []gousb.ID) ([]*gousb.Device, error) {
 	}
 }
 
-func usbRawWrite(endpoint *gousb.OutEndpoint, buffer []byte) (int, error) {
+func usbRawWrite(log handleLog, endpoint *gousb.OutEndpoint, buffer []byte) (int, error) {
 
 	opCtx := context.Background()
 
@@ -75,15 +121,15 @@ func usbRawWrite(endpoint *gousb.OutEndpoint, buffer []byte) (int, error) {
 	bytesWritten, err := endpoint.WriteContext(opCtx, buffer)
 
 	if err != nil {
-		return -1, err
+		return -1, usbMapError(err)
 	} else {
-		logger.Tracef("%d Bytes -> EP-%d", bytesWritten, endpoint.Desc.Number)
+		log.Tracef("%d Bytes -> EP-%d", bytesWritten, endpoint.Desc.Number)
 		return bytesWritten, nil
 	}
 
 }
 
-func usbRawRead(endpoint *gousb.InEndpoint, buffer []byte) (int, error) {
+func usbRawRead(log handleLog, endpoint *gousb.InEndpoint, buffer []byte) (int, error) {
 	opCtx := context.Background()
 
 	var done func()
@@ -93,14 +139,30 @@ func usbRawRead(endpoint *gousb.InEndpoint, buffer []byte) (int, error) {
 	bytesRead, err := endpoint.ReadContext(opCtx, buffer)
 
 	if err != nil {
-		return -1, err
+		return -1, usbMapError(err)
 	} else {
-		logger.Tracef("EP-%d -> %d Bytes", endpoint.Desc.Number, bytesRead)
+		log.Tracef("EP-%d -> %d Bytes", endpoint.Desc.Number, bytesRead)
 		return bytesRead, nil
 	}
 }
 
-func (h *StLink) maxBlockSize(tarAutoIncrBlock uint32, address uint32) uint32 {
+// Read from endpoint until the buffer is filled or timeout elapsed. Running
+// into the timeout is not an error, the amount of bytes received is returned.
+func usbRawReadPartial(log handleLog, endpoint *gousb.InEndpoint, buffer []byte, timeout time.Duration) (int, error) {
+	opCtx, done := context.WithTimeout(context.Background(), timeout)
+	defer done()
+
+	bytesRead, err := endpoint.ReadContext(opCtx, buffer)
+
+	if err != nil && err != gousb.TransferCancelled {
+		return bytesRead, usbMapError(err)
+	}
+
+	log.Tracef("EP-%d -> %d Bytes", endpoint.Desc.Number, bytesRead)
+	return bytesRead, nil
+}
+
+func maxBlockSize(tarAutoIncrBlock uint32, address uint32) uint32 {
 	var maxTarBlock = tarAutoIncrBlock - ((tarAutoIncrBlock - 1) & address)
 
 	if maxTarBlock == 0 {
@@ -110,6 +172,14 @@ func (h *StLink) maxBlockSize(tarAutoIncrBlock uint32, address uint32) uint32 {
 	return maxTarBlock
 }
 
+// largest data stage of a single 16 or 32bit memory transfer
+func (h *StLink) maxDataStage() uint32 {
+	if h.dataBufSize > 0 {
+		return h.dataBufSize
+	}
+	return dataBufferSize
+}
+
 func (h *StLink) usbBlock() uint32 {
 	if h.version.flags.Get(flagHasRw8Bytes512) {
 		return v3MaxReadWrite8
@@ -0,0 +1,130 @@
+// Copyright 2021 juju2013@github. All rights reserved.
+// Use of this source code is governed by a GNU-style
+// license that can be found in the LICENSE file.
+
+package gostlink
+
+import (
+	"errors"
+	"fmt"
+)
+
+const (
+	usbRequestGetDescriptor = 0x06
+	usbDescriptorDevice     = 0x01
+	usbDescriptorString     = 0x03
+	usbDeviceToHost         = 0x80
+
+	usbDeviceSerialIndex  = 16 // iSerialNumber in the device descriptor
+	stLinkBinarySerialLen = 12 // bytes of the binary serial of old firmware
+)
+
+// Usb interfaces of a virtual com port provided by the st-link. The port is
+// served by the host's cdc acm driver and can be opened in parallel to the
+// debug interface, e.g. as /dev/serial/by-id/usb-STMicroelectronics_*_<serial>-if<ControlInterface>
+type VcpInfo struct {
+	ControlInterface int // cdc communication interface
+	DataInterface    int // cdc data interface
+}
+
+func usbHasVcp(pid uint16) bool {
+	switch pid {
+	case stLinkV21Pid, stLinkV21NoMsdPid, stLinkV3EPid, stLinkV3SPid, stLinkV32VcpPid, stLinkV3PwrPid:
+		return true
+	default:
+		return false
+	}
+}
+
+// Serial number of the opened st-link
+func (h *StLink) SerialNumber() string {
+	h, unlock := h.lock()
+	defer unlock()
+
+	return h.serial
+}
+
+// Serial number in the form shown by ST tools and OpenOCD. Old ST-LINK/V2
+// firmware reports its 12 byte serial as binary characters in the string
+// descriptor, which libusb mangles, these are hex encoded like ST tools do.
+func (h *StLink) CanonicalSerialNumber() (string, error) {
+	h, unlock := h.lock()
+	defer unlock()
+
+	if h.libUsbDevice == nil {
+		return "", fmt.Errorf("st-link is closed: %w", ErrDeviceNotFound)
+	}
+
+	device := make([]byte, 18)
+
+	if _, err := h.libUsbDevice.Control(usbDeviceToHost, usbRequestGetDescriptor, usbDescriptorDevice<<8, 0, device); err != nil {
+		return "", usbMapError(err)
+	}
+
+	index := device[usbDeviceSerialIndex]
+
+	if index == 0 {
+		return "", errors.New("st-link has no serial number")
+	}
+
+	langIds := make([]byte, 4)
+
+	if _, err := h.libUsbDevice.Control(usbDeviceToHost, usbRequestGetDescriptor, usbDescriptorString<<8, 0, langIds); err != nil {
+		return "", usbMapError(err)
+	}
+
+	desc := make([]byte, 255)
+	n, err := h.libUsbDevice.Control(usbDeviceToHost, usbRequestGetDescriptor, usbDescriptorString<<8|uint16(index),
+		convertToUint16(langIds[2:], littleEndian), desc)
+
+	if err != nil {
+		return "", usbMapError(err)
+	}
+
+	if n < 2 || int(desc[0]) > n {
+		return "", fmt.Errorf("short serial number descriptor of %d bytes", n)
+	}
+
+	// utf-16 characters, the serial is ascii or binary in the low bytes
+	chars := make([]byte, 0, (desc[0]-2)/2)
+
+	for i := 2; i+1 < int(desc[0]); i += 2 {
+		chars = append(chars, desc[i])
+	}
+
+	if len(chars) == stLinkBinarySerialLen {
+		return fmt.Sprintf("%X", chars), nil
+	}
+
+	return string(chars), nil
+}
+
+// List the virtual com ports of the st-link, V3 probes may provide two of them
+func (h *StLink) VcpInterfaces() []VcpInfo {
+	h, unlock := h.lock()
+	defer unlock()
+
+	var vcps []VcpInfo
+
+	if h.libUsbConfig == nil {
+		return vcps
+	}
+
+	interfaces := h.libUsbConfig.Desc.Interfaces
+
+	for i, iface := range interfaces {
+		if len(iface.AltSettings) == 0 || iface.AltSettings[0].Class != usbClassCdcComm {
+			continue
+		}
+
+		// the data interface follows its communication interface
+		for _, data := range interfaces[i+1:] {
+			if len(data.AltSettings) > 0 && data.AltSettings[0].Class == usbClassCdcData {
+				vcps = append(vcps, VcpInfo{ControlInterface: iface.Number, DataInterface: data.Number})
+				break
+			}
+		}
+	}
+
+	return vcps
+}
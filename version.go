@@ -11,48 +11,127 @@ import (
 	"github.com/google/gousb"
 )
 
-func (h *StLink) useParseVersion() error {
-	var v, x, y, jtag, swim, msd, bridge byte = 0, 0, 0, 0, 0, 0, 0
+// Firmware version of the st-link as reported by the version commands
+type VersionInfo struct {
+	StLink int // hardware generation, 1 to 3
+	Jtag   int // debug (jtag/swd) firmware version
+	Swim   int // swim firmware version, 0 if not supported
+	Msd    int // mass storage firmware version, 0 if not present
+	Bridge int // bridge firmware version (STLINK-V3 only), 0 if not present
+
+	Vid gousb.ID
+	Pid gousb.ID
+}
 
-	ctx := h.initTransfer(transferIncoming)
+// Version string in the notation used by ST, e.g. V2J37S7 or V3J7M3B5S1
+func (v VersionInfo) String() string {
+	vStr := fmt.Sprintf("V%d", v.StLink)
 
-	ctx.cmdBuf.WriteByte(cmdGetVersion)
+	if v.Jtag > 0 || v.Msd == 0 {
+		vStr += fmt.Sprintf("J%d", v.Jtag)
+	}
 
-	err := h.usbTransferNoErrCheck(ctx, 6)
+	if v.Msd > 0 {
+		vStr += fmt.Sprintf("M%d", v.Msd)
+	}
 
-	if err != nil {
-		return err
+	if v.Bridge > 0 {
+		vStr += fmt.Sprintf("B%d", v.Bridge)
+	}
+
+	if v.Swim > 0 || v.Msd == 0 {
+		vStr += fmt.Sprintf("S%d", v.Swim)
+	}
+
+	return vStr
+}
+
+// Firmware version read when the handle was opened
+func (h *StLink) Version() VersionInfo {
+	h, unlock := h.lock()
+	defer unlock()
+
+	return h.version.info
+}
+
+// Decode the 6 byte response of the get version command. The version word is big
+// endian (4 bits generation, 6 bits each for the two firmware components), vid and
+// pid follow little endian. Which components the two fields describe depends on
+// the product, STLINK-V3 reports 0 for both and needs decodeVersionEx.
+func decodeVersion(data []byte) (VersionInfo, error) {
+	info := VersionInfo{}
+
+	if len(data) < 6 {
+		return info, fmt.Errorf("short version response of %d bytes", len(data))
 	}
 
-	version := ctx.dataBuf.ReadUint16BE()
+	version := convertToUint16(data[0:2], bigEndian)
 
-	v = byte((version >> 12) & 0x0f)
-	x = byte((version >> 6) & 0x3f)
-	y = byte(version & 0x3f)
+	info.StLink = int((version >> 12) & 0x0f)
+	x := int((version >> 6) & 0x3f)
+	y := int(version & 0x3f)
 
-	h.vid = gousb.ID(ctx.dataBuf.ReadUint16LE())
-	h.pid = gousb.ID(ctx.dataBuf.ReadUint16BE())
+	info.Vid = gousb.ID(convertToUint16(data[2:4], littleEndian))
+	info.Pid = gousb.ID(convertToUint16(data[4:6], littleEndian))
 
-	switch h.pid {
+	switch info.Pid {
 	case stLinkV21Pid, stLinkV21NoMsdPid:
 		if (x <= 22 && y == 7) || (x >= 25 && y >= 7 && y <= 12) {
-			msd = x
-			swim = y
-			jtag = 0
+			info.Msd = x
+			info.Swim = y
 		} else {
-			jtag = x
-			msd = y
-			swim = 0
+			info.Jtag = x
+			info.Msd = y
 		}
 
 	default:
-		jtag = x
-		msd = 0
-		swim = y
+		info.Jtag = x
+		info.Swim = y
+	}
+
+	return info, nil
+}
+
+// Decode the 12 byte response of the STLINK-V3 extended version command:
+// generation, swim, jtag, msd and bridge version bytes, 3 reserved bytes,
+// then vid and pid little endian.
+func decodeVersionEx(data []byte) (VersionInfo, error) {
+	info := VersionInfo{}
+
+	if len(data) < 12 {
+		return info, fmt.Errorf("short extended version response of %d bytes", len(data))
+	}
+
+	info.StLink = int(data[0])
+	info.Swim = int(data[1])
+	info.Jtag = int(data[2])
+	info.Msd = int(data[3])
+	info.Bridge = int(data[4])
+	info.Vid = gousb.ID(convertToUint16(data[8:10], littleEndian))
+	info.Pid = gousb.ID(convertToUint16(data[10:12], littleEndian))
+
+	return info, nil
+}
+
+func (h *StLink) useParseVersion() error {
+	ctx := h.initTransfer(transferIncoming)
+
+	ctx.cmdBuf.WriteByte(cmdGetVersion)
+
+	err := h.usbTransferNoErrCheck(ctx, 6)
+
+	if err != nil {
+		return err
+	}
+
+	info, err := decodeVersion(ctx.DataBytes())
+
+	if err != nil {
+		return err
 	}
 
 	/* STLINK-V3 requires a specific command */
-	if v == 3 && x == 0 && y == 0 {
+	if info.StLink == 3 && info.Jtag == 0 && info.Swim == 0 {
 		ctxV3 := h.initTransfer(transferIncoming)
 
 		ctxV3.cmdBuf.WriteByte(debugApiV3GetVersionEx)
@@ -63,19 +142,18 @@ func (h *StLink) useParseVersion() error {
 			return err
 		}
 
-		v       = ctxV3.dataBuf.Next(1)[0]
-		swim    = ctxV3.dataBuf.Next(1)[0]
-		jtag    = ctxV3.dataBuf.Next(1)[0]
-		msd     = ctxV3.dataBuf.Next(1)[0]
-		bridge  = ctxV3.dataBuf.Next(1)[0]
-    ctxV3.dataBuf.Next(3)
-		h.vid = gousb.ID(ctxV3.dataBuf.ReadUint16LE())
-		h.pid = gousb.ID(ctxV3.dataBuf.ReadUint16LE())
+		if info, err = decodeVersionEx(ctxV3.DataBytes()); err != nil {
+			return err
+		}
 	}
 
-	h.version.stlink = int(v)
-	h.version.jtag = int(jtag)
-	h.version.swim = int(swim)
+	h.vid = info.Vid
+	h.pid = info.Pid
+	h.version.info = info
+
+	h.version.stlink = info.StLink
+	h.version.jtag = info.Jtag
+	h.version.swim = info.Swim
 
 	var flags bitmap.Bitmap = bitmap.New(32)
 
@@ -135,8 +213,11 @@ func (h *StLink) useParseVersion() error {
 		}
 
 		/* Banked regs (DPv1 & DPv2) support from V2J32 */
+		/* Memory R/W supports CSW and AP selection from V2J32 */
 		if h.version.jtag >= 32 {
 			flags.Set(flagHasDpBankSel, true)
+			flags.Set(flagHasCsw, true)
+			flags.Set(flagHasMemRdNoInc, true)
 		}
 	case 3:
 		/* all STLINK-V3 use api-v3 */
@@ -152,6 +233,11 @@ func (h *StLink) useParseVersion() error {
 
 		if h.version.jtag >= 2 {
 			flags.Set(flagHasDpBankSel, true) // Banked regs (DPv1 & DPv2) support from V3J2
+			flags.Set(flagHasCsw, true)       // Memory R/W supports CSW and AP selection from V3J2
+		}
+
+		if h.version.jtag >= 3 {
+			flags.Set(flagHasMemRdNoInc, true) // read memory without address increment from V3J3
 		}
 
 		if h.version.jtag >= 6 {
@@ -164,23 +250,54 @@ func (h *StLink) useParseVersion() error {
 
 	h.version.flags = flags
 
-	var vStr string = fmt.Sprintf("V%d", v)
-
-	if jtag > 0 || msd != 0 {
-		vStr += fmt.Sprintf("J%d", jtag)
-	}
-
-	if msd > 0 {
-		vStr += fmt.Sprintf("M%d", msd)
-	}
-
-	if bridge > 0 {
-		vStr += fmt.Sprintf("B%d", bridge)
-	}
-
 	serialNo, _ := h.libUsbDevice.SerialNumber()
 
-	logger.Debugf("parsed st-link version [%s] for [%s]", vStr, serialNo)
+	h.log().Debugf("parsed st-link version [%s] for [%s]", info, serialNo)
 
 	return nil
 }
+
+// Operations supported by the connected st-link, derived from the firmware version
+// flags. Each entry names the commands it gates.
+type Capabilities struct {
+	Mem16Bit      bool // READMEM_16BIT/WRITEMEM_16BIT, otherwise 16bit accesses fall back to 8bit
+	Trace         bool // START/STOP_TRACE_RX and GET_TRACE_NB, see ConfigTrace
+	TargetVoltage bool // GET_TARGET_VOLTAGE, see GetTargetVoltage
+	ApInit        bool // INIT_AP/CLOSE_AP_DBG, access ports other than 0
+	SwdSetFreq    bool // SWD_SET_FREQ (V2 clock divisor)
+	JtagSetFreq   bool // JTAG_SET_FREQ (V2 clock divisor)
+	V3FreqMap     bool // GET_COM_FREQ/SET_COM_FREQ, the V3 frequency table
+	DapRegisters  bool // READ_DAP_REG/WRITE_DAP_REG, raw DP and AP access
+	LastRwStatus2 bool // GETLASTRWSTATUS2, status with the fault address
+	DpBankSel     bool // banked DP registers (DPv1 & DPv2)
+	Csw           bool // memory accesses with CSW and AP selection
+	MemReadNoInc  bool // memory reads without address increment
+	Rw8Bytes512   bool // 8bit memory transfers of up to 512 bytes
+	Swim          bool // SWIM mode for STM8 targets
+}
+
+// Capabilities of the connected st-link, so unsupported features can be
+// disabled up front instead of failing with ErrNotSupported.
+func (h *StLink) CapabilityMatrix() Capabilities {
+	h, unlock := h.lock()
+	defer unlock()
+
+	flags := h.version.flags
+
+	return Capabilities{
+		Mem16Bit:      flags.Get(flagHasMem16Bit),
+		Trace:         flags.Get(flagHasTrace),
+		TargetVoltage: flags.Get(flagHasTargetVolt),
+		ApInit:        flags.Get(flagHasApInit),
+		SwdSetFreq:    flags.Get(flagHasSwdSetFreq) || h.version.jtagApi == jTagApiV3,
+		JtagSetFreq:   flags.Get(flagHasJtagSetFreq) || h.version.jtagApi == jTagApiV3,
+		V3FreqMap:     h.version.jtagApi == jTagApiV3,
+		DapRegisters:  flags.Get(flagHasDapReg),
+		LastRwStatus2: flags.Get(flagHasGetLastRwStatus2),
+		DpBankSel:     flags.Get(flagHasDpBankSel),
+		Csw:           flags.Get(flagHasCsw),
+		MemReadNoInc:  flags.Get(flagHasMemRdNoInc),
+		Rw8Bytes512:   flags.Get(flagHasRw8Bytes512),
+		Swim:          h.version.swim > 0,
+	}
+}
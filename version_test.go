@@ -0,0 +1,73 @@
+// Copyright 2021 juju2013@github. All rights reserved.
+// Use of this source code is governed by a GNU-style
+// license that can be found in the LICENSE file.
+
+package gostlink
+
+import (
+	"testing"
+)
+
+func TestDecodeVersion(t *testing.T) {
+	tests := []struct {
+		name     string
+		data     []byte
+		expected VersionInfo
+		str      string
+	}{
+		{"V2", []byte{0x29, 0x47, 0x83, 0x04, 0x48, 0x37},
+			VersionInfo{StLink: 2, Jtag: 37, Swim: 7, Vid: 0x0483, Pid: stLinkV2Pid}, "V2J37S7"},
+		{"V2-1 jtag and msd", []byte{0x29, 0x5a, 0x83, 0x04, 0x4b, 0x37},
+			VersionInfo{StLink: 2, Jtag: 37, Msd: 26, Vid: 0x0483, Pid: stLinkV21Pid}, "V2J37M26"},
+		{"V2-1 msd and swim", []byte{0x26, 0x47, 0x83, 0x04, 0x4b, 0x37},
+			VersionInfo{StLink: 2, Msd: 25, Swim: 7, Vid: 0x0483, Pid: stLinkV21Pid}, "V2M25S7"},
+		{"V2-1 without msd", []byte{0x29, 0x5a, 0x83, 0x04, 0x52, 0x37},
+			VersionInfo{StLink: 2, Jtag: 37, Msd: 26, Vid: 0x0483, Pid: stLinkV21NoMsdPid}, "V2J37M26"},
+		{"V3 needs the extended command", []byte{0x30, 0x00, 0x83, 0x04, 0x4f, 0x37},
+			VersionInfo{StLink: 3, Vid: 0x0483, Pid: stLinkV3SPid}, "V3J0S0"},
+	}
+
+	for _, test := range tests {
+		info, err := decodeVersion(test.data)
+
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+
+		if info != test.expected {
+			t.Errorf("%s: got %+v, expected %+v", test.name, info, test.expected)
+		}
+
+		if info.String() != test.str {
+			t.Errorf("%s: got version string %s, expected %s", test.name, info, test.str)
+		}
+	}
+
+	if _, err := decodeVersion([]byte{0x29, 0x47, 0x83, 0x04, 0x48}); err == nil {
+		t.Error("short response was accepted")
+	}
+}
+
+func TestDecodeVersionEx(t *testing.T) {
+	data := []byte{0x03, 0x01, 0x07, 0x03, 0x05, 0x00, 0x00, 0x00, 0x83, 0x04, 0x4f, 0x37}
+	expected := VersionInfo{StLink: 3, Swim: 1, Jtag: 7, Msd: 3, Bridge: 5, Vid: 0x0483, Pid: stLinkV3SPid}
+
+	info, err := decodeVersionEx(data)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if info != expected {
+		t.Errorf("got %+v, expected %+v", info, expected)
+	}
+
+	if info.String() != "V3J7M3B5S1" {
+		t.Errorf("got version string %s, expected V3J7M3B5S1", info)
+	}
+
+	if _, err := decodeVersionEx(data[:11]); err == nil {
+		t.Error("short response was accepted")
+	}
+}
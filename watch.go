@@ -0,0 +1,148 @@
+// Copyright 2021 juju2013@github. All rights reserved.
+// Use of this source code is governed by a GNU-style
+// license that can be found in the LICENSE file.
+
+package gostlink
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+const memPollInterval = 10 * time.Millisecond // interval of WaitForMemValue
+
+type MemoryDataCb func([]byte)
+
+type MemoryErrorCb func(error)
+
+// Poll length bytes at addr every interval in the background and pass a fresh
+// copy of the data to cb. A failed read is passed to errCb (or logged if errCb
+// is nil) and polling goes on with the next tick. Both callbacks are called from
+// the watcher goroutine. The returned function stops the watcher and waits for
+// a running callback to return, it may be called several times.
+func (h *StLink) WatchMemory(addr uint32, length uint32, interval time.Duration,
+	cb MemoryDataCb, errCb MemoryErrorCb) (func(), error) {
+
+	if length == 0 {
+		return nil, errors.New("invalid watch length")
+	}
+
+	if interval <= 0 {
+		return nil, errors.New("invalid watch interval")
+	}
+
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	h = h.unlocked()
+
+	go func() {
+		defer close(done)
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			data, err := h.readRegion(addr, length)
+
+			if err != nil {
+				if errCb != nil {
+					errCb(err)
+				} else {
+					h.log().Errorf("memory watch at 0x%08x failed: %v", addr, err)
+				}
+			} else {
+				cb(data)
+			}
+
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+
+	var once sync.Once
+
+	cancel := func() {
+		once.Do(func() { close(stop) })
+		<-done
+	}
+
+	return cancel, nil
+}
+
+// Poll the 32bit word at addr until (value & mask) == expected,
+// e.g. to wait for a handshake flag set by the firmware. The word is read in
+// the data endianness of the target.
+func (h *StLink) WaitForMemValue(addr uint32, mask uint32, expected uint32, timeout time.Duration) error {
+	h, unlock := h.lock()
+	defer unlock()
+
+	if addr%4 != 0 {
+		return fmt.Errorf("wait on 0x%08x: %w", addr, ErrUnalignedAccess)
+	}
+
+	read := func() (uint32, error) {
+		return h.ReadUint32(addr, h.targetEndian)
+	}
+
+	return waitForValue(read, mask, expected, timeout, memPollInterval,
+		func(value uint32) string { return fmt.Sprintf("0x%08x reads 0x%08x", addr, value) })
+}
+
+// call read every interval until (value & mask) == expected or timeout elapsed,
+// describe names the last value in the timeout error
+func waitForValue(read func() (uint32, error), mask uint32, expected uint32,
+	timeout time.Duration, interval time.Duration, describe func(uint32) string) error {
+
+	deadline := time.Now().Add(timeout)
+
+	for {
+		value, err := read()
+
+		if err != nil {
+			return err
+		}
+
+		if (value & mask) == expected {
+			return nil
+		}
+
+		if time.Now().After(deadline) {
+			return fmt.Errorf("%s, waiting for 0x%08x (mask 0x%08x): %w",
+				describe(value), expected, mask, ErrTimeout)
+		}
+
+		time.Sleep(interval)
+	}
+}
+
+// Read a region with the widest access its alignment allows
+func (h *StLink) readRegion(addr uint32, length uint32) ([]byte, error) {
+	buffer := bytes.NewBuffer([]byte{})
+
+	var err error
+
+	switch {
+	case (addr%4) == 0 && (length%4) == 0:
+		err = h.ReadMem(addr, Memory32BitBlock, length/4, buffer)
+	case (addr%2) == 0 && (length%2) == 0:
+		err = h.ReadMem(addr, Memory16BitBlock, length/2, buffer)
+	default:
+		err = h.ReadMem(addr, Memory8BitBlock, length, buffer)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	if uint32(buffer.Len()) < length {
+		return nil, fmt.Errorf("short read of %d bytes", buffer.Len())
+	}
+
+	return buffer.Bytes()[:length], nil
+}
@@ -0,0 +1,77 @@
+// Copyright 2021 juju2013@github. All rights reserved.
+// Use of this source code is governed by a GNU-style
+// license that can be found in the LICENSE file.
+
+package gostlink
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+)
+
+// reader returning the given values in turn, repeating the last one
+func fakeReader(values ...uint32) (func() (uint32, error), *int) {
+	reads := 0
+
+	return func() (uint32, error) {
+		value := values[len(values)-1]
+
+		if reads < len(values) {
+			value = values[reads]
+		}
+
+		reads++
+		return value, nil
+	}, &reads
+}
+
+func describeValue(value uint32) string {
+	return fmt.Sprintf("reads 0x%08x", value)
+}
+
+func TestWaitForValue(t *testing.T) {
+	tests := []struct {
+		name     string
+		values   []uint32
+		mask     uint32
+		expected uint32
+		reads    int
+		timeout  bool
+	}{
+		{"match at once", []uint32{0x1}, 0xffffffff, 0x1, 1, false},
+		{"match after polling", []uint32{0x0, 0x0, 0x1}, 0xffffffff, 0x1, 3, false},
+		{"masked match", []uint32{0x00, 0xf0, 0xf4}, 0x04, 0x04, 3, false},
+		{"masked bits ignored", []uint32{0xabcd0001}, 0x0000ffff, 0x0001, 1, false},
+		{"cleared flag", []uint32{0x81, 0x80}, 0x01, 0x00, 2, false},
+		{"timeout", []uint32{0x0}, 0xffffffff, 0x1, 0, true},
+	}
+
+	for _, test := range tests {
+		read, reads := fakeReader(test.values...)
+		err := waitForValue(read, test.mask, test.expected, 20*time.Millisecond, time.Millisecond, describeValue)
+
+		if test.timeout {
+			if !errors.Is(err, ErrTimeout) {
+				t.Errorf("%s: expected a timeout, got %v", test.name, err)
+			}
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+		} else if *reads != test.reads {
+			t.Errorf("%s: matched after %d reads, expected %d", test.name, *reads, test.reads)
+		}
+	}
+}
+
+func TestWaitForValueReadError(t *testing.T) {
+	readErr := errors.New("read failed")
+	read := func() (uint32, error) { return 0, readErr }
+
+	if err := waitForValue(read, 0x1, 0x1, time.Second, time.Millisecond, describeValue); err != readErr {
+		t.Errorf("expected the read error, got %v", err)
+	}
+}